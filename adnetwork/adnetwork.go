@@ -3,6 +3,7 @@ package adnetwork
 import (
 	"fmt"
 	"math/rand"
+	"sort"
 	"strconv"
 
 	"github.com/pquerna/ffjson/ffjson"
@@ -12,9 +13,19 @@ import (
 type SDK struct {
 	Provider string  `json:"provider"`
 	Score    float64 `json:"score"`
+	// Settings are joined from provider metadata when lists are served, they are not part of datasets.
+	Settings *Settings `json:"settings,omitempty"`
 	// To add other necessary fields add them here
 }
 
+// Settings configure the mediation SDK adapter of a provider.
+type Settings struct {
+	PlacementID    string `json:"placementId,omitempty"`
+	AdUnitID       string `json:"adUnitId,omitempty"`
+	AdapterVersion string `json:"adapterVersion,omitempty"`
+	TimeoutMs      int    `json:"timeoutMs,omitempty"`
+}
+
 // used in simulating the pipeline ...
 var sdks = []string{
 	"AdMob", "AdMob-OptOut", "UnityAds", "Facebook",
@@ -24,7 +35,7 @@ var sdks = []string{
 }
 
 var countries = []string{
-	"EN", "ES", "HM", "DO", "GG", "SV", "TN", "IN", "PM", "AE", "SA", "LS", "VA", "ST", "TM", "KM",
+	"DE", "ES", "HM", "DO", "GG", "SV", "TN", "IN", "PM", "AE", "SA", "LS", "VA", "ST", "TM", "KM",
 	"CG", "SY", "CR", "MT", "SN", "AF", "BH", "AO", "CK", "IM", "CY", "AG", "AL", "UM", "SR", "TG",
 	"TO", "TH", "NO", "FM", "BY", "HN", "ER", "SX", "IQ", "IE", "MO", "VN", "MH", "MZ", "SI", "DJ",
 	"BW", "AR", "TK", "ML", "NZ", "LK", "PN", "BZ", "NE", "LB", "LR", "DM", "SG", "DK", "GB", "HK",
@@ -46,13 +57,23 @@ func (s ScoreSorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 // Reverse the condition in Less to 'greater' to reverse order of sorting(left < right => left > right).
 func (s ScoreSorter) Less(i, j int) bool { return s[i].Score > s[j].Score }
 
+// Ad types of a network.
+const (
+	Banner       = "banner"
+	Interstitial = "interstitial"
+	Video        = "video"
+)
+
+// AdTypes lists all ad types of a network.
+var AdTypes = []string{Banner, Interstitial, Video}
+
 // AdNetwork represents a network od SDKs.
 // Each AdNetwork is assigned to a country and is divided by each type of the ad.
 type AdNetwork struct {
 	Banner       []*SDK `json:"banner"`
 	Interstitial []*SDK `json:"interstitial"`
 	Video        []*SDK `json:"video"`
-	Country      string `json:"country"`
+	Country      string `json:"country" validate:"required"`
 }
 
 // MarshalBinary satisfies encoding.BinaryMarshaler interface.
@@ -65,6 +86,56 @@ func (an *AdNetwork) UnmarshalBinary(data []byte) (err error) {
 	return ffjson.Unmarshal(data, an)
 }
 
+// Copy returns a copy of the network, filtering the copy leaves the original untouched.
+func (an *AdNetwork) Copy() *AdNetwork {
+	return &AdNetwork{
+		Banner:       append([]*SDK{}, an.Banner...),
+		Interstitial: append([]*SDK{}, an.Interstitial...),
+		Video:        append([]*SDK{}, an.Video...),
+		Country:      an.Country,
+	}
+}
+
+// List returns providers of given ad type.
+func (an *AdNetwork) List(adType string) []*SDK {
+	switch adType {
+	case Banner:
+		return an.Banner
+	case Interstitial:
+		return an.Interstitial
+	case Video:
+		return an.Video
+	}
+
+	return nil
+}
+
+// AnyEmpty returns true if any list of given ad types is empty.
+func (an *AdNetwork) AnyEmpty(adTypes []string) bool {
+	for _, adType := range adTypes {
+		if len(an.List(adType)) == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Limit truncates every list to at most n providers with the highest score.
+func (an *AdNetwork) Limit(n int) {
+	limit := func(arr []*SDK) []*SDK {
+		sort.Stable(ScoreSorter(arr))
+		if len(arr) > n {
+			return arr[:n]
+		}
+		return arr
+	}
+
+	an.Banner = limit(an.Banner)
+	an.Interstitial = limit(an.Interstitial)
+	an.Video = limit(an.Video)
+}
+
 // ContainsAllProviders returns true if all providers are present in specified slice.
 func (an *AdNetwork) ContainsAllProviders(adType string, providers []string) bool {
 	switch adType {
@@ -198,3 +198,66 @@ func TestContainsAny(t *testing.T) {
 		})
 	}
 }
+
+func TestCopy(t *testing.T) {
+	original := &AdNetwork{
+		Country: "SI",
+		Banner:  []*SDK{{Provider: "Facebook"}, {Provider: "AdMob"}},
+		Video:   []*SDK{{Provider: "Twitter"}},
+	}
+
+	cp := original.Copy()
+	cp.Banner = cp.Banner[:1]
+	cp.Video[0] = &SDK{Provider: "MoPub"}
+	cp.Country = "GB"
+
+	if len(original.Banner) != 2 || original.Video[0].Provider != "Twitter" || original.Country != "SI" {
+		t.Errorf("expected original to be untouched, got %+v", original)
+	}
+
+	if cp.Interstitial == nil || len(cp.Interstitial) != 0 {
+		t.Errorf("expected empty interstitial list, got %v", cp.Interstitial)
+	}
+}
+
+func TestAnyEmpty(t *testing.T) {
+	an := &AdNetwork{
+		Banner: []*SDK{{Provider: "Facebook"}},
+		Video:  []*SDK{},
+	}
+
+	tests := []struct {
+		in       []string
+		expected bool
+	}{
+		{[]string{Banner}, false},
+		{[]string{Banner, Video}, true},
+		{[]string{Interstitial}, true},
+		{[]string{}, false},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if got := an.AnyEmpty(test.in); got != test.expected {
+				t.Errorf("Got: %t Expected: %t", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestLimit(t *testing.T) {
+	an := &AdNetwork{
+		Banner: []*SDK{{Provider: "AdMob", Score: 1}, {Provider: "Facebook", Score: 3}, {Provider: "Adx", Score: 2}},
+		Video:  []*SDK{{Provider: "Twitter", Score: 1}},
+	}
+
+	an.Limit(2)
+
+	if len(an.Banner) != 2 || an.Banner[0].Provider != "Facebook" || an.Banner[1].Provider != "Adx" {
+		t.Errorf("expected top 2 banners by score, got %v", an.Banner)
+	}
+
+	if len(an.Video) != 1 || len(an.Interstitial) != 0 {
+		t.Errorf("expected shorter lists to be untouched, got %v %v", an.Video, an.Interstitial)
+	}
+}
@@ -0,0 +1,270 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"expertisetest/config"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/pquerna/ffjson/ffjson"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	instance Store
+	once     sync.Once
+)
+
+// GetInstance always returns the same instance of the audit Store configured in Config.
+func GetInstance() Store {
+	once.Do(func() {
+		c := config.GetInstance()
+		if c.AuditStore == "redis" {
+			instance = NewRedisStore(c.MetaRedisClient, "audit")
+			return
+		}
+
+		instance = NewFileStore(c.AuditFile)
+	})
+	return instance
+}
+
+// Entry records a single administrative action.
+type Entry struct {
+	Time      time.Time         `json:"time"`
+	RequestID string            `json:"requestId,omitempty"`
+	Actor     string            `json:"actor"`
+	Method    string            `json:"method,omitempty"`
+	IP        string            `json:"ip"`
+	Action    string            `json:"action"`
+	Params    map[string]string `json:"params,omitempty"`
+	Status    int               `json:"status"`
+	// Tenants affected by the action, empty for actions affecting all tenants or failing before their resource was found.
+	Tenants []string `json:"tenants,omitempty"`
+	// Dataset changes, only set by actions publishing data.
+	VersionBefore int64 `json:"versionBefore,omitempty"`
+	VersionAfter  int64 `json:"versionAfter,omitempty"`
+	Countries     int   `json:"countries,omitempty"`
+}
+
+// Filter narrows down queried entries, zero values match everything.
+type Filter struct {
+	Actor  string
+	Action string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+	// Tenants restricts entries to actions affecting none but these tenants,
+	// actions affecting all tenants are left out.
+	Tenants []string
+}
+
+// Match returns true if entry passes the filter.
+func (f *Filter) Match(e *Entry) bool {
+	return (f.Actor == "" || f.Actor == e.Actor) &&
+		(f.Action == "" || f.Action == e.Action) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until)) &&
+		(len(f.Tenants) == 0 || within(e.Tenants, f.Tenants))
+}
+
+// returns true if tenants are a non empty subset of allowed.
+func within(tenants, allowed []string) bool {
+	if len(tenants) == 0 {
+		return false
+	}
+
+	for _, t := range tenants {
+		found := false
+		for _, a := range allowed {
+			found = found || a == t
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Store persists audit entries, entries can only be appended.
+type Store interface {
+	Append(e *Entry) error
+	// Query returns matching entries, newest first.
+	Query(f *Filter) ([]*Entry, error)
+}
+
+// Record appends the entry to the configured store, failures are only logged
+// so an unavailable audit store does not block administration.
+func Record(e *Entry) {
+	if err := GetInstance().Append(e); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"type":   "audit",
+			"action": e.Action,
+			"actor":  e.Actor,
+		}).Error(errors.Wrap(err, "failed to record audit entry"))
+	}
+}
+
+// FromContext returns the entry being recorded for the request, or nil.
+func FromContext(ctx context.Context) *Entry {
+	e, ok := ctx.Value(config.AuditKey).(*Entry)
+	if !ok {
+		return nil
+	}
+
+	return e
+}
+
+// WriteJSONL writes entries as json lines.
+func WriteJSONL(w io.Writer, entries []*Entry) error {
+	for _, e := range entries {
+		b, err := ffjson.Marshal(e)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal entry")
+		}
+
+		if _, err = w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FileStore appends entries to a json lines file.
+type FileStore struct {
+	filename string
+	mu       sync.Mutex
+}
+
+// NewFileStore returns a new FileStore, the file is created on first append.
+func NewFileStore(filename string) *FileStore {
+	return &FileStore{filename: filename}
+}
+
+// Append satisfies Store interface.
+func (fs *FileStore) Append(e *Entry) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	f, err := os.OpenFile(fs.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %q", fs.filename)
+	}
+
+	if err = WriteJSONL(f, []*Entry{e}); err != nil {
+		_ = f.Close()
+		return errors.Wrapf(err, "failed to append to %q", fs.filename)
+	}
+
+	// Sync so entries survive a crash right after the action.
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return errors.Wrapf(err, "failed to sync %q", fs.filename)
+	}
+
+	return f.Close()
+}
+
+// Query satisfies Store interface.
+func (fs *FileStore) Query(f *Filter) ([]*Entry, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	file, err := os.Open(fs.filename)
+	if os.IsNotExist(err) {
+		return []*Entry{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %q", fs.filename)
+	}
+	defer file.Close()
+
+	out := []*Entry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		e := &Entry{}
+		if err = ffjson.Unmarshal(line, e); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal entry")
+		}
+
+		if f.Match(e) {
+			out = append(out, e)
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read %q", fs.filename)
+	}
+
+	return newestFirst(out, f.Limit), nil
+}
+
+// RedisStore appends entries to a redis list, shared between all instances.
+type RedisStore struct {
+	client *redis.Client
+	key    string
+}
+
+// NewRedisStore returns a new RedisStore keeping entries in list under key.
+func NewRedisStore(client *redis.Client, key string) *RedisStore {
+	return &RedisStore{client: client, key: key}
+}
+
+// Append satisfies Store interface.
+func (rs *RedisStore) Append(e *Entry) error {
+	b, err := ffjson.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal entry")
+	}
+
+	return errors.Wrap(rs.client.RPush(rs.key, b).Err(), "failed to append entry")
+}
+
+// Query satisfies Store interface.
+func (rs *RedisStore) Query(f *Filter) ([]*Entry, error) {
+	values, err := rs.client.LRange(rs.key, 0, -1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch entries")
+	}
+
+	out := []*Entry{}
+	for _, value := range values {
+		e := &Entry{}
+		if err = ffjson.Unmarshal([]byte(value), e); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal entry")
+		}
+
+		if f.Match(e) {
+			out = append(out, e)
+		}
+	}
+
+	return newestFirst(out, f.Limit), nil
+}
+
+// reverses entries stored in append order and applies the limit.
+func newestFirst(entries []*Entry, limit int) []*Entry {
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	return entries
+}
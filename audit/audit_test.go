@@ -0,0 +1,96 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := NewFileStore(filepath.Join(dir, "audit.jsonl"))
+
+	// Querying before anything was recorded.
+	entries, err := fs.Query(&Filter{})
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("unexpected result: %v %v", entries, err)
+	}
+
+	start := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	for i, item := range []struct {
+		actor   string
+		action  string
+		tenants []string
+	}{
+		{"admin", "update", []string{"default"}},
+		{"admin", "users.disable", []string{"default", "other"}},
+		{"publisher", "update", []string{"other"}},
+		{"admin", "update", nil},
+	} {
+		e := &Entry{
+			Time:          start.Add(time.Duration(i) * time.Hour),
+			Actor:         item.actor,
+			Action:        item.action,
+			Params:        map[string]string{"wipe": "true"},
+			VersionBefore: int64(i),
+			VersionAfter:  int64(i + 1),
+			Tenants:       item.tenants,
+		}
+		if err := fs.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		filter   *Filter
+		expected []int64 // VersionAfter of expected entries
+	}{
+		{&Filter{}, []int64{4, 3, 2, 1}},
+		{&Filter{Action: "update"}, []int64{4, 3, 1}},
+		{&Filter{Actor: "admin", Action: "update", Limit: 1}, []int64{4}},
+		{&Filter{Since: start.Add(time.Hour), Until: start.Add(3 * time.Hour)}, []int64{3, 2}},
+		{&Filter{Tenants: []string{"default"}}, []int64{1}},
+		{&Filter{Tenants: []string{"other", "default"}}, []int64{3, 2, 1}},
+		{&Filter{Tenants: []string{"other"}, Limit: 1}, []int64{3}},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got, err := fs.Query(test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(test.expected) {
+				t.Fatalf("Got: %d entries Expected: %d", len(got), len(test.expected))
+			}
+
+			for j, e := range got {
+				if e.VersionAfter != test.expected[j] {
+					t.Errorf("Got: %d Expected: %d", e.VersionAfter, test.expected[j])
+				}
+			}
+		})
+	}
+
+	// Export writes one entry per line.
+	entries, _ = fs.Query(&Filter{})
+	buf := &bytes.Buffer{}
+	if err := WriteJSONL(buf, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != len(entries) {
+		t.Errorf("Got: %d lines Expected: %d", len(lines), len(entries))
+	}
+}
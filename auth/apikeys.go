@@ -0,0 +1,356 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"expertisetest/config"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pquerna/ffjson/ffjson"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	keys     *keyStore
+	keysOnce sync.Once
+)
+
+// ErrKeyNotFound is returned when API key does not exist in the store.
+var ErrKeyNotFound = errors.New("api key not found")
+
+// ErrInvalidKey is returned when API key is malformed, unknown, revoked or expired.
+var ErrInvalidKey = errors.New("invalid or expired api key")
+
+// ErrInvalidScope is returned when API key is requested with an unknown scope.
+var ErrInvalidScope = errors.New("invalid scope")
+
+// Scopes lists permissions that can be granted to API keys.
+var Scopes = []Permission{PermList, PermUpdate, PermRules}
+
+// APIKey identifies a client application.
+// Only a hash of the secret is stored, the key itself is returned once on creation.
+type APIKey struct {
+	ID         string       `json:"id"`
+	App        string       `json:"app"`
+	Scopes     []Permission `json:"scopes"`
+	Tenants    []string     `json:"tenants,omitempty"`
+	SecretHash string       `json:"secretHash,omitempty"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	ExpiresAt  *time.Time   `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time   `json:"lastUsedAt,omitempty"`
+	Revoked    bool         `json:"revoked"`
+	// RotatedTo is the id of the key replacing this one.
+	RotatedTo string `json:"rotatedTo,omitempty"`
+}
+
+// valid returns true if key can be used at given time.
+func (k *APIKey) valid(now time.Time) bool {
+	return !k.Revoked && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// keyStore encodes API keys into a Store.
+type keyStore struct {
+	Store
+}
+
+func (ks *keyStore) get(id string) (*APIKey, error) {
+	b, err := ks.Get(id)
+	if err == ErrNotFound {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	k := &APIKey{}
+	return k, errors.Wrapf(ffjson.Unmarshal(b, k), "failed to unmarshal api key %q", id)
+}
+
+func (ks *keyStore) list() ([]*APIKey, error) {
+	m, err := ks.List()
+	if err != nil {
+		return nil, err
+	}
+
+	out := []*APIKey{}
+	for id, b := range m {
+		k := &APIKey{}
+		if err = ffjson.Unmarshal(b, k); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal api key %q", id)
+		}
+		out = append(out, k)
+	}
+
+	return out, nil
+}
+
+func (ks *keyStore) put(k *APIKey) error {
+	b, err := ffjson.Marshal(k)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal api key")
+	}
+
+	return ks.Put(k.ID, b)
+}
+
+// update applies fn to the stored key, concurrent changes of the key are never overwritten.
+func (ks *keyStore) update(id string, fn func(k *APIKey) error) (*APIKey, error) {
+	k := &APIKey{}
+	err := ks.Update(id, func(b []byte) ([]byte, error) {
+		k = &APIKey{}
+		if err := ffjson.Unmarshal(b, k); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal api key %q", id)
+		}
+
+		if err := fn(k); err != nil {
+			return nil, err
+		}
+
+		b, err := ffjson.Marshal(k)
+		return b, errors.Wrap(err, "failed to marshal api key")
+	})
+	if err == ErrNotFound {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// getKeys always returns the same instance of the API key store configured in Config.
+func getKeys() *keyStore {
+	keysOnce.Do(func() {
+		c := config.GetInstance()
+
+		s, err := newStore(c.AuthStore, c.APIKeysFile, "apikeys", c.MetaRedisClient)
+		if err != nil {
+			logrus.Fatal(errors.Wrap(err, "failed to init api key store"))
+		}
+		keys = &keyStore{s}
+	})
+	return keys
+}
+
+// ParseScopes validates scope names.
+func ParseScopes(scopes []string) ([]Permission, error) {
+	out := []Permission{}
+	for _, scope := range scopes {
+		found := false
+		for _, p := range Scopes {
+			if string(p) == scope {
+				out = append(out, p)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, ErrInvalidScope
+		}
+	}
+
+	if len(out) == 0 {
+		return nil, ErrInvalidScope
+	}
+
+	return out, nil
+}
+
+// CreateAPIKey stores a new key for the application and returns it with its plaintext value.
+// Zero expiresAt means the key never expires, keys without tenants can access all of them.
+func CreateAPIKey(app string, scopes []Permission, tenants []string, expiresAt time.Time) (*APIKey, string, error) {
+	if app == "" {
+		return nil, "", errors.New("missing app")
+	}
+
+	if len(scopes) == 0 {
+		return nil, "", ErrInvalidScope
+	}
+
+	if err := validTenants(tenants); err != nil {
+		return nil, "", err
+	}
+
+	return createAPIKey(getKeys(), app, scopes, tenants, expiresAt)
+}
+
+func createAPIKey(ks *keyStore, app string, scopes []Permission, tenants []string, expiresAt time.Time) (*APIKey, string, error) {
+	k, key, err := newAPIKey(app, scopes, tenants, expiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err = ks.put(k); err != nil {
+		return nil, "", err
+	}
+
+	return k, key, nil
+}
+
+// newAPIKey returns a key that is not stored yet with its plaintext value.
+func newAPIKey(app string, scopes []Permission, tenants []string, expiresAt time.Time) (*APIKey, string, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret, err := randomHex(24)
+	if err != nil {
+		return nil, "", err
+	}
+
+	k := &APIKey{
+		ID:         id,
+		App:        app,
+		Scopes:     scopes,
+		Tenants:    tenants,
+		SecretHash: hashSecret(secret),
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	if !expiresAt.IsZero() {
+		expiresAt = expiresAt.UTC()
+		k.ExpiresAt = &expiresAt
+	}
+
+	return k, id + "." + secret, nil
+}
+
+// VerifyAPIKey validates the key and returns the identity of the application it belongs to.
+func VerifyAPIKey(key string) (*Identity, error) {
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidKey
+	}
+
+	ks := getKeys()
+	k, err := ks.get(parts[0])
+	if err == ErrKeyNotFound {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch api key")
+	}
+
+	now := time.Now().UTC()
+	if subtle.ConstantTimeCompare([]byte(k.SecretHash), []byte(hashSecret(parts[1]))) != 1 || !k.valid(now) {
+		return nil, ErrInvalidKey
+	}
+
+	// Only persist last use periodically to avoid a write on every request.
+	// The key is updated as stored by now, so a revocation or rotation since it was read is kept.
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= config.GetInstance().APIKeysTouchInterval {
+		_, err = ks.update(k.ID, func(k *APIKey) error {
+			k.LastUsedAt = &now
+			return nil
+		})
+		if err != nil {
+			logrus.WithField("key", k.ID).Error(errors.Wrap(err, "failed to track api key use"))
+		}
+	}
+
+	return &Identity{
+		Subject: k.App,
+		Method:  MethodAPIKey,
+		KeyID:   k.ID,
+		Scopes:  k.Scopes,
+		Tenants: k.Tenants,
+	}, nil
+}
+
+// GetAPIKey returns the key record.
+func GetAPIKey(id string) (*APIKey, error) {
+	return getKeys().get(id)
+}
+
+// RevokeAPIKey revokes the key immediately.
+func RevokeAPIKey(id string) (*APIKey, error) {
+	return getKeys().update(id, func(k *APIKey) error {
+		k.Revoked = true
+		return nil
+	})
+}
+
+// RotateAPIKey issues a new key with the same application and scopes.
+// The old key stays valid for the overlap period, so clients can be rolled out gradually.
+func RotateAPIKey(id string, overlap time.Duration) (*APIKey, string, error) {
+	ks := getKeys()
+	old, err := ks.get(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	expiresAt := time.Time{}
+	if old.ExpiresAt != nil {
+		expiresAt = *old.ExpiresAt
+	}
+
+	k, secret, err := newAPIKey(old.App, old.Scopes, old.Tenants, expiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The old key is checked and shortened as stored by now, so a revocation since it was read is kept
+	// and no replacement is issued for it.
+	_, err = ks.update(id, func(old *APIKey) error {
+		if !old.valid(time.Now()) {
+			return ErrInvalidKey
+		}
+
+		// Never extend the validity of the old key.
+		until := time.Now().UTC().Add(overlap)
+		if old.ExpiresAt == nil || until.Before(*old.ExpiresAt) {
+			old.ExpiresAt = &until
+		}
+		old.RotatedTo = k.ID
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err = ks.put(k); err != nil {
+		return nil, "", err
+	}
+
+	return k, secret, nil
+}
+
+// ListAPIKeys returns all keys sorted by application and creation time.
+func ListAPIKeys() ([]*APIKey, error) {
+	out, err := getKeys().list()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].App != out[j].App {
+			return out[i].App < out[j].App
+		}
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out, nil
+}
+
+// API keys carry enough entropy for a fast hash, unlike passwords.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate random string")
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"expertisetest/config"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// Role represents a set of permissions granted to an authenticated entity.
+type Role string
+
+const (
+	// RoleAdmin can call every endpoint.
+	RoleAdmin Role = "admin"
+	// RoleClient can only fetch lists.
+	RoleClient Role = "client"
+	// RoleAnalyst can fetch lists and read administrative data, but not change it.
+	RoleAnalyst Role = "analyst"
+	// RoleEditor can manage filtering rules.
+	RoleEditor Role = "editor"
+	// RolePublisher can publish new datasets.
+	RolePublisher Role = "publisher"
+)
+
+// Roles lists all valid roles.
+var Roles = []Role{RoleAdmin, RoleClient, RoleAnalyst, RoleEditor, RolePublisher}
+
+// Permission allows calling a group of endpoints.
+// API key scopes are expressed as permissions as well.
+type Permission string
+
+const (
+	// PermList allows fetching lists.
+	PermList Permission = "list"
+	// PermUpdate allows publishing datasets.
+	PermUpdate Permission = "update"
+	// PermRules allows managing filtering rules, provider metadata and kill switches.
+	PermRules Permission = "rules"
+	// PermRead allows reading administrative data.
+	PermRead Permission = "read"
+	// PermAccounts allows managing users, API keys and webhooks.
+	PermAccounts Permission = "accounts"
+)
+
+// permissions granted to each role.
+var permissions = map[Role][]Permission{
+	RoleAdmin:     {PermList, PermUpdate, PermRules, PermRead, PermAccounts},
+	RolePublisher: {PermList, PermUpdate},
+	RoleEditor:    {PermList, PermRules},
+	RoleAnalyst:   {PermList, PermRead},
+	RoleClient:    {PermList},
+}
+
+// Authentication methods.
+const (
+	MethodBasic  = "basic"
+	MethodToken  = "token"
+	MethodAPIKey = "apikey"
+)
+
+const (
+	tokenAccess  = "access"
+	tokenRefresh = "refresh"
+)
+
+// ErrInvalidCredentials is returned when username and password do not match.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// ErrInvalidToken is returned when token fails verification.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// Identity is an authenticated entity sent down the context.
+type Identity struct {
+	Subject string
+	Role    Role
+	Method  string
+	// Tenants the identity is restricted to, empty means all tenants.
+	Tenants []string
+	// KeyID and Scopes are only set for API keys.
+	KeyID   string
+	Scopes  []Permission
+	version int
+}
+
+// CanAccess returns true if identity is allowed to access the tenant.
+func (id *Identity) CanAccess(tenant string) bool {
+	if len(id.Tenants) == 0 {
+		return true
+	}
+
+	for _, t := range id.Tenants {
+		if t == tenant {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanManage returns true if identity is allowed to manage an account, webhook or other resource restricted to tenants.
+// Identities restricted to tenants only manage resources restricted to a subset of their own tenants,
+// unrestricted resources reach every tenant.
+func (id *Identity) CanManage(tenants []string) bool {
+	if len(id.Tenants) == 0 {
+		return true
+	}
+	if len(tenants) == 0 {
+		return false
+	}
+
+	for _, t := range tenants {
+		if !id.CanAccess(t) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Can returns true if identity was granted the permission,
+// either by its role or by API key scopes.
+func (id *Identity) Can(perm Permission) bool {
+	granted := permissions[id.Role]
+	if id.Method == MethodAPIKey {
+		granted = id.Scopes
+	}
+
+	for _, p := range granted {
+		if p == perm {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Challenges returns WWW-Authenticate challenges for the enabled authentication methods.
+func Challenges() []string {
+	if config.GetInstance().AuthBasicEnabled {
+		return []string{"Bearer", `Basic realm="restricted"`}
+	}
+
+	return []string{"Bearer"}
+}
+
+// Claims are the JWT claims issued by the api.
+type Claims struct {
+	Role    Role   `json:"role"`
+	Type    string `json:"typ"`
+	Version int    `json:"ver"`
+	jwt.StandardClaims
+}
+
+// TokenPair is returned on login and refresh.
+type TokenPair struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int64  `json:"expiresIn"`
+}
+
+// Issue returns a new pair of signed access and refresh tokens for the identity.
+func Issue(id *Identity) (*TokenPair, error) {
+	c := config.GetInstance()
+
+	access, err := sign(id, tokenAccess, c.JWTAccessTTL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign access token")
+	}
+
+	refresh, err := sign(id, tokenRefresh, c.JWTRefreshTTL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign refresh token")
+	}
+
+	return &TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(c.JWTAccessTTL.Seconds()),
+	}, nil
+}
+
+// Verify validates an access token and returns the identity it was issued for.
+func Verify(token string) (*Identity, error) {
+	return verify(token, tokenAccess)
+}
+
+// Refresh exchanges a valid refresh token for a new token pair.
+func Refresh(token string) (*TokenPair, error) {
+	id, err := verify(token, tokenRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	return Issue(id)
+}
+
+func sign(id *Identity, tokenType string, ttl time.Duration) (string, error) {
+	c := config.GetInstance()
+	now := time.Now()
+
+	claims := &Claims{
+		Role:    id.Role,
+		Type:    tokenType,
+		Version: id.version,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   id.Subject,
+			Issuer:    c.JWTIssuer,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(c.JWTSecret))
+}
+
+func verify(token, tokenType string) (*Identity, error) {
+	c := config.GetInstance()
+	claims := &Claims{}
+
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, errors.Errorf("unexpected signing method %q", t.Header["alg"])
+		}
+		return []byte(c.JWTSecret), nil
+	})
+	if err != nil || !parsed.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.Type != tokenType || claims.Issuer != c.JWTIssuer || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+
+	// Tokens of disabled or rotated users are no longer valid,
+	// role is taken from the store so changes take effect immediately.
+	u, err := active(claims.Subject, claims.Version)
+	if err == ErrUserNotFound || err == ErrInvalidToken {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch user")
+	}
+
+	return &Identity{
+		Subject: u.Username,
+		Role:    u.Role,
+		Method:  MethodToken,
+		Tenants: u.Tenants,
+		version: u.Version,
+	}, nil
+}
@@ -0,0 +1,398 @@
+package auth
+
+import (
+	"expertisetest/config"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Use main to set up new Config without redis and a temporary user store,
+// seeded with initial accounts from env.
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		panic(err)
+	}
+
+	config.OverrideInstance(config.NewTest())
+	config.GetInstance().AuthStore = "file"
+	config.GetInstance().UsersFile = filepath.Join(dir, "users.json")
+	config.GetInstance().APIKeysFile = filepath.Join(dir, "apikeys.json")
+	config.GetInstance().DisableLogging()
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestAuthenticate(t *testing.T) {
+	c := config.GetInstance()
+	tests := []struct {
+		user     string
+		pass     string
+		expected Role
+		err      error
+	}{
+		{c.AdminUser, c.AdminPass, RoleAdmin, nil},
+		{c.ClientUser, c.ClientPass, RoleClient, nil},
+		{c.AdminUser, c.ClientPass, "", ErrInvalidCredentials},
+		{"", "", "", ErrInvalidCredentials},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			id, err := Authenticate(test.user, test.pass)
+			if err != test.err {
+				t.Fatalf("Got: %v Expected: %v", err, test.err)
+			}
+
+			if err == nil && id.Role != test.expected {
+				t.Errorf("Got: %s Expected: %s", id.Role, test.expected)
+			}
+		})
+	}
+}
+
+func TestIssueVerify(t *testing.T) {
+	c := config.GetInstance()
+	tokens, err := Issue(&Identity{Subject: c.AdminUser, Role: RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := Verify(tokens.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id.Subject != c.AdminUser || id.Role != RoleAdmin || id.Method != MethodToken {
+		t.Errorf("unexpected identity: %+v", id)
+	}
+
+	// Refresh tokens can not be used as access tokens and vice versa.
+	if _, err := Verify(tokens.RefreshToken); err != ErrInvalidToken {
+		t.Errorf("Got: %v Expected: %v", err, ErrInvalidToken)
+	}
+
+	if _, err := Refresh(tokens.AccessToken); err != ErrInvalidToken {
+		t.Errorf("Got: %v Expected: %v", err, ErrInvalidToken)
+	}
+
+	refreshed, err := Refresh(tokens.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Verify(refreshed.AccessToken); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyExpired(t *testing.T) {
+	token, err := sign(&Identity{Subject: config.GetInstance().ClientUser, Role: RoleClient}, tokenAccess, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Verify(token); err != ErrInvalidToken {
+		t.Errorf("Got: %v Expected: %v", err, ErrInvalidToken)
+	}
+
+	if _, err := Verify("not.a.token"); err != ErrInvalidToken {
+		t.Errorf("Got: %v Expected: %v", err, ErrInvalidToken)
+	}
+}
+
+func TestUserLifecycle(t *testing.T) {
+	if _, err := CreateUser("analyst", "analystpass", RoleAnalyst, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CreateUser("analyst", "analystpass", RoleAnalyst, nil); err != ErrUserExists {
+		t.Errorf("Got: %v Expected: %v", err, ErrUserExists)
+	}
+
+	if _, err := CreateUser("nobody", "pass", Role("root"), nil); err != ErrInvalidRole {
+		t.Errorf("Got: %v Expected: %v", err, ErrInvalidRole)
+	}
+
+	if _, err := CreateUser("nobody", "pass", RoleClient, []string{"unknown"}); err != ErrInvalidTenant {
+		t.Errorf("Got: %v Expected: %v", err, ErrInvalidTenant)
+	}
+
+	id, err := Authenticate("analyst", "analystpass")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := Issue(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Disabled users can neither log in nor use issued tokens.
+	if _, err = SetDisabled("analyst", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = Authenticate("analyst", "analystpass"); err != ErrInvalidCredentials {
+		t.Errorf("Got: %v Expected: %v", err, ErrInvalidCredentials)
+	}
+
+	if _, err = Verify(tokens.AccessToken); err != ErrInvalidToken {
+		t.Errorf("Got: %v Expected: %v", err, ErrInvalidToken)
+	}
+
+	if _, err = SetDisabled("analyst", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = Verify(tokens.AccessToken); err != nil {
+		t.Error(err)
+	}
+
+	// Rotation invalidates the old password and tokens.
+	pass, err := RotatePassword("analyst", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = Verify(tokens.AccessToken); err != ErrInvalidToken {
+		t.Errorf("Got: %v Expected: %v", err, ErrInvalidToken)
+	}
+
+	if _, err = Authenticate("analyst", "analystpass"); err != ErrInvalidCredentials {
+		t.Errorf("Got: %v Expected: %v", err, ErrInvalidCredentials)
+	}
+
+	if _, err = Authenticate("analyst", pass); err != nil {
+		t.Error(err)
+	}
+
+	// Users survive reloading the store from file.
+	fs, err := NewFileStore(config.GetInstance().UsersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u, err := (&userStore{fs}).get("analyst"); err != nil || u.Role != RoleAnalyst {
+		t.Errorf("failed to reload user: %v", err)
+	}
+}
+
+// Stores of the same file, as of the api and cmd/users, see each others writes.
+func TestFileStoreShared(t *testing.T) {
+	filename := filepath.Join(filepath.Dir(config.GetInstance().UsersFile), "shared.json")
+
+	api, err := NewFileStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := NewFileStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = api.Put("a", []byte(`{"n":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err = cli.Update("a", func([]byte) ([]byte, error) { return []byte(`{"n":2}`), nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err = api.Put("b", []byte(`{"n":3}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []*FileStore{api, cli} {
+		m, err := s.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(m["a"]) != `{"n":2}` || string(m["b"]) != `{"n":3}` {
+			t.Errorf("Got: %s %s Expected the writes of both stores", m["a"], m["b"])
+		}
+	}
+
+	if _, err = os.Stat(filename + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("Got lock file: %v Expected it removed after writing", err)
+	}
+}
+
+func TestCan(t *testing.T) {
+	tests := []struct {
+		id       *Identity
+		perm     Permission
+		expected bool
+	}{
+		{&Identity{Role: RoleAdmin, Method: MethodToken}, PermAccounts, true},
+		{&Identity{Role: RoleClient, Method: MethodBasic}, PermList, true},
+		{&Identity{Role: RoleClient, Method: MethodBasic}, PermUpdate, false},
+		{&Identity{Role: RolePublisher, Method: MethodToken}, PermUpdate, true},
+		{&Identity{Role: RoleAnalyst, Method: MethodToken}, PermRules, false},
+		// API keys are only granted their scopes.
+		{&Identity{Role: RoleAdmin, Method: MethodAPIKey, Scopes: []Permission{PermList}}, PermUpdate, false},
+		{&Identity{Method: MethodAPIKey, Scopes: []Permission{PermList, PermRules}}, PermRules, true},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			if got := test.id.Can(test.perm); got != test.expected {
+				t.Errorf("Got: %t Expected: %t", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestCanManage(t *testing.T) {
+	unrestricted := &Identity{Role: RoleAdmin}
+	restricted := &Identity{Role: RoleAdmin, Tenants: []string{"tom", "angela"}}
+
+	tests := []struct {
+		id       *Identity
+		tenants  []string
+		expected bool
+	}{
+		{unrestricted, nil, true},
+		{unrestricted, []string{"ben"}, true},
+		// Unrestricted resources reach every tenant.
+		{restricted, nil, false},
+		{restricted, []string{"tom"}, true},
+		{restricted, []string{"angela", "tom"}, true},
+		{restricted, []string{"tom", "ben"}, false},
+	}
+
+	for i, test := range tests {
+		if got := test.id.CanManage(test.tenants); got != test.expected {
+			t.Errorf("%d: Got: %t Expected: %t", i, got, test.expected)
+		}
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	if _, err := ParseScopes([]string{"list", "admin"}); err != ErrInvalidScope {
+		t.Errorf("Got: %v Expected: %v", err, ErrInvalidScope)
+	}
+
+	scopes, err := ParseScopes([]string{"list"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, key, err := CreateAPIKey("talking-tom", scopes, nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := VerifyAPIKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id.Subject != "talking-tom" || id.KeyID != k.ID || !id.Can(PermList) || id.Can(PermUpdate) {
+		t.Errorf("unexpected identity: %+v", id)
+	}
+
+	if stored, err := getKeys().get(k.ID); err != nil || stored.LastUsedAt == nil {
+		t.Errorf("last use not tracked: %v", err)
+	}
+
+	if _, err = VerifyAPIKey(k.ID + ".wrong"); err != ErrInvalidKey {
+		t.Errorf("Got: %v Expected: %v", err, ErrInvalidKey)
+	}
+
+	// Both keys are valid during overlap.
+	rotated, rotatedKey, err := RotateAPIKey(k.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{key, rotatedKey} {
+		if _, err = VerifyAPIKey(key); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Without overlap the old key expires immediately.
+	_, newest, err := RotateAPIKey(rotated.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = VerifyAPIKey(rotatedKey); err != ErrInvalidKey {
+		t.Errorf("Got: %v Expected: %v", err, ErrInvalidKey)
+	}
+
+	// Expired keys can not be used.
+	expired, expiredKey, err := CreateAPIKey("talking-angela", scopes, nil, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = VerifyAPIKey(expiredKey); err != ErrInvalidKey {
+		t.Errorf("Got: %v Expected: %v", err, ErrInvalidKey)
+	}
+
+	if _, _, err = RotateAPIKey(expired.ID, time.Hour); err != ErrInvalidKey {
+		t.Errorf("Got: %v Expected: %v", err, ErrInvalidKey)
+	}
+
+	// Revoked keys stop working immediately.
+	id, err = VerifyAPIKey(newest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = RevokeAPIKey(id.KeyID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = VerifyAPIKey(newest); err != ErrInvalidKey {
+		t.Errorf("Got: %v Expected: %v", err, ErrInvalidKey)
+	}
+}
+
+// revokingStore revokes every key right after it is read, as a concurrent request would.
+type revokingStore struct {
+	Store
+}
+
+func (s revokingStore) Get(id string) ([]byte, error) {
+	b, err := s.Store.Get(id)
+	if err == nil {
+		_, err = (&keyStore{s.Store}).update(id, func(k *APIKey) error {
+			k.Revoked = true
+			return nil
+		})
+	}
+
+	return b, err
+}
+
+func TestVerifyRevokedMeanwhile(t *testing.T) {
+	k, key, err := CreateAPIKey("talking-ben", []Permission{PermList}, nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ks := getKeys()
+	keys = &keyStore{revokingStore{ks.Store}}
+	defer func() { keys = ks }()
+
+	// The key was valid when read, the request is served but tracking its use keeps the revocation.
+	if _, err = VerifyAPIKey(key); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := ks.get(k.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !stored.Revoked || stored.LastUsedAt == nil {
+		t.Errorf("Got revoked: %v last use: %v Expected the revoked key with its last use", stored.Revoked, stored.LastUsedAt)
+	}
+}
@@ -0,0 +1,305 @@
+package auth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+// ErrNotFound is returned by a Store when record does not exist.
+var ErrNotFound = errors.New("record not found")
+
+// Store persists json encoded records by id.
+// Users and API keys are each kept in their own Store.
+type Store interface {
+	Get(id string) ([]byte, error)
+	List() (map[string][]byte, error)
+	Put(id string, record []byte) error
+	// Update replaces the record by the one fn returns for it atomically,
+	// so concurrent updates of other fields are never overwritten.
+	Update(id string, fn func(record []byte) ([]byte, error)) error
+}
+
+// FileStore keeps records in a json file, suitable for a single instance.
+// The file is read again whenever it changed, so writes of other processes such as cmd/users are picked up,
+// and writes hold a lock file so processes never overwrite each other.
+type FileStore struct {
+	filename string
+	mu       sync.Mutex
+	records  map[string]json.RawMessage
+	// modification time and size of the file when records were read.
+	modTime time.Time
+	size    int64
+}
+
+const (
+	// lockTimeout bounds waiting for the lock file held by another process.
+	lockTimeout = 5 * time.Second
+	// lockStale is the age after which a lock file is considered left behind by a crashed process.
+	lockStale = 30 * time.Second
+)
+
+// NewFileStore returns a FileStore loaded from filename, the file is created on first write.
+func NewFileStore(filename string) (*FileStore, error) {
+	fs := &FileStore{filename: filename}
+	if err := fs.load(false); err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+// Get returns the record.
+func (fs *FileStore) Get(id string) ([]byte, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.load(false); err != nil {
+		return nil, err
+	}
+
+	b, ok := fs.records[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return b, nil
+}
+
+// List returns all records.
+func (fs *FileStore) List() (map[string][]byte, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.load(false); err != nil {
+		return nil, err
+	}
+
+	out := map[string][]byte{}
+	for id, b := range fs.records {
+		out[id] = b
+	}
+
+	return out, nil
+}
+
+// Put stores the record and flushes all records to file.
+func (fs *FileStore) Put(id string, record []byte) error {
+	return fs.write(id, func(map[string]json.RawMessage) ([]byte, error) {
+		return record, nil
+	})
+}
+
+// Update replaces the record and flushes all records to file.
+func (fs *FileStore) Update(id string, fn func(record []byte) ([]byte, error)) error {
+	return fs.write(id, func(records map[string]json.RawMessage) ([]byte, error) {
+		b, ok := records[id]
+		if !ok {
+			return nil, ErrNotFound
+		}
+
+		return fn(b)
+	})
+}
+
+// write stores the record fn returns given the records as currently in the file, holding the lock file.
+func (fs *FileStore) write(id string, fn func(records map[string]json.RawMessage) ([]byte, error)) (err error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// Records not written are dropped, they are read again on next access.
+	defer func() {
+		if err != nil {
+			fs.records = nil
+		}
+	}()
+
+	unlock, err := fs.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Modification times may be too coarse to tell writes apart, so the file is always read under the lock.
+	if err = fs.load(true); err != nil {
+		return err
+	}
+
+	record, err := fn(fs.records)
+	if err != nil {
+		return err
+	}
+	fs.records[id] = record
+
+	b, err := ffjson.Marshal(fs.records)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal records")
+	}
+
+	// Write to a temporary file first so a failed write never corrupts the store.
+	tmp := fs.filename + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrapf(err, "failed to write %q", tmp)
+	}
+
+	if err = os.Rename(tmp, fs.filename); err != nil {
+		return errors.Wrapf(err, "failed to replace %q", fs.filename)
+	}
+
+	// Records are in sync with the file as written, it is only read again once another process replaces it.
+	info, err := os.Stat(fs.filename)
+	if err != nil {
+		return errors.Wrapf(err, "failed to stat %q", fs.filename)
+	}
+	fs.modTime, fs.size = info.ModTime(), info.Size()
+	return nil
+}
+
+// load reads records from file unless it is unchanged since last read, fs.mu must be held.
+func (fs *FileStore) load(force bool) error {
+	info, err := os.Stat(fs.filename)
+	if os.IsNotExist(err) {
+		fs.records, fs.modTime, fs.size = map[string]json.RawMessage{}, time.Time{}, 0
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to stat %q", fs.filename)
+	}
+
+	if !force && fs.records != nil && info.ModTime().Equal(fs.modTime) && info.Size() == fs.size {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(fs.filename)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %q", fs.filename)
+	}
+
+	records := map[string]json.RawMessage{}
+	if err = ffjson.Unmarshal(b, &records); err != nil {
+		return errors.Wrapf(err, "failed to unmarshal %q", fs.filename)
+	}
+
+	fs.records, fs.modTime, fs.size = records, info.ModTime(), info.Size()
+	return nil
+}
+
+// lock creates the lock file next to the store and returns the function removing it.
+// Lock files left behind by crashed processes are taken over once stale.
+func (fs *FileStore) lock() (func(), error) {
+	name := fs.filename + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+
+	for {
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			_ = f.Close()
+			return func() { _ = os.Remove(name) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, errors.Wrapf(err, "failed to lock %q", fs.filename)
+		}
+
+		if info, err := os.Stat(name); err == nil && time.Since(info.ModTime()) > lockStale {
+			_ = os.Remove(name)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errors.Errorf("failed to lock %q: %q is held by another process", fs.filename, name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// RedisStore keeps records in a redis hash, shared between all instances.
+type RedisStore struct {
+	client *redis.Client
+	key    string
+}
+
+// NewRedisStore returns a new RedisStore keeping records in hash under key.
+func NewRedisStore(client *redis.Client, key string) *RedisStore {
+	return &RedisStore{client: client, key: key}
+}
+
+// Get fetches the record from redis.
+func (rs *RedisStore) Get(id string) ([]byte, error) {
+	b, err := rs.client.HGet(rs.key, id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+
+	return b, errors.Wrapf(err, "failed to fetch %q from %q", id, rs.key)
+}
+
+// List fetches all records from redis.
+func (rs *RedisStore) List() (map[string][]byte, error) {
+	m, err := rs.client.HGetAll(rs.key).Result()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch %q", rs.key)
+	}
+
+	out := map[string][]byte{}
+	for id, value := range m {
+		out[id] = []byte(value)
+	}
+
+	return out, nil
+}
+
+// Put stores the record in redis.
+func (rs *RedisStore) Put(id string, record []byte) error {
+	return errors.Wrapf(rs.client.HSet(rs.key, id, record).Err(), "failed to store %q in %q", id, rs.key)
+}
+
+// updateAttempts bounds retries of an update losing the race against concurrent writes.
+const updateAttempts = 10
+
+// Update replaces the record in a transaction, retried when the hash changes meanwhile.
+func (rs *RedisStore) Update(id string, fn func(record []byte) ([]byte, error)) error {
+	update := func(tx *redis.Tx) error {
+		b, err := tx.HGet(rs.key, id).Bytes()
+		if err == redis.Nil {
+			return ErrNotFound
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to fetch %q from %q", id, rs.key)
+		}
+
+		if b, err = fn(b); err != nil {
+			return err
+		}
+
+		// Fails with redis.TxFailedErr if the hash was written since it was watched.
+		_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.HSet(rs.key, id, b)
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < updateAttempts; i++ {
+		err := rs.client.Watch(update, rs.key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+
+	return errors.Errorf("failed to update %q in %q: too many concurrent writes", id, rs.key)
+}
+
+// newStore returns a Store of the backend configured in Config.
+func newStore(backend, filename, key string, client *redis.Client) (Store, error) {
+	if backend == "redis" {
+		return NewRedisStore(client, key), nil
+	}
+
+	return NewFileStore(filename)
+}
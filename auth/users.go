@@ -0,0 +1,338 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"expertisetest/config"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pquerna/ffjson/ffjson"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	users     *userStore
+	usersOnce sync.Once
+)
+
+// ErrUserNotFound is returned when user does not exist in the store.
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrUserExists is returned when creating a user that already exists.
+var ErrUserExists = errors.New("user already exists")
+
+// ErrInvalidRole is returned when parsing an unknown role.
+var ErrInvalidRole = errors.New("invalid role")
+
+// ErrInvalidTenant is returned when restricting access to a tenant not in Config.
+var ErrInvalidTenant = errors.New("invalid tenant")
+
+// used to keep response times of unknown users equal to the known ones.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+
+// User is an account stored in the user store.
+type User struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"passwordHash,omitempty"`
+	Role         Role      `json:"role"`
+	Tenants      []string  `json:"tenants,omitempty"`
+	Disabled     bool      `json:"disabled"`
+	CreatedAt    time.Time `json:"createdAt"`
+	RotatedAt    time.Time `json:"rotatedAt"`
+	// Version is incremented on every rotation, invalidating previously issued tokens.
+	Version int `json:"version"`
+}
+
+// userStore encodes users into a Store.
+type userStore struct {
+	Store
+}
+
+func (us *userStore) get(username string) (*User, error) {
+	b, err := us.Get(username)
+	if err == ErrNotFound {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	u := &User{}
+	return u, errors.Wrapf(ffjson.Unmarshal(b, u), "failed to unmarshal user %q", username)
+}
+
+func (us *userStore) list() ([]*User, error) {
+	m, err := us.List()
+	if err != nil {
+		return nil, err
+	}
+
+	out := []*User{}
+	for username, b := range m {
+		u := &User{}
+		if err = ffjson.Unmarshal(b, u); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal user %q", username)
+		}
+		out = append(out, u)
+	}
+
+	return out, nil
+}
+
+func (us *userStore) put(u *User) error {
+	b, err := ffjson.Marshal(u)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal user")
+	}
+
+	return us.Put(u.Username, b)
+}
+
+// update applies fn to the stored user, concurrent changes of the user are never overwritten.
+func (us *userStore) update(username string, fn func(u *User) error) (*User, error) {
+	u := &User{}
+	err := us.Update(username, func(b []byte) ([]byte, error) {
+		u = &User{}
+		if err := ffjson.Unmarshal(b, u); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal user %q", username)
+		}
+
+		if err := fn(u); err != nil {
+			return nil, err
+		}
+
+		b, err := ffjson.Marshal(u)
+		return b, errors.Wrap(err, "failed to marshal user")
+	})
+	if err == ErrNotFound {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// getUsers always returns the same instance of the user store configured in Config.
+// Empty stores are seeded with initial accounts from Config.
+func getUsers() *userStore {
+	usersOnce.Do(func() {
+		c := config.GetInstance()
+
+		s, err := newStore(c.AuthStore, c.UsersFile, "users", c.MetaRedisClient)
+		if err != nil {
+			logrus.Fatal(errors.Wrap(err, "failed to init user store"))
+		}
+		users = &userStore{s}
+
+		if err = seed(users, c); err != nil {
+			logrus.Fatal(errors.Wrap(err, "failed to seed user store"))
+		}
+	})
+	return users
+}
+
+// ParseRole validates the role name.
+func ParseRole(role string) (Role, error) {
+	for _, r := range Roles {
+		if string(r) == role {
+			return r, nil
+		}
+	}
+
+	return "", ErrInvalidRole
+}
+
+// Authenticate validates username and password and returns the identity they belong to.
+func Authenticate(username, pass string) (*Identity, error) {
+	u, err := getUsers().get(username)
+	if err != nil && err != ErrUserNotFound {
+		return nil, errors.Wrap(err, "failed to fetch user")
+	}
+
+	if u == nil || u.Disabled {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(pass))
+		return nil, ErrInvalidCredentials
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(pass)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Identity{Subject: u.Username, Role: u.Role, Tenants: u.Tenants, version: u.Version}, nil
+}
+
+// CreateUser hashes the password and stores a new user.
+// Users without tenants can access all of them.
+func CreateUser(username, pass string, role Role, tenants []string) (*User, error) {
+	return createUser(getUsers(), username, pass, role, tenants)
+}
+
+func createUser(s *userStore, username, pass string, role Role, tenants []string) (*User, error) {
+	if username == "" || pass == "" {
+		return nil, errors.New("missing username or password")
+	}
+
+	if _, err := ParseRole(string(role)); err != nil {
+		return nil, err
+	}
+
+	if err := validTenants(tenants); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.get(username); err != ErrUserNotFound {
+		if err == nil {
+			return nil, ErrUserExists
+		}
+		return nil, err
+	}
+
+	hash, err := hashPassword(pass)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	u := &User{
+		Username:     username,
+		PasswordHash: hash,
+		Role:         role,
+		Tenants:      tenants,
+		CreatedAt:    now,
+		RotatedAt:    now,
+	}
+
+	return u, s.put(u)
+}
+
+// GetUser returns the user.
+func GetUser(username string) (*User, error) {
+	return getUsers().get(username)
+}
+
+// SetDisabled disables or re-enables the user.
+func SetDisabled(username string, disabled bool) (*User, error) {
+	return getUsers().update(username, func(u *User) error {
+		u.Disabled = disabled
+		return nil
+	})
+}
+
+// RotatePassword replaces the password of the user, invalidating previously issued tokens.
+// If pass is empty a random password is generated and returned.
+func RotatePassword(username, pass string) (string, error) {
+	var err error
+	if pass == "" {
+		if pass, err = randomString(24); err != nil {
+			return "", err
+		}
+	}
+
+	hash, err := hashPassword(pass)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = getUsers().update(username, func(u *User) error {
+		u.PasswordHash = hash
+		u.RotatedAt = time.Now().UTC()
+		u.Version++
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return pass, nil
+}
+
+// ListUsers returns all users sorted by username.
+func ListUsers() ([]*User, error) {
+	out, err := getUsers().list()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Username < out[j].Username })
+	return out, nil
+}
+
+// active returns the user if it exists, is enabled and has not rotated its password since the token was issued.
+func active(username string, version int) (*User, error) {
+	u, err := getUsers().get(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Disabled || u.Version != version {
+		return nil, ErrInvalidToken
+	}
+
+	return u, nil
+}
+
+// seeds an empty store with initial accounts from Config.
+func seed(s *userStore, c *config.Config) error {
+	existing, err := s.list()
+	if err != nil || len(existing) > 0 {
+		return err
+	}
+
+	initial := []struct {
+		user string
+		pass string
+		role Role
+	}{
+		{c.AdminUser, c.AdminPass, RoleAdmin},
+		{c.ClientUser, c.ClientPass, RoleClient},
+	}
+
+	for _, item := range initial {
+		if item.user == "" || item.pass == "" {
+			continue
+		}
+
+		if _, err := createUser(s, item.user, item.pass, item.role, nil); err != nil {
+			return errors.Wrapf(err, "failed to create %q", item.user)
+		}
+
+		logrus.WithFields(logrus.Fields{"user": item.user, "role": item.role}).Info("seeded user")
+	}
+
+	return nil
+}
+
+// validates all tenants are in Config.
+func validTenants(tenants []string) error {
+	for _, tenant := range tenants {
+		if !config.GetInstance().ValidTenant(tenant) {
+			return ErrInvalidTenant
+		}
+	}
+
+	return nil
+}
+
+func hashPassword(pass string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to hash password")
+	}
+
+	return string(hash), nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate random string")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
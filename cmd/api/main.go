@@ -3,9 +3,10 @@ package main
 import (
 	"expertisetest/config"
 	"expertisetest/server"
+	"os"
 )
 
 func main() {
 	config.GetInstance()
-	server.New().Serve()
+	os.Exit(server.New().Serve())
 }
@@ -1,17 +1,28 @@
 package main
 
 import (
-	"expertisetest/adnetwork"
 	"expertisetest/config"
 	"expertisetest/handler"
+	"flag"
 	"os"
 
 	"github.com/sirupsen/logrus"
 )
 
 func main() {
-	config.GetInstance()
-	h := handler.GetInstance()
+	app := flag.String("app", "", "tenant to warm up, default tenant if empty")
+	flag.Parse()
+
+	c := config.GetInstance()
+	if *app == "" {
+		*app = c.DefaultTenant
+	}
+
+	h, err := handler.ForTenant(*app)
+	if err != nil {
+		logrus.Fatal(err)
+		os.Exit(2)
+	}
 
 	m, err := h.Load()
 	if err != nil {
@@ -19,15 +30,13 @@ func main() {
 		os.Exit(2)
 	}
 
-	if err := h.Store(m, true); err != nil {
+	if _, err := h.Store(m, true); err != nil {
 
 		logrus.Fatal(err)
 		os.Exit(2)
 	}
 
-	an := &adnetwork.AdNetwork{}
-	a := config.GetInstance().RedisClient.Get("CN")
-	if err := a.Scan(an); err != nil {
+	if _, err := h.Get("CN"); err != nil {
 		logrus.Fatal(err)
 		os.Exit(3)
 	}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"expertisetest/auth"
+	"expertisetest/config"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const usage = `usage: users <command> [flags]
+
+commands:
+  list
+  create  -username <name> -password <pass> -role <role> [-tenants <tenant,...>]
+  disable -username <name>
+  enable  -username <name>
+  rotate  -username <name> [-password <pass>]
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	username := fs.String("username", "", "name of the user")
+	password := fs.String("password", "", "password of the user")
+	role := fs.String("role", string(auth.RoleClient), "role of the user")
+	tenants := fs.String("tenants", "", "comma separated tenants the user is restricted to, empty for all")
+	if err := fs.Parse(os.Args[2:]); err != nil {
+		logrus.Fatal(err)
+	}
+
+	config.GetInstance()
+
+	switch os.Args[1] {
+	case "list":
+		users, err := auth.ListUsers()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		for _, u := range users {
+			fmt.Printf("%s\t%s\ttenants=%s\tdisabled=%t\n", u.Username, u.Role, strings.Join(u.Tenants, ","), u.Disabled)
+		}
+	case "create":
+		r, err := auth.ParseRole(*role)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		var restricted []string
+		if *tenants != "" {
+			restricted = strings.Split(*tenants, ",")
+		}
+
+		if _, err := auth.CreateUser(*username, *password, r, restricted); err != nil {
+			logrus.Fatal(err)
+		}
+	case "disable", "enable":
+		if _, err := auth.SetDisabled(*username, os.Args[1] == "disable"); err != nil {
+			logrus.Fatal(err)
+		}
+	case "rotate":
+		pass, err := auth.RotatePassword(*username, *password)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		if *password == "" {
+			fmt.Println(pass)
+		}
+	default:
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-redis/redis"
 	"github.com/pkg/errors"
@@ -32,15 +34,91 @@ func GetInstance() *Config {
 
 // Config ...
 type Config struct {
-	RedisClient   *redis.Client
-	Pipefile      string // Simulate the complex scoring pipeline
-	Prefilter     string
-	Postfilter    string
-	AdminUser     string
-	AdminPass     string
-	ClientUser    string
-	ClientPass    string
-	RetryAttempts int
+	RedisClient *redis.Client
+	// MetaRedisClient holds everything but the dataset (users, keys, ...),
+	// keeping it safe from wiping the dataset on update.
+	MetaRedisClient *redis.Client
+	Pipefile        string // Simulate the complex scoring pipeline
+	Prefilter       string
+	Postfilter      string
+	AdminUser       string
+	AdminPass       string
+	ClientUser      string
+	ClientPass      string
+	RetryAttempts   int
+	BatchMaxSize    int // maximum number of contexts in a batch list request
+
+	// Registry of canonical provider IDs and their aliases, rules referencing
+	// unknown providers fail to load when strict and are only logged otherwise.
+	Providers           string
+	ProviderRulesStrict bool
+	ProviderMetadata    bool // serve only providers enabled in provider metadata, with their settings
+
+	// HTTP caching of /list responses, zero max age disables caching.
+	ListCacheMaxAge time.Duration
+	ListCachePublic bool // allow shared caches such as CDNs to store responses
+
+	// Watching /list for new datasets, requests and streams end after the timeout.
+	ListWatchTimeout   time.Duration
+	ListWatchKeepAlive time.Duration // interval of comments keeping idle event streams open
+
+	// Client detection, countries are detected from client addresses when GeoIPFile is set.
+	GeoIPFile          string // MaxMind database with country records, e.g. GeoLite2-Country.mmdb
+	TrustedProxies     string // comma separated addresses or networks whose proxy headers are trusted, * for any, none for no peer
+	UserAgentDetection bool   // derive omitted platform, osVersion and device from User-Agent and client hints
+
+	// Tenants are apps sharing the api, each with its own dataset and rules.
+	Tenants        []string
+	DefaultTenant  string
+	TenantRulesDir string // holds <tenant>/prefilter.json and <tenant>/postfilter.json overrides
+
+	// Token authentication settings.
+	JWTSecret        string
+	JWTIssuer        string
+	JWTAccessTTL     time.Duration
+	JWTRefreshTTL    time.Duration
+	AuthBasicEnabled bool // legacy basic auth on every endpoint
+
+	// User and API key store settings.
+	AuthStore            string // file or redis
+	UsersFile            string
+	APIKeysFile          string
+	APIKeysTouchInterval time.Duration // how often last use of a key is persisted
+
+	// HTTP server settings.
+	HTTPAddr         string
+	HTTPReadTimeout  time.Duration
+	HTTPWriteTimeout time.Duration
+	HTTPIdleTimeout  time.Duration
+	ShutdownGrace    time.Duration
+
+	// gRPC server settings, the server is disabled when GRPCAddr is empty.
+	GRPCAddr           string
+	GRPCHealthInterval time.Duration // how often storage is checked for health reporting
+
+	// Audit log settings.
+	AuditStore string // file or redis
+	AuditFile  string
+
+	// Webhook delivery settings, zero workers disable delivery on the instance.
+	WebhookWorkers      int
+	WebhookTimeout      time.Duration
+	WebhookMaxAttempts  int
+	WebhookRetryBackoff time.Duration // delay before the first retry, doubled on every further one
+	WebhookHistorySize  int           // delivery attempts kept per webhook
+
+	// Rate limit settings, limits are in "rate:burst" format.
+	RateLimitEnabled bool
+	RateLimitBackend string // memory or redis
+	RateLimitIP      string
+	RateLimitRoles   string // comma separated role=rate:burst, "apikey" applies to API keys
+
+	// TLS settings, TLS is enabled when both TLSCertFile and TLSKeyFile are set.
+	TLSCertFile         string
+	TLSKeyFile          string
+	TLSClientCAFile     string
+	TLSReloadInterval   time.Duration
+	TLSRequireAdminCert bool
 }
 
 func new(omitRedis bool) *Config {
@@ -58,23 +136,178 @@ func new(omitRedis bool) *Config {
 		log.Fatalf("failed to fetch config: %q", "POSTFILTER_FILENAME")
 	}
 
-	if c.AdminUser = viper.GetString("ADMIN_USER"); c.AdminUser == "" {
-		log.Fatalf("failed to fetch config: %q", "ADMIN_USER")
+	viper.SetDefault("PROVIDERS_FILENAME", "handler/providers.json")
+	if c.Providers = viper.GetString("PROVIDERS_FILENAME"); c.Providers == "" {
+		log.Fatalf("failed to fetch config: %q", "PROVIDERS_FILENAME")
 	}
+	c.ProviderRulesStrict = viper.GetBool("PROVIDER_RULES_STRICT")
+	c.ProviderMetadata = viper.GetBool("PROVIDER_METADATA")
+
+	// Initial accounts are optional, they are only used to seed an empty user store.
+	c.AdminUser = viper.GetString("ADMIN_USER")
+	c.AdminPass = viper.GetString("ADMIN_PASS")
+	c.ClientUser = viper.GetString("CLIENT_USER")
+	c.ClientPass = viper.GetString("CLIENT_PASS")
 
-	if c.AdminPass = viper.GetString("ADMIN_PASS"); c.AdminPass == "" {
-		log.Fatalf("failed to fetch config: %q", "ADMIN_PASS")
+	viper.SetDefault("AUTH_STORE", "file")
+	viper.SetDefault("USERS_FILENAME", "users.json")
+	viper.SetDefault("APIKEYS_FILENAME", "apikeys.json")
+	viper.SetDefault("APIKEYS_TOUCH_INTERVAL", "1m")
+	c.APIKeysTouchInterval = viper.GetDuration("APIKEYS_TOUCH_INTERVAL")
+	switch c.AuthStore = viper.GetString("AUTH_STORE"); c.AuthStore {
+	case "file":
+		if c.UsersFile = viper.GetString("USERS_FILENAME"); c.UsersFile == "" {
+			log.Fatalf("failed to fetch config: %q", "USERS_FILENAME")
+		}
+
+		if c.APIKeysFile = viper.GetString("APIKEYS_FILENAME"); c.APIKeysFile == "" {
+			log.Fatalf("failed to fetch config: %q", "APIKEYS_FILENAME")
+		}
+	case "redis":
+	default:
+		log.Fatalf("invalid config %q: %q", "AUTH_STORE", c.AuthStore)
 	}
 
-	if c.ClientUser = viper.GetString("CLIENT_USER"); c.ClientUser == "" {
-		log.Fatalf("failed to fetch config: %q", "CLIENT_USER")
+	c.RetryAttempts = viper.GetInt("RETRY_ATTEMPTS")
+
+	viper.SetDefault("BATCH_MAX_SIZE", 100)
+	if c.BatchMaxSize = viper.GetInt("BATCH_MAX_SIZE"); c.BatchMaxSize < 1 {
+		log.Fatalf("invalid config %q: %q", "BATCH_MAX_SIZE", viper.GetString("BATCH_MAX_SIZE"))
 	}
 
-	if c.ClientPass = viper.GetString("CLIENT_PASS"); c.ClientPass == "" {
-		log.Fatalf("failed to fetch config: %q", "CLIENT_PASS")
+	viper.SetDefault("LIST_CACHE_MAX_AGE", "60s")
+	viper.SetDefault("LIST_CACHE_PUBLIC", false)
+	if c.ListCacheMaxAge = viper.GetDuration("LIST_CACHE_MAX_AGE"); c.ListCacheMaxAge < 0 {
+		log.Fatalf("invalid config %q: %q", "LIST_CACHE_MAX_AGE", viper.GetString("LIST_CACHE_MAX_AGE"))
 	}
+	c.ListCachePublic = viper.GetBool("LIST_CACHE_PUBLIC")
 
-	c.RetryAttempts = viper.GetInt("RETRY_ATTEMPTS")
+	viper.SetDefault("TRUSTED_PROXIES", "none")
+	c.GeoIPFile = viper.GetString("GEOIP_FILENAME")
+	c.TrustedProxies = viper.GetString("TRUSTED_PROXIES")
+	c.UserAgentDetection = viper.GetBool("USER_AGENT_DETECTION")
+
+	viper.SetDefault("TENANTS", "default")
+	viper.SetDefault("DEFAULT_TENANT", "default")
+	viper.SetDefault("TENANT_RULES_DIR", "handler/tenants")
+	c.DefaultTenant = viper.GetString("DEFAULT_TENANT")
+	c.TenantRulesDir = viper.GetString("TENANT_RULES_DIR")
+	for _, tenant := range strings.Split(viper.GetString("TENANTS"), ",") {
+		if tenant = strings.TrimSpace(tenant); tenant != "" {
+			c.Tenants = append(c.Tenants, tenant)
+		}
+	}
+
+	if !c.ValidTenant(c.DefaultTenant) {
+		log.Fatalf("invalid config %q: %q not in %q", "DEFAULT_TENANT", c.DefaultTenant, "TENANTS")
+	}
+
+	if c.JWTSecret = viper.GetString("JWT_SECRET"); c.JWTSecret == "" {
+		log.Fatalf("failed to fetch config: %q", "JWT_SECRET")
+	}
+
+	viper.SetDefault("JWT_ISSUER", "expertisetest")
+	viper.SetDefault("JWT_ACCESS_TTL", "15m")
+	viper.SetDefault("JWT_REFRESH_TTL", "24h")
+	viper.SetDefault("AUTH_BASIC_ENABLED", true)
+	c.JWTIssuer = viper.GetString("JWT_ISSUER")
+	c.JWTAccessTTL = viper.GetDuration("JWT_ACCESS_TTL")
+	c.JWTRefreshTTL = viper.GetDuration("JWT_REFRESH_TTL")
+	c.AuthBasicEnabled = viper.GetBool("AUTH_BASIC_ENABLED")
+
+	viper.SetDefault("HTTP_ADDR", ":80")
+	viper.SetDefault("HTTP_READ_TIMEOUT", "10s")
+	viper.SetDefault("HTTP_WRITE_TIMEOUT", "30s")
+	viper.SetDefault("HTTP_IDLE_TIMEOUT", "120s")
+	viper.SetDefault("SHUTDOWN_GRACE_PERIOD", "15s")
+	c.HTTPAddr = viper.GetString("HTTP_ADDR")
+	c.HTTPReadTimeout = viper.GetDuration("HTTP_READ_TIMEOUT")
+	c.HTTPWriteTimeout = viper.GetDuration("HTTP_WRITE_TIMEOUT")
+	c.HTTPIdleTimeout = viper.GetDuration("HTTP_IDLE_TIMEOUT")
+	c.ShutdownGrace = viper.GetDuration("SHUTDOWN_GRACE_PERIOD")
+
+	// Watch requests must end before the server times out writing them.
+	viper.SetDefault("LIST_WATCH_TIMEOUT", "25s")
+	viper.SetDefault("LIST_WATCH_KEEPALIVE", "10s")
+	if c.ListWatchTimeout = viper.GetDuration("LIST_WATCH_TIMEOUT"); c.ListWatchTimeout <= 0 ||
+		(c.HTTPWriteTimeout > 0 && c.ListWatchTimeout >= c.HTTPWriteTimeout) {
+		log.Fatalf("invalid config %q: %q", "LIST_WATCH_TIMEOUT", viper.GetString("LIST_WATCH_TIMEOUT"))
+	}
+	if c.ListWatchKeepAlive = viper.GetDuration("LIST_WATCH_KEEPALIVE"); c.ListWatchKeepAlive <= 0 {
+		log.Fatalf("invalid config %q: %q", "LIST_WATCH_KEEPALIVE", viper.GetString("LIST_WATCH_KEEPALIVE"))
+	}
+
+	viper.SetDefault("GRPC_HEALTH_INTERVAL", "10s")
+	c.GRPCAddr = viper.GetString("GRPC_ADDR")
+	if c.GRPCHealthInterval = viper.GetDuration("GRPC_HEALTH_INTERVAL"); c.GRPCHealthInterval <= 0 {
+		log.Fatalf("invalid config %q: %q", "GRPC_HEALTH_INTERVAL", viper.GetString("GRPC_HEALTH_INTERVAL"))
+	}
+
+	viper.SetDefault("AUDIT_STORE", "file")
+	viper.SetDefault("AUDIT_FILENAME", "audit.jsonl")
+	switch c.AuditStore = viper.GetString("AUDIT_STORE"); c.AuditStore {
+	case "file":
+		if c.AuditFile = viper.GetString("AUDIT_FILENAME"); c.AuditFile == "" {
+			log.Fatalf("failed to fetch config: %q", "AUDIT_FILENAME")
+		}
+	case "redis":
+	default:
+		log.Fatalf("invalid config %q: %q", "AUDIT_STORE", c.AuditStore)
+	}
+
+	viper.SetDefault("WEBHOOK_WORKERS", 4)
+	viper.SetDefault("WEBHOOK_TIMEOUT", "10s")
+	viper.SetDefault("WEBHOOK_MAX_ATTEMPTS", 8)
+	viper.SetDefault("WEBHOOK_RETRY_BACKOFF", "10s")
+	viper.SetDefault("WEBHOOK_HISTORY_SIZE", 100)
+	if c.WebhookWorkers = viper.GetInt("WEBHOOK_WORKERS"); c.WebhookWorkers < 0 {
+		log.Fatalf("invalid config %q: %q", "WEBHOOK_WORKERS", viper.GetString("WEBHOOK_WORKERS"))
+	}
+	if c.WebhookTimeout = viper.GetDuration("WEBHOOK_TIMEOUT"); c.WebhookTimeout <= 0 {
+		log.Fatalf("invalid config %q: %q", "WEBHOOK_TIMEOUT", viper.GetString("WEBHOOK_TIMEOUT"))
+	}
+	if c.WebhookMaxAttempts = viper.GetInt("WEBHOOK_MAX_ATTEMPTS"); c.WebhookMaxAttempts < 1 {
+		log.Fatalf("invalid config %q: %q", "WEBHOOK_MAX_ATTEMPTS", viper.GetString("WEBHOOK_MAX_ATTEMPTS"))
+	}
+	if c.WebhookRetryBackoff = viper.GetDuration("WEBHOOK_RETRY_BACKOFF"); c.WebhookRetryBackoff <= 0 {
+		log.Fatalf("invalid config %q: %q", "WEBHOOK_RETRY_BACKOFF", viper.GetString("WEBHOOK_RETRY_BACKOFF"))
+	}
+	if c.WebhookHistorySize = viper.GetInt("WEBHOOK_HISTORY_SIZE"); c.WebhookHistorySize < 1 {
+		log.Fatalf("invalid config %q: %q", "WEBHOOK_HISTORY_SIZE", viper.GetString("WEBHOOK_HISTORY_SIZE"))
+	}
+
+	viper.SetDefault("RATE_LIMIT_ENABLED", true)
+	viper.SetDefault("RATE_LIMIT_BACKEND", "memory")
+	viper.SetDefault("RATE_LIMIT_IP", "20:40")
+	viper.SetDefault("RATE_LIMIT_ROLES", "admin=50:100,publisher=10:20,editor=10:20,analyst=10:20,client=10:20,apikey=10:20")
+	c.RateLimitEnabled = viper.GetBool("RATE_LIMIT_ENABLED")
+	c.RateLimitIP = viper.GetString("RATE_LIMIT_IP")
+	c.RateLimitRoles = viper.GetString("RATE_LIMIT_ROLES")
+	switch c.RateLimitBackend = viper.GetString("RATE_LIMIT_BACKEND"); c.RateLimitBackend {
+	case "memory", "redis":
+	default:
+		log.Fatalf("invalid config %q: %q", "RATE_LIMIT_BACKEND", c.RateLimitBackend)
+	}
+
+	viper.SetDefault("TLS_RELOAD_INTERVAL", "30s")
+	c.TLSCertFile = viper.GetString("TLS_CERT_FILE")
+	c.TLSKeyFile = viper.GetString("TLS_KEY_FILE")
+	c.TLSClientCAFile = viper.GetString("TLS_CLIENT_CA_FILE")
+	c.TLSReloadInterval = viper.GetDuration("TLS_RELOAD_INTERVAL")
+	c.TLSRequireAdminCert = viper.GetBool("TLS_REQUIRE_ADMIN_CERT")
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		log.Fatalf("failed to fetch config: %q and %q must be set together", "TLS_CERT_FILE", "TLS_KEY_FILE")
+	}
+
+	if c.TLSRequireAdminCert && c.TLSClientCAFile == "" {
+		log.Fatalf("failed to fetch config: %q", "TLS_CLIENT_CA_FILE")
+	}
+
+	// Without TLS no client presents a certificate, so admin endpoints would reject every request.
+	if c.TLSRequireAdminCert && !c.TLSEnabled() {
+		log.Fatalf("failed to fetch config: %q requires %q and %q", "TLS_REQUIRE_ADMIN_CERT", "TLS_CERT_FILE", "TLS_KEY_FILE")
+	}
 
 	// handle logger
 	c.initLogger()
@@ -89,6 +322,13 @@ func new(omitRedis bool) *Config {
 		if _, err := c.RedisClient.Ping().Result(); err != nil {
 			logrus.Fatal(errors.Wrap(err, "failed to connect to redis"))
 		}
+
+		viper.SetDefault("REDIS_META_DB", 1)
+		c.MetaRedisClient = redis.NewClient(&redis.Options{
+			Addr:     fmt.Sprintf("%s:%d", viper.GetString("REDIS_HOST"), viper.GetInt("REDIS_PORT")),
+			Password: viper.GetString("REDIS_PASSWORD"),
+			DB:       viper.GetInt("REDIS_META_DB"),
+		})
 	}
 
 	return c
@@ -151,6 +391,37 @@ func (c *Config) initLogger() {
 	})
 }
 
+// ValidTenant returns true if tenant is configured.
+func (c *Config) ValidTenant(tenant string) bool {
+	for _, t := range c.Tenants {
+		if t == tenant {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TLSEnabled returns true if the server should serve HTTPS.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// Close releases resources held by the Config, such as the storage client.
+func (c *Config) Close() error {
+	for _, client := range []*redis.Client{c.RedisClient, c.MetaRedisClient} {
+		if client == nil {
+			continue
+		}
+
+		if err := client.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // DisableLogging enables to disable all logging for testing.
 func (c *Config) DisableLogging() {
 	logrus.SetOutput(ioutil.Discard)
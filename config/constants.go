@@ -3,8 +3,8 @@ package config
 // LogKey is used in sending logger down the context.
 const LogKey string = "logKey"
 
-// UserKey is used in sending client user down the context.
-const UserKey string = "userKey"
+// IdentityKey is used in sending authenticated identity down the context.
+const IdentityKey string = "identityKey"
 
-// PassKey is used in sending client password down the context.
-const PassKey string = "passKey"
+// AuditKey is used in sending audit entry of an administrative action down the context.
+const AuditKey string = "auditKey"
@@ -0,0 +1,46 @@
+// Package country normalizes country codes to ISO 3166-1 alpha-2, the codes datasets are stored by.
+package country
+
+import (
+	"strings"
+)
+
+// alpha2 maps alpha-3 codes to their alpha-2 codes.
+var alpha2 = map[string]string{}
+
+func init() {
+	for _, codes := range []map[string]string{alpha3, userAssigned} {
+		for a2, a3 := range codes {
+			alpha2[a3] = a2
+		}
+	}
+}
+
+// Normalize returns the alpha-2 code of code, given as ISO 3166-1 alpha-2 or alpha-3 code or a common alias
+// such as UK, in any case and surrounded by whitespace. False is returned for unknown codes.
+func Normalize(code string) (string, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	switch len(code) {
+	case 2:
+		if alias, ok := aliases[code]; ok {
+			return alias, true
+		}
+		if Valid(code) {
+			return code, true
+		}
+	case 3:
+		if a2, ok := alpha2[code]; ok {
+			return a2, true
+		}
+	}
+
+	return "", false
+}
+
+// Valid returns true if code is a normalized alpha-2 code.
+func Valid(code string) bool {
+	_, official := alpha3[code]
+	_, assigned := userAssigned[code]
+	return official || assigned
+}
@@ -0,0 +1,57 @@
+package country
+
+import (
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+		ok       bool
+	}{
+		{"SI", "SI", true},
+		{"si", "SI", true},
+		{" SI ", "SI", true},
+		{"Si\t", "SI", true},
+		{"SVN", "SI", true},
+		{"gbr", "GB", true},
+		{"UK", "GB", true},
+		{"el", "GR", true},
+		{"XK", "XK", true},
+		{"XKX", "XK", true},
+		{"EN", "", false},
+		{"ZZ", "", false},
+		{"ABC", "", false},
+		{"S", "", false},
+		{"SVNX", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := Normalize(tt.code)
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf("%q: Got: %q, %v Expected: %q, %v", tt.code, got, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	if len(alpha3) != 249 {
+		t.Errorf("Got: %d officially assigned codes Expected: 249", len(alpha3))
+	}
+
+	// Every alpha-3 code maps back to its own alpha-2 code.
+	for a2, a3 := range alpha3 {
+		if got, _ := Normalize(a3); got != a2 {
+			t.Errorf("%s: Got: %s Expected: %s", a3, got, a2)
+		}
+	}
+
+	// Aliases never shadow assigned codes.
+	for alias, a2 := range aliases {
+		if Valid(alias) || !Valid(a2) {
+			t.Errorf("invalid alias %s for %s", alias, a2)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Package geoip detects countries of client addresses from a local MaxMind database
+// and resolves client addresses reported by trusted proxies.
+package geoip
+
+import (
+	"expertisetest/config"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	instance *Locator
+	once     sync.Once
+)
+
+// GetInstance always returns the same Locator reading the database in Config, nil if none is configured.
+func GetInstance() *Locator {
+	once.Do(func() {
+		c := config.GetInstance()
+		if c.GeoIPFile == "" {
+			return
+		}
+
+		var err error
+		if instance, err = Open(c.GeoIPFile); err != nil {
+			logrus.Fatal(err)
+		}
+	})
+	return instance
+}
+
+// Locator looks up countries of addresses.
+type Locator struct {
+	db *maxminddb.Reader
+}
+
+// record holds the fields of country and city databases used for detection.
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	// RegisteredCountry is the country of the network owner, known for more addresses than Country.
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// Open returns a Locator reading the MaxMind database file, e.g. GeoLite2-Country.mmdb.
+func Open(filename string) (*Locator, error) {
+	db, err := maxminddb.Open(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open geoip database %q", filename)
+	}
+
+	return &Locator{db: db}, nil
+}
+
+// Country returns the ISO-3166-1 alpha-2 code of the country of ip, empty if the database does not know it.
+func (l *Locator) Country(ip net.IP) (string, error) {
+	if ip == nil {
+		return "", nil
+	}
+
+	rec := &record{}
+	if err := l.db.Lookup(ip, rec); err != nil {
+		return "", errors.Wrapf(err, "failed to look up %s", ip)
+	}
+
+	code := rec.Country.ISOCode
+	if code == "" {
+		code = rec.RegisteredCountry.ISOCode
+	}
+
+	return strings.ToUpper(code), nil
+}
+
+// Close releases the database.
+func (l *Locator) Close() error {
+	return l.db.Close()
+}
+
+// ParseAddr returns the ip of a host:port or plain address, nil if it is not one.
+func ParseAddr(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	return net.ParseIP(strings.TrimSpace(addr))
+}
@@ -0,0 +1,149 @@
+package geoip
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+// testdata/country.mmdb maps 81.2.69.0/24 to GB, 89.160.20.0/24 to SE, 2a02:ff0::/32 to DE
+// and 2.125.160.0/24 only to the registered country SI.
+func TestCountry(t *testing.T) {
+	l, err := Open("testdata/country.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"81.2.69.142", "GB"},
+		{"89.160.20.1", "SE"},
+		{"2a02:ff0::1", "DE"},
+		{"::ffff:81.2.69.1", "GB"},
+		{"2.125.160.216", "SI"},
+		{"10.0.0.1", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := l.Country(net.ParseIP(tt.ip))
+		if err != nil {
+			t.Errorf("%s: %v", tt.ip, err)
+		}
+		if got != tt.expected {
+			t.Errorf("%s: Got: %q Expected: %q", tt.ip, got, tt.expected)
+		}
+	}
+}
+
+func TestOpenMissing(t *testing.T) {
+	if _, err := Open("testdata/missing.mmdb"); err == nil {
+		t.Error("expected error opening missing database")
+	}
+}
+
+func TestParseAddr(t *testing.T) {
+	tests := []struct {
+		addr     string
+		expected string
+	}{
+		{"81.2.69.142:5123", "81.2.69.142"},
+		{"81.2.69.142", "81.2.69.142"},
+		{" 81.2.69.142", "81.2.69.142"},
+		{"[2a02:ff0::1]:443", "2a02:ff0::1"},
+		{"2a02:ff0::1", "2a02:ff0::1"},
+		{"unknown", "<nil>"},
+		{"", "<nil>"},
+	}
+
+	for _, tt := range tests {
+		if got := ParseAddr(tt.addr).String(); got != tt.expected {
+			t.Errorf("%q: Got: %s Expected: %s", tt.addr, got, tt.expected)
+		}
+	}
+}
+
+func TestParseProxies(t *testing.T) {
+	tests := []struct {
+		proxies string
+		ip      string
+		trusted bool
+	}{
+		{"*", "81.2.69.142", true},
+		{"none", "127.0.0.1", false},
+		{"10.0.0.0/8, 127.0.0.1", "10.1.2.3", true},
+		{"10.0.0.0/8, 127.0.0.1", "127.0.0.1", true},
+		{"10.0.0.0/8, 127.0.0.1", "127.0.0.2", false},
+		{"10.0.0.0/8", "::ffff:10.0.0.1", true},
+		{"fd00::/8,::1", "::1", true},
+		{"fd00::/8,::1", "fd12::1", true},
+		{"fd00::/8,::1", "10.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		p, err := ParseProxies(tt.proxies)
+		if err != nil {
+			t.Errorf("%q: %v", tt.proxies, err)
+			continue
+		}
+
+		if got := p.Trusted(net.ParseIP(tt.ip)); got != tt.trusted {
+			t.Errorf("%q %s: Got: %v Expected: %v", tt.proxies, tt.ip, got, tt.trusted)
+		}
+	}
+
+	for _, invalid := range []string{"", "10.0.0.0/33", "proxy", "10.0.0.1,"} {
+		if _, err := ParseProxies(invalid); err == nil {
+			t.Errorf("%q: expected error", invalid)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		proxies    string
+		remoteAddr string
+		realIP     string
+		forwarded  []string
+		expected   string
+	}{
+		// Without proxies, the peer is the client.
+		{"none", "81.2.69.142:5123", "", []string{"89.160.20.1"}, "81.2.69.142"},
+		{"none", "10.0.0.1:5123", "89.160.20.1", nil, "10.0.0.1"},
+		// Headers of untrusted peers are ignored.
+		{"10.0.0.0/8", "81.2.69.142:5123", "89.160.20.1", []string{"89.160.20.1"}, "81.2.69.142"},
+		// X-Real-IP of trusted peers is taken as is.
+		{"10.0.0.0/8", "10.0.0.1:5123", "89.160.20.1", []string{"81.2.69.142"}, "89.160.20.1"},
+		// Forwarded addresses are followed back to the first untrusted one, spoofed ones before it are ignored.
+		{"10.0.0.0/8", "10.0.0.1:5123", "", []string{"1.1.1.1, 81.2.69.142, 10.0.0.2"}, "81.2.69.142"},
+		{"10.0.0.0/8", "10.0.0.1:5123", "", []string{"1.1.1.1", "81.2.69.142", "10.0.0.2"}, "81.2.69.142"},
+		{"10.0.0.0/8", "10.0.0.1:5123", "", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"10.0.0.0/8", "10.0.0.1:5123", "", []string{"81.2.69.142, garbage"}, "10.0.0.1"},
+		{"10.0.0.0/8", "10.0.0.1:5123", "", nil, "10.0.0.1"},
+		// Trusting any peer takes the first forwarded address, as chi RealIP does.
+		{"*", "81.2.69.142:5123", "", []string{"89.160.20.1, 2a02:ff0::1"}, "89.160.20.1"},
+		{"*", "81.2.69.142:5123", "2a02:ff0::1", []string{"89.160.20.1"}, "2a02:ff0::1"},
+	}
+
+	for i, tt := range tests {
+		p, err := ParseProxies(tt.proxies)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		header := http.Header{}
+		if tt.realIP != "" {
+			header.Set("X-Real-IP", tt.realIP)
+		}
+		for _, value := range tt.forwarded {
+			header.Add("X-Forwarded-For", value)
+		}
+
+		if got := p.ClientIP(tt.remoteAddr, header).String(); got != tt.expected {
+			t.Errorf("%d: Got: %s Expected: %s", i, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package geoip
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Proxies are the peers trusted to report client addresses in X-Real-IP and X-Forwarded-For headers.
+type Proxies struct {
+	any  bool
+	nets []*net.IPNet
+}
+
+// ParseProxies parses comma separated addresses and networks in CIDR notation,
+// * trusts any peer and none trusts no peer.
+func ParseProxies(s string) (*Proxies, error) {
+	p := &Proxies{}
+	switch s = strings.TrimSpace(s); s {
+	case "*":
+		p.any = true
+		return p, nil
+	case "none":
+		return p, nil
+	}
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, errors.Errorf("invalid proxy address %q", item)
+			}
+
+			// A single address is a network of its own.
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			p.nets = append(p.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, errors.Errorf("invalid proxy network %q", item)
+		}
+		p.nets = append(p.nets, network)
+	}
+
+	return p, nil
+}
+
+// Trusted returns true if the proxy headers of ip are trusted.
+func (p *Proxies) Trusted(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if p.any {
+		return true
+	}
+
+	for _, network := range p.nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientIP returns the address of the client of a request from peer at remoteAddr.
+// Headers are only followed while they were set by trusted proxies: X-Real-IP of a trusted peer is taken as is,
+// otherwise X-Forwarded-For is walked from the peer back to the first address that is not a trusted proxy.
+// Trusting any peer takes the first forwarded address, as chi RealIP middleware does.
+func (p *Proxies) ClientIP(remoteAddr string, header http.Header) net.IP {
+	client := ParseAddr(remoteAddr)
+	if !p.Trusted(client) {
+		return client
+	}
+
+	if ip := ParseAddr(header.Get("X-Real-IP")); ip != nil {
+		return ip
+	}
+
+	hops := []string{}
+	for _, value := range header["X-Forwarded-For"] {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := ParseAddr(hops[i])
+		if ip == nil {
+			break
+		}
+
+		client = ip
+		if !p.Trusted(ip) {
+			break
+		}
+	}
+
+	return client
+}
@@ -1,15 +1,23 @@
 package handler
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"expertisetest/adnetwork"
 	"expertisetest/config"
+	"expertisetest/country"
+	"expertisetest/provider"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/url"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
 
+	"github.com/go-redis/redis"
 	"github.com/pkg/errors"
 	"github.com/pquerna/ffjson/ffjson"
 	"github.com/sirupsen/logrus"
@@ -18,9 +26,21 @@ import (
 var (
 	instance *Handler
 	once     sync.Once
+
+	tenants   = map[string]*Handler{}
+	tenantsMu sync.Mutex
 )
 
-// GetInstance always returns the same instance of Handler.
+// ErrUnknownTenant is returned when requesting a handler for a tenant not in Config.
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+// versionKey holds the dataset version of a tenant in meta storage, so it survives wiping the dataset.
+const versionKey = "dataset:version:"
+
+// networksKey holds the ad networks of a tenant by country.
+const networksKey = "networks:"
+
+// GetInstance always returns the same instance of Handler for the default tenant.
 // Also ensuring the filter configs only get loaded once, instead of each api call.
 func GetInstance() *Handler {
 	once.Do(func() {
@@ -33,10 +53,52 @@ func GetInstance() *Handler {
 	return instance
 }
 
+// ForTenant always returns the same instance of Handler for the tenant.
+// Tenants use their own rules if present in tenant rules directory, otherwise the default ones.
+func ForTenant(tenant string) (*Handler, error) {
+	c := config.GetInstance()
+	if tenant == c.DefaultTenant {
+		return GetInstance(), nil
+	}
+
+	if !c.ValidTenant(tenant) {
+		return nil, ErrUnknownTenant
+	}
+
+	tenantsMu.Lock()
+	defer tenantsMu.Unlock()
+
+	if h := tenants[tenant]; h != nil {
+		return h, nil
+	}
+
+	h, err := NewTenant(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	tenants[tenant] = h
+	return h, nil
+}
+
 // Prefilter is a definition of a filter running on load.
 type Prefilter struct {
 	FilterType filterType          `json:"type"`
 	Args       map[string][]string `json:"args"`
+	// Values of score and top-N rules by scope: "*", a country, an ad type or "country/adType".
+	Values map[string]float64 `json:"values,omitempty"`
+}
+
+// value returns the value of the most specific scope matching country and ad type,
+// a country scope wins over an ad type one. False is returned if no scope matches.
+func (p *Prefilter) value(country, adType string) (float64, bool) {
+	for _, scope := range []string{country + "/" + adType, country, adType, anyScope} {
+		if v, ok := p.Values[scope]; ok {
+			return v, true
+		}
+	}
+
+	return 0, false
 }
 
 // Postfilter is a definition of a filter running on api call.
@@ -48,14 +110,28 @@ type Postfilter struct {
 // Handler handles loading and filtering of data.
 type Handler struct {
 	log                *logrus.Entry
+	tenant             string
+	prefilterFile      string
+	postfilterFile     string
+	prefilterSum       [sha256.Size]byte
+	postfilterSum      [sha256.Size]byte
 	PrefilterMappings  []Prefilter `json:"prefilterMappings"`
 	PostfilterMappings Postfilter  `json:"postfilterMappings"`
 }
 
-// New returns a new Handler.
+// New returns a new Handler for the default tenant.
 func New() (*Handler, error) {
+	return NewTenant(config.GetInstance().DefaultTenant)
+}
+
+// NewTenant returns a new Handler for the tenant.
+func NewTenant(tenant string) (*Handler, error) {
+	c := config.GetInstance()
 	h := &Handler{
-		log: logrus.WithField("package", "handler"),
+		log:            logrus.WithFields(logrus.Fields{"package": "handler", "tenant": tenant}),
+		tenant:         tenant,
+		prefilterFile:  tenantFile(c, tenant, "prefilter.json", c.Prefilter),
+		postfilterFile: tenantFile(c, tenant, "postfilter.json", c.Postfilter),
 	}
 
 	h.log.Debug("init")
@@ -71,6 +147,11 @@ func New() (*Handler, error) {
 	return h, nil
 }
 
+// Tenant returns the tenant the handler belongs to.
+func (h *Handler) Tenant() string {
+	return h.tenant
+}
+
 // Get fetches from redis
 func (h *Handler) Get(key string) (*adnetwork.AdNetwork, error) {
 	h.log.WithFields(logrus.Fields{
@@ -80,14 +161,10 @@ func (h *Handler) Get(key string) (*adnetwork.AdNetwork, error) {
 	an := &adnetwork.AdNetwork{}
 	rd := config.GetInstance().RedisClient
 
-	status, err := rd.Exists(key).Result()
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to validate key")
-	}
-	if status == 0 {
+	cmd := rd.HGet(networksKey+h.tenant, key)
+	if cmd.Err() == redis.Nil {
 		return nil, nil
 	}
-	cmd := rd.Get(key)
 
 	if err := cmd.Scan(an); err != nil {
 		return nil, fmt.Errorf("failed to scan key %q with error %v", key, err)
@@ -96,21 +173,63 @@ func (h *Handler) Get(key string) (*adnetwork.AdNetwork, error) {
 	return an, nil
 }
 
+// GetMany fetches networks of all given countries in a single lookup.
+// Countries without a stored network are left out of the result,
+// countries whose stored network fails to unmarshal are returned in failed instead.
+func (h *Handler) GetMany(keys []string) (map[string]*adnetwork.AdNetwork, map[string]error, error) {
+	h.log.WithFields(logrus.Fields{
+		"type": "get many",
+		"keys": len(keys),
+	}).Debug("init")
+	out, failed := map[string]*adnetwork.AdNetwork{}, map[string]error{}
+	if len(keys) == 0 {
+		return out, failed, nil
+	}
+
+	vals, err := config.GetInstance().RedisClient.HMGet(networksKey+h.tenant, keys...).Result()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to fetch keys")
+	}
+
+	for i, val := range vals {
+		s, ok := val.(string)
+		if !ok {
+			continue
+		}
+
+		an := &adnetwork.AdNetwork{}
+		if err = an.UnmarshalBinary([]byte(s)); err != nil {
+			failed[keys[i]] = errors.Wrapf(err, "failed to unmarshal key %q", keys[i])
+			continue
+		}
+		out[keys[i]] = an
+	}
+
+	return out, failed, nil
+}
+
 // GetRandom fetches a random value from redis
+// Countries are picked by redis with HRANDFIELD (redis 6.2 or newer), without listing the dataset.
 func (h *Handler) GetRandom() (*adnetwork.AdNetwork, error) {
 	h.log.WithFields(logrus.Fields{
 		"type": "random fetch",
 	}).Debug("init")
-	an := &adnetwork.AdNetwork{}
 	rd := config.GetInstance().RedisClient
 
-	key := rd.RandomKey().Val()
-	cmd := rd.Get(key)
-	if err := cmd.Scan(an); err != nil {
-		return nil, fmt.Errorf("failed to scan key %q with error %v", key, err)
+	key, err := rd.Do("HRANDFIELD", networksKey+h.tenant).String()
+	if err == redis.Nil {
+		return nil, errors.New("empty dataset")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch random key")
 	}
 
-	return an, nil
+	return h.Get(key)
+}
+
+// Size returns the number of countries stored.
+func (h *Handler) Size() (int64, error) {
+	return config.GetInstance().RedisClient.HLen(networksKey + h.tenant).Result()
 }
 
 // Load is the main method to simulate fetching data from pipeline.
@@ -125,6 +244,15 @@ func (h *Handler) Load() (map[string]*adnetwork.AdNetwork, error) {
 		return nil, errors.Wrap(err, "failed to unmrashal pipeline data")
 	}
 
+	for _, network := range load.AdNetwork {
+		code, ok := country.Normalize(network.Country)
+		if !ok {
+			return nil, fmt.Errorf("unknown ISO 3166-1 country code: %q", network.Country)
+		}
+		network.Country = code
+	}
+
+	h.Canonicalize(load.AdNetwork)
 	filtered := h.Prefilter(load.AdNetwork)
 
 	if len(filtered) == 0 {
@@ -136,7 +264,8 @@ func (h *Handler) Load() (map[string]*adnetwork.AdNetwork, error) {
 
 // Store the prefiltered data to redis. dropDB will drop the database before refilling it back up,
 // otherwise non-overwritten old records will remain in database.
-func (h *Handler) Store(mappings map[string]*adnetwork.AdNetwork, dropDB bool) error {
+// The stored dataset is returned with its new version, callers announce it.
+func (h *Handler) Store(mappings map[string]*adnetwork.AdNetwork, dropDB bool) (*Stored, error) {
 	h.log.WithField("type", "store").Debug("init")
 	rd := config.GetInstance().RedisClient
 	// Not removing old data because it's better to have non-optimal list rather than an empty one.
@@ -147,16 +276,32 @@ func (h *Handler) Store(mappings map[string]*adnetwork.AdNetwork, dropDB bool) e
 	// to happen at api call in case of a random hit.
 	// Keeping old data might cause hitting old random sets when original countries do not exist with small sets.
 	// (searching for a not existing set (exp. SI), and hitting a not updated set for some other country (exp. GER))
+	key := networksKey + h.tenant
+
+	countries := make([]string, 0, len(mappings))
+	for country := range mappings {
+		countries = append(countries, country)
+	}
+	sort.Strings(countries)
+
 	pipe := rd.TxPipeline()
 
+	// The previous dataset is read in the same transaction, so concurrent stores are diffed against each other.
+	// Only stored countries are compared, unless the whole dataset is replaced.
+	var previousAll *redis.StringStringMapCmd
+	var previous *redis.SliceCmd
 	if dropDB {
-		pipe.FlushDB()
+		previousAll = pipe.HGetAll(key)
+		// Only the dataset of the tenant is dropped.
+		pipe.Del(key)
+	} else if len(countries) > 0 {
+		previous = pipe.HMGet(key, countries...)
 	}
 
 	for country, adNetwork := range mappings {
 		// no TTL because it's better to have non-optimal list to an empty one.
 
-		if err := pipe.Set(country, adNetwork, 0).Err(); err != nil {
+		if err := pipe.HSet(key, country, adNetwork).Err(); err != nil {
 			// log errors and continue
 			h.log.Error(errors.Wrapf(err, "failed to set %q with error", country))
 		}
@@ -164,17 +309,89 @@ func (h *Handler) Store(mappings map[string]*adnetwork.AdNetwork, dropDB bool) e
 	}
 
 	if _, err := pipe.Exec(); err != nil {
-		return errors.Wrap(err, "failed to exec transaction")
+		return nil, errors.Wrap(err, "failed to exec transaction")
 	}
 
-	return nil
+	version, err := config.GetInstance().MetaRedisClient.Incr(versionKey + h.tenant).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to bump dataset version")
+	}
+
+	// The diff is only a summary for announcements, the dataset is stored without it.
+	stored := map[string]string{}
+	switch {
+	case previousAll != nil:
+		stored = previousAll.Val()
+	case previous != nil:
+		for i, value := range previous.Val() {
+			if b, ok := value.(string); ok {
+				stored[countries[i]] = b
+			}
+		}
+	}
+
+	return &Stored{
+		Version:   version,
+		Countries: countries,
+		Wiped:     dropDB,
+		Diff:      diffDataset(stored, mappings, dropDB),
+	}, nil
+}
+
+// diffDataset summarizes changes of mappings against the previous dataset, as stored in redis.
+// Countries missing from mappings are only removed when the dataset is dropped.
+func diffDataset(previous map[string]string, mappings map[string]*adnetwork.AdNetwork, dropDB bool) *Diff {
+	diff := &Diff{Added: []string{}, Updated: []string{}, Removed: []string{}}
+	for country, adNetwork := range mappings {
+		old, ok := previous[country]
+		if !ok {
+			diff.Added = append(diff.Added, country)
+			continue
+		}
+
+		b, err := adNetwork.MarshalBinary()
+		if err != nil || string(b) != old {
+			diff.Updated = append(diff.Updated, country)
+			continue
+		}
+
+		diff.Unchanged++
+	}
+
+	if dropDB {
+		for country := range previous {
+			if _, ok := mappings[country]; !ok {
+				diff.Removed = append(diff.Removed, country)
+			}
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Updated)
+	sort.Strings(diff.Removed)
+
+	return diff
+}
+
+// Version returns the current dataset version, incremented on every store.
+// Zero means no dataset was stored yet.
+func (h *Handler) Version() (int64, error) {
+	v, err := config.GetInstance().MetaRedisClient.Get(versionKey + h.tenant).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to fetch dataset version")
+	}
+
+	return v, nil
 }
 
 // LoadPrefilter loads prefilter settings and mappings from config file.
 func (h *Handler) LoadPrefilter() error {
-	h.log.WithField("filename", config.GetInstance().Prefilter).Debug("load prefilter")
+	h.log.WithField("filename", h.prefilterFile).Debug("load prefilter")
 
-	b, err := ioutil.ReadFile(config.GetInstance().Prefilter)
+	b, err := ioutil.ReadFile(h.prefilterFile)
 	if err != nil {
 		return errors.Wrap(err, "failed to read from prefilter config")
 	}
@@ -183,14 +400,43 @@ func (h *Handler) LoadPrefilter() error {
 		return errors.Wrap(err, "failed to load unmarshal prefilters")
 	}
 
+	for i, prefilter := range h.PrefilterMappings {
+		for _, providers := range prefilter.Args {
+			if err = h.canonicalRules(h.prefilterFile, providers); err != nil {
+				return err
+			}
+		}
+
+		switch prefilter.FilterType {
+		case excludeCountry:
+			// Excluded countries are matched against normalized dataset countries.
+			args := make(map[string][]string, len(prefilter.Args))
+			for key, sdks := range prefilter.Args {
+				code, ok := country.Normalize(key)
+				if !ok {
+					return fmt.Errorf("unknown ISO 3166-1 country code in prefilter: %q", key)
+				}
+				args[code] = append(args[code], sdks...)
+			}
+			h.PrefilterMappings[i].Args = args
+		case minScore, topN:
+			values, err := scopeValues(prefilter)
+			if err != nil {
+				return err
+			}
+			h.PrefilterMappings[i].Values = values
+		}
+	}
+	h.prefilterSum = sha256.Sum256(b)
+
 	return nil
 }
 
 // LoadPostfilter loads postfilter settings and mappings from config file.
 func (h *Handler) LoadPostfilter() error {
-	h.log.WithField("filename", config.GetInstance().Postfilter).Debug("load postfiler")
+	h.log.WithField("filename", h.postfilterFile).Debug("load postfiler")
 
-	b, err := ioutil.ReadFile(config.GetInstance().Postfilter)
+	b, err := ioutil.ReadFile(h.postfilterFile)
 	if err != nil {
 		return errors.Wrap(err, "failed to read from prefilter config")
 	}
@@ -199,9 +445,78 @@ func (h *Handler) LoadPostfilter() error {
 		return errors.Wrap(err, "failed to load unmarshal postfilter")
 	}
 
+	for _, args := range h.PostfilterMappings.OsVersion.Args {
+		if err = h.canonicalRules(h.postfilterFile, args.Exclude); err != nil {
+			return err
+		}
+	}
+	for _, args := range h.PostfilterMappings.Device.Args {
+		if err = h.canonicalRules(h.postfilterFile, args.Exclude); err != nil {
+			return err
+		}
+	}
+	h.postfilterSum = sha256.Sum256(b)
+
 	return nil
 }
 
+// replaces provider names of rules with their canonical IDs, unknown providers fail loading when strict in Config.
+func (h *Handler) canonicalRules(filename string, providers []string) error {
+	registry := provider.GetInstance()
+	for i, name := range providers {
+		id, ok := registry.Canonical(name)
+		if !ok {
+			if config.GetInstance().ProviderRulesStrict {
+				return fmt.Errorf("unknown provider in %s: %q", filename, name)
+			}
+			h.log.WithFields(logrus.Fields{"filename": filename, "provider": name}).Warn("unknown provider in rules")
+		}
+		providers[i] = id
+	}
+
+	return nil
+}
+
+// Canonicalize replaces provider names of the dataset with their canonical IDs, for rules to match them.
+// Unknown providers are kept as named. Settings are dropped, they are joined from provider metadata when served.
+func (h *Handler) Canonicalize(an []*adnetwork.AdNetwork) {
+	registry := provider.GetInstance()
+	unknown := map[string]bool{}
+	for _, network := range an {
+		for _, list := range [][]*adnetwork.SDK{network.Banner, network.Interstitial, network.Video} {
+			for _, sdk := range list {
+				if sdk == nil {
+					continue
+				}
+
+				id, ok := registry.Canonical(sdk.Provider)
+				if !ok {
+					unknown[id] = true
+				}
+				sdk.Provider, sdk.Settings = id, nil
+			}
+		}
+	}
+
+	if len(unknown) > 0 {
+		names := make([]string, 0, len(unknown))
+		for name := range unknown {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		h.log.WithField("providers", names).Warn("unknown providers in dataset")
+	}
+}
+
+// RulesVersion identifies the loaded pre- and postfilter rules, it changes whenever their content does.
+func (h *Handler) RulesVersion() string {
+	sum := sha256.New()
+	sum.Write(h.prefilterSum[:])
+	sum.Write(h.postfilterSum[:])
+
+	return hex.EncodeToString(sum.Sum(nil))[:16]
+}
+
 // OsVersion implements filtering by operating system and its version.
 func (h *Handler) OsVersion(os, version string, an *adnetwork.AdNetwork) *adnetwork.AdNetwork {
 	h.log.WithFields(logrus.Fields{
@@ -318,6 +633,57 @@ func (h *Handler) MutualPriority(an *adnetwork.AdNetwork, providers []string) *a
 	return an
 }
 
+// MinScore removes providers scoring below the minimum of the rule for the country and ad type of each list.
+func (h *Handler) MinScore(an *adnetwork.AdNetwork, rule Prefilter) *adnetwork.AdNetwork {
+	h.log.WithFields(logrus.Fields{
+		"type":    "prefilter",
+		"name":    "min_score",
+		"country": an.Country,
+	}).Debug("init")
+
+	filter := func(adType string, arr []*adnetwork.SDK) []*adnetwork.SDK {
+		threshold, ok := rule.value(an.Country, adType)
+		if !ok {
+			return arr
+		}
+
+		out := []*adnetwork.SDK{}
+		for _, sdk := range arr {
+			if sdk.Score >= threshold {
+				out = append(out, sdk)
+			}
+		}
+		return out
+	}
+
+	an.Banner = filter(adnetwork.Banner, an.Banner)
+	an.Interstitial = filter(adnetwork.Interstitial, an.Interstitial)
+	an.Video = filter(adnetwork.Video, an.Video)
+	return an
+}
+
+// TopN truncates each list to the number of providers of the rule for its country and ad type,
+// lists have to be sorted by score.
+func (h *Handler) TopN(an *adnetwork.AdNetwork, rule Prefilter) *adnetwork.AdNetwork {
+	h.log.WithFields(logrus.Fields{
+		"type":    "prefilter",
+		"name":    "top_n",
+		"country": an.Country,
+	}).Debug("init")
+
+	truncate := func(adType string, arr []*adnetwork.SDK) []*adnetwork.SDK {
+		if n, ok := rule.value(an.Country, adType); ok && len(arr) > int(n) {
+			return arr[:int(n)]
+		}
+		return arr
+	}
+
+	an.Banner = truncate(adnetwork.Banner, an.Banner)
+	an.Interstitial = truncate(adnetwork.Interstitial, an.Interstitial)
+	an.Video = truncate(adnetwork.Video, an.Video)
+	return an
+}
+
 // Prefilter ...
 // Current implementation is such that no complex channel implementations are
 // required in order to implement a concurrency model for faster prefiltering.
@@ -346,12 +712,21 @@ func (h *Handler) Prefilter(an []*adnetwork.AdNetwork) []*adnetwork.AdNetwork {
 					for _, args := range prefilter.Args {
 						an = h.MutualPriority(an, args)
 					}
+				case minScore:
+					an = h.MinScore(an, prefilter)
 				}
 			}
 
 			sort.Sort(adnetwork.ScoreSorter(an.Banner))
 			sort.Sort(adnetwork.ScoreSorter(an.Interstitial))
 			sort.Sort(adnetwork.ScoreSorter(an.Video))
+
+			// Lists are capped once sorted, keeping the providers with the highest score.
+			for _, prefilter := range h.PrefilterMappings {
+				if prefilter.FilterType == topN {
+					an = h.TopN(an, prefilter)
+				}
+			}
 			ch <- an
 
 		}(network, ch)
@@ -373,11 +748,16 @@ func (h *Handler) SetLogger(entry *logrus.Entry) {
 	h.log = entry
 }
 
-// ToCountryMap returns an array of ad networks as a map[country]network
+// ToCountryMap returns an array of ad networks as a map[country]network,
+// countries must be normalized ISO 3166-1 alpha-2 codes.
 func ToCountryMap(an []*adnetwork.AdNetwork) (map[string]*adnetwork.AdNetwork, error) {
 	m := map[string]*adnetwork.AdNetwork{}
 
 	for _, network := range an {
+		if !country.Valid(network.Country) {
+			return m, fmt.Errorf("invalid country code: %q", network.Country)
+		}
+
 		if exists := m[network.Country]; exists != nil {
 			return m, fmt.Errorf("key exists in map: %s", network.Country)
 		}
@@ -388,6 +768,61 @@ func ToCountryMap(an []*adnetwork.AdNetwork) (map[string]*adnetwork.AdNetwork, e
 	return m, nil
 }
 
+// returns values of the score or top-N rule by normalized scope.
+// Scores must not be negative, top-N values must be positive integers.
+func scopeValues(rule Prefilter) (map[string]float64, error) {
+	values := make(map[string]float64, len(rule.Values))
+	for scope, v := range rule.Values {
+		key, ok := normalizeScope(scope)
+		if !ok {
+			return nil, fmt.Errorf("invalid scope of %s prefilter: %q", rule.FilterType, scope)
+		}
+		if _, exists := values[key]; exists {
+			return nil, fmt.Errorf("duplicate scope of %s prefilter: %q", rule.FilterType, scope)
+		}
+
+		if rule.FilterType == topN && (v < 1 || v != math.Trunc(v)) {
+			return nil, fmt.Errorf("%s prefilter of %q must be a positive integer: %v", rule.FilterType, scope, v)
+		}
+		if rule.FilterType == minScore && v < 0 {
+			return nil, fmt.Errorf("%s prefilter of %q must not be negative: %v", rule.FilterType, scope, v)
+		}
+		values[key] = v
+	}
+
+	return values, nil
+}
+
+// returns the scope with its country normalized, false if it is not "*", a country, an ad type or "country/adType".
+func normalizeScope(scope string) (string, bool) {
+	if scope == anyScope || containsString(adnetwork.AdTypes, scope) {
+		return scope, true
+	}
+
+	parts := strings.Split(scope, "/")
+	code, ok := country.Normalize(parts[0])
+	switch {
+	case !ok || len(parts) > 2:
+		return "", false
+	case len(parts) == 1:
+		return code, true
+	case !containsString(adnetwork.AdTypes, parts[1]):
+		return "", false
+	}
+
+	return code + "/" + parts[1], true
+}
+
+// returns tenant specific rules file if it exists, otherwise the default one.
+func tenantFile(c *config.Config, tenant, name, fallback string) string {
+	filename := filepath.Join(c.TenantRulesDir, tenant, name)
+	if _, err := os.Stat(filename); err == nil {
+		return filename
+	}
+
+	return fallback
+}
+
 // removes sdks from network if they contain given providers.
 func excludeFromSDK(arr []*adnetwork.SDK, providers []string) []*adnetwork.SDK {
 	out := []*adnetwork.SDK{}
@@ -5,7 +5,10 @@ import (
 	"expertisetest/adnetwork"
 	"expertisetest/config"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -16,6 +19,7 @@ func TestMain(m *testing.M) {
 	config.GetInstance().Pipefile = "pipefile_test.json"
 	config.GetInstance().Prefilter = "prefilter.json"
 	config.GetInstance().Postfilter = "postfilter.json"
+	config.GetInstance().Providers = "providers.json"
 	config.GetInstance().DisableLogging()
 	os.Exit(m.Run())
 }
@@ -93,18 +97,22 @@ func TestLoad(t *testing.T) {
 		t.Error(err)
 	}
 	for country, network := range m {
-		expected := an[country]
-		if expected == nil {
+		if an[country] == nil {
 			t.Logf("nil entry in map: %s", country)
 			t.Fail()
+			continue
 		}
 
+		// Fixtures spell providers as the pipeline does, loading canonicalizes them.
+		expected := clone(t, an[country])
+		h.Canonicalize([]*adnetwork.AdNetwork{expected})
+
 		byteGot, err := json.MarshalIndent(network, "", "  ")
 		if err != nil {
 			t.Errorf("failed to marshal network: %v", err)
 		}
 
-		byteExpected, err := json.MarshalIndent(an[country], "", "  ")
+		byteExpected, err := json.MarshalIndent(expected, "", "  ")
 		if err != nil {
 			t.Errorf("failed to marshal expected: %v", err)
 		}
@@ -169,3 +177,358 @@ func TestExcludeFromSDK(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadPrefilterCountries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prefilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := &Handler{log: GetInstance().log, prefilterFile: filepath.Join(dir, "prefilter.json")}
+
+	rules := []byte(`{"prefilterMappings":[{"type":"excCtr","args":{"uk":["AdMob"],"GBR":["Facebook"]}},{"type":"mutPri","args":{"p1":["AdMob"]}}]}`)
+	if err = ioutil.WriteFile(h.prefilterFile, rules, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = h.LoadPrefilter(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := h.PrefilterMappings[0].Args; len(got) != 1 || len(got["GB"]) != 2 {
+		t.Errorf("Got: %v Expected: countries normalized to GB", got)
+	}
+
+	if got := h.PrefilterMappings[1].Args; len(got["p1"]) != 1 {
+		t.Errorf("Got: %v Expected: mutual priority args untouched", got)
+	}
+
+	rules = []byte(`{"prefilterMappings":[{"type":"excCtr","args":{"EN":["AdMob"]}}]}`)
+	if err = ioutil.WriteFile(h.prefilterFile, rules, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = h.LoadPrefilter(); err == nil {
+		t.Error("expected error for unknown country")
+	}
+}
+
+func TestLoadRulesProviders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := &Handler{
+		log:            GetInstance().log,
+		prefilterFile:  filepath.Join(dir, "prefilter.json"),
+		postfilterFile: filepath.Join(dir, "postfilter.json"),
+	}
+
+	prefilter := []byte(`{"prefilterMappings":[{"type":"excCtr","args":{"US":["Huawei Ads","Moloco"]}},{"type":"mutPri","args":{"p1":["admob","AdMob OptOut"]}}]}`)
+	postfilter := []byte(`{"postfilterMappings":{"osVersion":{"args":[{"os":"android","versions":["9"],"exclude":["unity-ads"]}]},"device":{"args":[{"type":"tablet","exclude":["ADX"]}]}}}`)
+	if err = ioutil.WriteFile(h.prefilterFile, prefilter, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(h.postfilterFile, postfilter, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = h.LoadPrefilter(); err != nil {
+		t.Fatal(err)
+	}
+	if err = h.LoadPostfilter(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unknown providers are kept when not strict.
+	if got := h.PrefilterMappings[0].Args["US"]; !reflect.DeepEqual(got, []string{"HuaweiAds", "Moloco"}) {
+		t.Errorf("Got: %v Expected: [HuaweiAds Moloco]", got)
+	}
+	if got := h.PrefilterMappings[1].Args["p1"]; !reflect.DeepEqual(got, []string{"AdMob", "AdMob-OptOut"}) {
+		t.Errorf("Got: %v Expected: [AdMob AdMob-OptOut]", got)
+	}
+	if got := h.PostfilterMappings.OsVersion.Args[0].Exclude; !reflect.DeepEqual(got, []string{"UnityAds"}) {
+		t.Errorf("Got: %v Expected: [UnityAds]", got)
+	}
+	if got := h.PostfilterMappings.Device.Args[0].Exclude; !reflect.DeepEqual(got, []string{"Adx"}) {
+		t.Errorf("Got: %v Expected: [Adx]", got)
+	}
+
+	c := config.GetInstance()
+	defer func() { c.ProviderRulesStrict = false }()
+	c.ProviderRulesStrict = true
+
+	if err = h.LoadPrefilter(); err == nil {
+		t.Error("expected error for unknown provider")
+	}
+	if err = h.LoadPostfilter(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadPrefilterScopes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prefilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := &Handler{log: GetInstance().log, prefilterFile: filepath.Join(dir, "prefilter.json")}
+
+	rules := []byte(`{"prefilterMappings":[{"type":"minScore","values":{"*":1,"usa":2.5,"video":3,"uk/video":4}},{"type":"topN","values":{"*":10,"banner":2}}]}`)
+	if err = ioutil.WriteFile(h.prefilterFile, rules, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = h.LoadPrefilter(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]float64{"*": 1, "US": 2.5, "video": 3, "GB/video": 4}
+	if got := h.PrefilterMappings[0].Values; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Got: %v Expected: %v", got, expected)
+	}
+
+	for _, invalid := range []string{
+		`{"type":"minScore","values":{"EN":1}}`,
+		`{"type":"minScore","values":{"US/native":1}}`,
+		`{"type":"minScore","values":{"US/video/SI":1}}`,
+		`{"type":"minScore","values":{"US":1,"usa":2}}`,
+		`{"type":"minScore","values":{"*":-1}}`,
+		`{"type":"topN","values":{"*":0}}`,
+		`{"type":"topN","values":{"*":2.5}}`,
+	} {
+		rules = []byte(`{"prefilterMappings":[` + invalid + `]}`)
+		if err = ioutil.WriteFile(h.prefilterFile, rules, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = h.LoadPrefilter(); err == nil {
+			t.Errorf("%s: expected error", invalid)
+		}
+	}
+}
+
+func TestPrefilterScores(t *testing.T) {
+	h := &Handler{log: GetInstance().log, PrefilterMappings: []Prefilter{
+		{FilterType: topN, Values: map[string]float64{"*": 3, "SI/banner": 1}},
+		{FilterType: minScore, Values: map[string]float64{"*": 2, "SI": 4, "video": 1}},
+	}}
+
+	networks := func() []*adnetwork.AdNetwork {
+		sdks := func() []*adnetwork.SDK {
+			return []*adnetwork.SDK{
+				{Provider: "AdMob", Score: 1.5}, {Provider: "Facebook", Score: 9}, {Provider: "Vungle", Score: 5},
+				{Provider: "Moloco", Score: 3}, {Provider: "Adx", Score: 7},
+			}
+		}
+		return []*adnetwork.AdNetwork{
+			{Country: "SI", Banner: sdks(), Interstitial: sdks(), Video: sdks()},
+			{Country: "US", Banner: sdks(), Interstitial: sdks(), Video: sdks()},
+		}
+	}
+
+	tests := []struct {
+		country                     string
+		banner, interstitial, video []string
+	}{
+		// Lists are capped after the minimum score removed providers, keeping the highest scores.
+		{"SI", []string{"Facebook"}, []string{"Facebook", "Adx", "Vungle"}, []string{"Facebook", "Adx", "Vungle"}},
+		{"US", []string{"Facebook", "Adx", "Vungle"}, []string{"Facebook", "Adx", "Vungle"}, []string{"Facebook", "Adx", "Vungle"}},
+	}
+
+	m, err := ToCountryMap(h.Prefilter(networks()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		an := m[tt.country]
+		if got := providers(an.Banner); !reflect.DeepEqual(got, tt.banner) {
+			t.Errorf("%s banner: Got: %v Expected: %v", tt.country, got, tt.banner)
+		}
+		if got := providers(an.Interstitial); !reflect.DeepEqual(got, tt.interstitial) {
+			t.Errorf("%s interstitial: Got: %v Expected: %v", tt.country, got, tt.interstitial)
+		}
+		if got := providers(an.Video); !reflect.DeepEqual(got, tt.video) {
+			t.Errorf("%s video: Got: %v Expected: %v", tt.country, got, tt.video)
+		}
+	}
+
+	// Minimum scores alone leave lists uncapped.
+	h.PrefilterMappings = h.PrefilterMappings[1:]
+	if m, err = ToCountryMap(h.Prefilter(networks())); err != nil {
+		t.Fatal(err)
+	}
+	if got := providers(m["US"].Video); !reflect.DeepEqual(got, []string{"Facebook", "Adx", "Vungle", "Moloco", "AdMob"}) {
+		t.Errorf("US video: Got: %v", got)
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	an := []*adnetwork.AdNetwork{{
+		Banner: []*adnetwork.SDK{{Provider: "Huawei Ads"}, {Provider: "Moloco"}},
+		Video:  []*adnetwork.SDK{{Provider: "unity ads"}},
+	}}
+
+	GetInstance().Canonicalize(an)
+
+	if an[0].Banner[0].Provider != "HuaweiAds" || an[0].Banner[1].Provider != "Moloco" || an[0].Video[0].Provider != "UnityAds" {
+		t.Errorf("unexpected providers: %+v %+v", an[0].Banner, an[0].Video)
+	}
+}
+
+func TestPrefilterCanonical(t *testing.T) {
+	h := GetInstance()
+
+	// The rule excluding "Huawei Ads" from US matches the provider once canonicalized, CN keeps it.
+	cn, us := clone(t, an["CN"]), clone(t, an["CN"])
+	us.Country = "US"
+	networks := []*adnetwork.AdNetwork{cn, us}
+	h.Canonicalize(networks)
+
+	m, err := ToCountryMap(h.Prefilter(networks))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := providers(m["CN"].Banner); !reflect.DeepEqual(got, []string{"AdMob-OptOut", "HuaweiAds"}) {
+		t.Errorf("CN: Got: %v Expected: [AdMob-OptOut HuaweiAds]", got)
+	}
+	if got := providers(m["US"].Banner); !reflect.DeepEqual(got, []string{"AdMob-OptOut"}) {
+		t.Errorf("US: Got: %v Expected: [AdMob-OptOut]", got)
+	}
+}
+
+// returns a deep copy of the network, so fixtures are left untouched.
+func clone(t *testing.T, n *adnetwork.AdNetwork) *adnetwork.AdNetwork {
+	b, err := n.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &adnetwork.AdNetwork{}
+	if err = out.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+
+	return out
+}
+
+func providers(arr []*adnetwork.SDK) []string {
+	out := []string{}
+	for _, sdk := range arr {
+		out = append(out, sdk.Provider)
+	}
+	return out
+}
+
+func TestToCountryMap(t *testing.T) {
+	if _, err := ToCountryMap([]*adnetwork.AdNetwork{{Country: "US"}, {Country: "SI"}}); err != nil {
+		t.Error(err)
+	}
+
+	for _, invalid := range [][]*adnetwork.AdNetwork{
+		{{Country: "US"}, {Country: "US"}},
+		{{Country: "EN"}},
+		{{Country: "us"}},
+	} {
+		if _, err := ToCountryMap(invalid); err == nil {
+			t.Errorf("%s: expected error", invalid[len(invalid)-1].Country)
+		}
+	}
+}
+
+func TestForTenant(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tenants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Tenant "tom" overrides prefilter rules, postfilter rules fall back to default.
+	if err = os.Mkdir(filepath.Join(dir, "tom"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := []byte(`{"prefilterMappings":[{"type":"excCtr","args":{"SI":["AdMob"]}}]}`)
+	if err = ioutil.WriteFile(filepath.Join(dir, "tom", "prefilter.json"), rules, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := config.GetInstance()
+	tenants, rulesDir := c.Tenants, c.TenantRulesDir
+	defer func() { c.Tenants, c.TenantRulesDir = tenants, rulesDir }()
+	c.Tenants = append([]string{"tom", "angela"}, tenants...)
+	c.TenantRulesDir = dir
+
+	if _, err = ForTenant("ben"); err != ErrUnknownTenant {
+		t.Errorf("Got: %v Expected: %v", err, ErrUnknownTenant)
+	}
+
+	tom, err := ForTenant("tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if same, _ := ForTenant("tom"); same != tom {
+		t.Error("expected cached handler")
+	}
+
+	if len(tom.PrefilterMappings) != 1 || tom.PrefilterMappings[0].Args["SI"][0] != "AdMob" {
+		t.Errorf("unexpected prefilter: %+v", tom.PrefilterMappings)
+	}
+
+	angela, err := ForTenant("angela")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(angela.PrefilterMappings) != len(GetInstance().PrefilterMappings) {
+		t.Errorf("unexpected prefilter: %+v", angela.PrefilterMappings)
+	}
+
+	if len(tom.PostfilterMappings.Device.Args) != len(GetInstance().PostfilterMappings.Device.Args) {
+		t.Errorf("unexpected postfilter: %+v", tom.PostfilterMappings)
+	}
+
+	if tom.RulesVersion() == angela.RulesVersion() {
+		t.Error("expected rules version to change with rules")
+	}
+
+	if angela.RulesVersion() != GetInstance().RulesVersion() {
+		t.Error("expected same rules version for same rules")
+	}
+}
+
+func TestDiffDataset(t *testing.T) {
+	stored, err := an["CN"].MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := map[string]string{"CN": string(stored), "SI": string(stored), "DE": "{}"}
+	mappings := map[string]*adnetwork.AdNetwork{"CN": an["CN"], "DE": an["CN"], "US": an["CN"]}
+
+	tests := []struct {
+		dropDB   bool
+		expected string
+	}{
+		{false, `{"added":["US"],"updated":["DE"],"removed":[],"unchanged":1}`},
+		{true, `{"added":["US"],"updated":["DE"],"removed":["SI"],"unchanged":1}`},
+	}
+
+	for _, tt := range tests {
+		b, err := json.Marshal(diffDataset(previous, mappings, tt.dropDB))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(b) != tt.expected {
+			t.Errorf("dropDB=%v: Got: %s Expected: %s", tt.dropDB, b, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"expertisetest/config"
+	"expertisetest/country"
+
+	"github.com/pkg/errors"
+)
+
+// legacyPattern matches the keys of datasets stored before tenants, one top-level key per country code.
+const legacyPattern = "??"
+
+// MigrateLegacy moves a dataset stored before tenants, as top-level country keys, into the dataset of the tenant,
+// unless the tenant already has one. Moved keys are removed and the number of moved countries is returned.
+func (h *Handler) MigrateLegacy() (int, error) {
+	rd := config.GetInstance().RedisClient
+	key := networksKey + h.tenant
+
+	exists, err := rd.Exists(key).Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to check dataset")
+	}
+	if exists > 0 {
+		return 0, nil
+	}
+
+	keys := []string{}
+	iter := rd.Scan(0, legacyPattern, 1000).Iterator()
+	for iter.Next() {
+		if code, ok := country.Normalize(iter.Val()); ok && code == iter.Val() {
+			keys = append(keys, code)
+		}
+	}
+	if err = iter.Err(); err != nil {
+		return 0, errors.Wrap(err, "failed to scan legacy dataset")
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	// Keys holding anything but a string are not countries of a dataset and are answered as nil.
+	vals, err := rd.MGet(keys...).Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to fetch legacy dataset")
+	}
+
+	legacy := map[string]interface{}{}
+	for i, val := range vals {
+		if b, ok := val.(string); ok {
+			legacy[keys[i]] = b
+		}
+	}
+	if len(legacy) == 0 {
+		return 0, nil
+	}
+
+	pipe := rd.TxPipeline()
+	pipe.HMSet(key, legacy)
+	for country := range legacy {
+		pipe.Del(country)
+	}
+	if _, err = pipe.Exec(); err != nil {
+		return 0, errors.Wrap(err, "failed to move legacy dataset")
+	}
+
+	// Lists cached before the move are stale, as after any store.
+	if err = config.GetInstance().MetaRedisClient.Incr(versionKey + h.tenant).Err(); err != nil {
+		return 0, errors.Wrap(err, "failed to bump dataset version")
+	}
+
+	return len(legacy), nil
+}
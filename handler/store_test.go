@@ -18,6 +18,7 @@ func TestStore(t *testing.T) {
 	config.GetInstance().Pipefile = "pipefile_test.json"
 	config.GetInstance().Prefilter = "prefilter.json"
 	config.GetInstance().Postfilter = "postfilter.json"
+	config.GetInstance().Providers = "providers.json"
 
 	config.GetInstance().DisableLogging()
 
@@ -29,13 +30,13 @@ func TestStore(t *testing.T) {
 		t.Error(err)
 	}
 
-	if err := h.Store(networks, true); err != nil {
+	if _, err := h.Store(networks, true); err != nil {
 		t.Error(err)
 	}
 
 	for key := range networks {
 		an := &adnetwork.AdNetwork{}
-		cmd := rd.Get(key)
+		cmd := rd.HGet(networksKey+h.Tenant(), key)
 		if err := cmd.Scan(an); err != nil {
 			t.Errorf("failed to scan key %q with error %v", key, err)
 		}
@@ -56,3 +57,101 @@ func TestStore(t *testing.T) {
 		}
 	}
 }
+
+func TestGetMany(t *testing.T) {
+	config.OverrideInstance(config.NewTestDB())
+	config.GetInstance().Pipefile = "pipefile_test.json"
+	config.GetInstance().Prefilter = "prefilter.json"
+	config.GetInstance().Postfilter = "postfilter.json"
+	config.GetInstance().Providers = "providers.json"
+
+	config.GetInstance().DisableLogging()
+
+	h := GetInstance()
+	networks, err := h.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = h.Store(networks, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = config.GetInstance().RedisClient.HSet(networksKey+h.Tenant(), "XX", "corrupt").Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{"missing", "XX"}
+	for key := range networks {
+		keys = append(keys, key)
+	}
+
+	got, failed, err := h.GetMany(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(networks) {
+		t.Errorf("expected %d networks, got %d", len(networks), len(got))
+	}
+
+	if _, ok := got["missing"]; ok {
+		t.Error("expected missing country to be left out")
+	}
+
+	if len(failed) != 1 || failed["XX"] == nil {
+		t.Errorf("expected only the corrupt country to fail, got %v", failed)
+	}
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	config.OverrideInstance(config.NewTestDB())
+	config.GetInstance().Pipefile = "pipefile_test.json"
+	config.GetInstance().Prefilter = "prefilter.json"
+	config.GetInstance().Postfilter = "postfilter.json"
+	config.GetInstance().Providers = "providers.json"
+
+	config.GetInstance().DisableLogging()
+
+	rd := config.GetInstance().RedisClient
+	h := GetInstance()
+
+	networks, err := h.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = rd.Del(networksKey + h.Tenant()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	for key, an := range networks {
+		if err = rd.Set(key, an, 0).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := h.MigrateLegacy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(networks) {
+		t.Errorf("Got: %d moved Expected: %d", n, len(networks))
+	}
+
+	for key := range networks {
+		if got, err := h.Get(key); err != nil || got == nil {
+			t.Errorf("expected %q to be moved, got %v %v", key, got, err)
+		}
+		if exists := rd.Exists(key).Val(); exists != 0 {
+			t.Errorf("expected legacy key %q to be removed", key)
+		}
+	}
+
+	if an, err := h.GetRandom(); err != nil || an == nil {
+		t.Errorf("expected a random network, got %v %v", an, err)
+	}
+
+	if n, err = h.MigrateLegacy(); err != nil || n != 0 {
+		t.Errorf("expected nothing to move, got %d %v", n, err)
+	}
+}
@@ -7,11 +7,33 @@ type filterType string
 const (
 	excludeCountry filterType = "excCtr"
 	mutualPriority filterType = "mutPri"
+	minScore       filterType = "minScore"
+	topN           filterType = "topN"
 )
 
+// anyScope is the scope of score and top-N rules matching every country and ad type.
+const anyScope = "*"
+
 // LoadObject simulates a json object returned by pipeline.
 type LoadObject struct {
-	AdNetwork []*adnetwork.AdNetwork `json:"data"`
+	AdNetwork []*adnetwork.AdNetwork `json:"data" validate:"required"`
+}
+
+// Stored describes a dataset stored by Store, for announcing it.
+type Stored struct {
+	Version int64
+	// Countries are the stored countries, sorted.
+	Countries []string
+	Wiped     bool
+	Diff      *Diff
+}
+
+// Diff summarizes the changes of a stored dataset against the previous one.
+type Diff struct {
+	Added     []string `json:"added"`
+	Updated   []string `json:"updated"`
+	Removed   []string `json:"removed"`
+	Unchanged int      `json:"unchanged"`
 }
 
 // OsVersion postfilter.
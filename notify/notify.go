@@ -0,0 +1,187 @@
+// Package notify announces published datasets and provider changes to every api instance through redis pub/sub.
+package notify
+
+import (
+	"expertisetest/config"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/pquerna/ffjson/ffjson"
+	"github.com/sirupsen/logrus"
+)
+
+// channel carries events in meta storage.
+const channel = "notify:datasets"
+
+var (
+	instance *Broker
+	once     sync.Once
+)
+
+// GetInstance always returns the same Broker, subscribed to events of all instances.
+func GetInstance() *Broker {
+	once.Do(func() {
+		instance = NewBroker()
+		go instance.run(config.GetInstance().MetaRedisClient.Subscribe(channel))
+	})
+	return instance
+}
+
+// KindProviders is the kind of events announcing changed provider metadata or kill switches,
+// which apply to the lists of every tenant and country.
+const KindProviders = "providers"
+
+// Event announces a dataset published by any instance, or a provider change when Kind is KindProviders.
+type Event struct {
+	// Kind is empty for published datasets.
+	Kind    string `json:"kind,omitempty"`
+	Tenant  string `json:"tenant,omitempty"`
+	Version int64  `json:"version"`
+	// Countries stored by the publish. When Wiped, countries that are not listed were removed.
+	Countries []string  `json:"countries"`
+	Wiped     bool      `json:"wiped,omitempty"`
+	Diff      *Diff     `json:"diff,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// Diff summarizes changes of a publish against the dataset it replaced, by country.
+type Diff struct {
+	Added     []string `json:"added"`
+	Updated   []string `json:"updated"`
+	Removed   []string `json:"removed"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// Changed returns added, updated and removed countries, sorted.
+func (d *Diff) Changed() []string {
+	out := append(append(append([]string{}, d.Added...), d.Updated...), d.Removed...)
+	sort.Strings(out)
+
+	return out
+}
+
+// AffectsTenant returns true if the event can change any list of the tenant.
+func (e *Event) AffectsTenant(tenant string) bool {
+	return e.Kind == KindProviders || e.Tenant == tenant
+}
+
+// Affects returns true if the event changes the dataset of the country in the tenant.
+// Without a diff, every stored country and all countries of a wiped dataset are assumed to change.
+// Provider changes affect every country.
+func (e *Event) Affects(tenant, country string) bool {
+	if e.Kind == KindProviders {
+		return true
+	}
+	if e.Tenant != tenant {
+		return false
+	}
+
+	countries := e.Countries
+	if e.Diff != nil {
+		countries = e.Diff.Changed()
+	} else if e.Wiped {
+		return true
+	}
+
+	for _, c := range countries {
+		if c == country {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Publish sends the event to subscribers of all instances.
+func Publish(e *Event) error {
+	b, err := ffjson.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal event")
+	}
+
+	if err = config.GetInstance().MetaRedisClient.Publish(channel, b).Err(); err != nil {
+		return errors.Wrap(err, "failed to publish event")
+	}
+
+	return nil
+}
+
+// Broker fans events out to local subscribers.
+type Broker struct {
+	mu     sync.Mutex
+	subs   map[chan *Event]struct{}
+	closed bool
+}
+
+// NewBroker returns a Broker without a storage subscription, events are only delivered by Broadcast.
+func NewBroker() *Broker {
+	return &Broker{subs: map[chan *Event]struct{}{}}
+}
+
+// Subscribe returns a channel receiving all events and a function cancelling the subscription.
+// Events are dropped for subscribers that fall more than buffer events behind.
+// The channel is closed on cancel or when the broker is closed.
+func (b *Broker) Subscribe(buffer int) (<-chan *Event, func()) {
+	c := make(chan *Event, buffer)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(c)
+		return c, func() {}
+	}
+	b.subs[c] = struct{}{}
+
+	return c, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subs[c]; ok {
+			delete(b.subs, c)
+			close(c)
+		}
+	}
+}
+
+// Close ends all subscriptions, so long lived requests waiting for events finish on shutdown.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for c := range b.subs {
+		delete(b.subs, c)
+		close(c)
+	}
+}
+
+// Broadcast delivers the event to local subscribers.
+func (b *Broker) Broadcast(e *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for c := range b.subs {
+		select {
+		case c <- e:
+		default:
+			logrus.WithFields(logrus.Fields{"type": "notify", "tenant": e.Tenant, "version": e.Version}).Warn("slow subscriber, event dropped")
+		}
+	}
+}
+
+// broadcasts events received from storage, until the subscription is closed.
+func (b *Broker) run(ps *redis.PubSub) {
+	for msg := range ps.Channel() {
+		e := &Event{}
+		if err := ffjson.Unmarshal([]byte(msg.Payload), e); err != nil {
+			logrus.WithField("type", "notify").Error(errors.Wrap(err, "failed to unmarshal event"))
+			continue
+		}
+
+		b.Broadcast(e)
+	}
+}
@@ -0,0 +1,128 @@
+package notify
+
+import (
+	"testing"
+)
+
+func TestAffects(t *testing.T) {
+	e := &Event{Tenant: "default", Version: 2, Countries: []string{"SI", "US"}}
+
+	tests := []struct {
+		tenant   string
+		country  string
+		wiped    bool
+		expected bool
+	}{
+		{"default", "SI", false, true},
+		{"default", "DE", false, false},
+		{"default", "DE", true, true},
+		{"other", "SI", false, false},
+		{"other", "SI", true, false},
+	}
+
+	for _, tt := range tests {
+		e.Wiped = tt.wiped
+		if got := e.Affects(tt.tenant, tt.country); got != tt.expected {
+			t.Errorf("%s %s wiped=%v: Got: %v Expected: %v", tt.tenant, tt.country, tt.wiped, got, tt.expected)
+		}
+	}
+}
+
+func TestAffectsDiff(t *testing.T) {
+	e := &Event{
+		Tenant:    "default",
+		Version:   3,
+		Countries: []string{"SI", "US"},
+		Wiped:     true,
+		Diff:      &Diff{Added: []string{"SI"}, Removed: []string{"DE"}, Unchanged: 1},
+	}
+
+	tests := []struct {
+		country  string
+		expected bool
+	}{
+		{"SI", true},
+		{"DE", true},
+		{"US", false},
+		{"FR", false},
+	}
+
+	for _, tt := range tests {
+		if got := e.Affects("default", tt.country); got != tt.expected {
+			t.Errorf("%s: Got: %v Expected: %v", tt.country, got, tt.expected)
+		}
+	}
+}
+
+func TestAffectsProviders(t *testing.T) {
+	e := &Event{Kind: KindProviders}
+
+	if !e.Affects("default", "SI") || !e.Affects("other", "DE") {
+		t.Error("expected provider changes to affect every country")
+	}
+
+	if !e.AffectsTenant("other") {
+		t.Error("expected provider changes to affect every tenant")
+	}
+
+	if (&Event{Tenant: "default"}).AffectsTenant("other") {
+		t.Error("expected datasets to only affect their tenant")
+	}
+}
+
+func TestBroker(t *testing.T) {
+	b := NewBroker()
+
+	first, cancelFirst := b.Subscribe(1)
+	second, cancelSecond := b.Subscribe(1)
+	defer cancelSecond()
+
+	b.Broadcast(&Event{Version: 1})
+	if e := <-first; e.Version != 1 {
+		t.Errorf("Got: %d Expected: 1", e.Version)
+	}
+	if e := <-second; e.Version != 1 {
+		t.Errorf("Got: %d Expected: 1", e.Version)
+	}
+
+	// Cancelled subscribers are closed and no longer receive events.
+	cancelFirst()
+	cancelFirst()
+	if _, ok := <-first; ok {
+		t.Error("expected cancelled subscription to be closed")
+	}
+
+	// Full subscribers drop events instead of blocking the broker.
+	b.Broadcast(&Event{Version: 2})
+	b.Broadcast(&Event{Version: 3})
+	if e := <-second; e.Version != 2 {
+		t.Errorf("Got: %d Expected: 2", e.Version)
+	}
+
+	select {
+	case e := <-second:
+		t.Errorf("expected dropped event, got %v", e)
+	default:
+	}
+}
+
+func TestBrokerClose(t *testing.T) {
+	b := NewBroker()
+
+	events, cancel := b.Subscribe(1)
+	b.Close()
+	if _, ok := <-events; ok {
+		t.Error("expected subscription to be closed")
+	}
+
+	// Cancelling closed subscriptions is safe.
+	cancel()
+
+	late, cancelLate := b.Subscribe(1)
+	defer cancelLate()
+	if _, ok := <-late; ok {
+		t.Error("expected subscriptions of a closed broker to be closed")
+	}
+
+	b.Broadcast(&Event{Version: 1})
+}
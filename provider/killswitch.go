@@ -0,0 +1,272 @@
+package provider
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"expertisetest/adnetwork"
+	"expertisetest/country"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+const (
+	// switchesKey holds kill switches by id.
+	switchesKey = "providers:switches"
+	// switchesVersionKey is incremented on every kill switch change.
+	switchesVersionKey = "providers:switches:version"
+)
+
+// ErrSwitchNotFound is returned when kill switch does not exist.
+var ErrSwitchNotFound = errors.New("kill switch not found")
+
+// ErrInvalidCountry is returned when kill switch country is not an ISO 3166-1 country code.
+var ErrInvalidCountry = errors.New("invalid country")
+
+// ErrInvalidExpiry is returned when kill switch expires before it is created.
+var ErrInvalidExpiry = errors.New("invalid expiry")
+
+// Switch disables a provider in every served list matching its platform, country and ad type, empty ones match any.
+// Switches without expiry hold until they are deleted.
+type Switch struct {
+	ID        string     `json:"id"`
+	Provider  string     `json:"provider"`
+	Platform  string     `json:"platform,omitempty"`
+	Country   string     `json:"country,omitempty"`
+	AdType    string     `json:"adType,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	CreatedBy string     `json:"createdBy,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// Active returns true if the switch has not expired at now.
+func (s *Switch) Active(now time.Time) bool {
+	return s.ExpiresAt == nil || now.Before(*s.ExpiresAt)
+}
+
+// returns true if the switch disables the provider in the list.
+func (s *Switch) matches(provider, platform, country, adType string) bool {
+	return s.Provider == provider &&
+		(s.Platform == "" || s.Platform == platform) &&
+		(s.Country == "" || s.Country == country) &&
+		(s.AdType == "" || s.AdType == adType)
+}
+
+// CreateSwitch stores a kill switch for the provider, named by its id or an alias, disabling it immediately.
+// The provider, platform, country and ad type of the switch are normalized in place, expired switches are removed.
+func CreateSwitch(s *Switch) (*Switch, error) {
+	if err := validateSwitch(s); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if s.ExpiresAt != nil && !s.Active(now) {
+		return nil, ErrInvalidExpiry
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	s.ID, s.CreatedAt = id, now
+
+	b, err := ffjson.Marshal(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal kill switch")
+	}
+
+	all, err := ListSwitches()
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := client().TxPipeline()
+	for _, old := range all {
+		if !old.Active(now) {
+			pipe.HDel(switchesKey, old.ID)
+		}
+	}
+	pipe.HSet(switchesKey, s.ID, b)
+	pipe.Incr(switchesVersionKey)
+	_, err = pipe.Exec()
+	return s, errors.Wrapf(err, "failed to store kill switch of provider %q", s.Provider)
+}
+
+// ListSwitches returns all kill switches, expired ones included, sorted by creation time.
+func ListSwitches() ([]*Switch, error) {
+	m, err := client().HGetAll(switchesKey).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch kill switches")
+	}
+
+	out := []*Switch{}
+	for id, b := range m {
+		s := &Switch{}
+		if err = ffjson.Unmarshal([]byte(b), s); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal kill switch %q", id)
+		}
+		out = append(out, s)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out, nil
+}
+
+// DeleteSwitch removes the kill switch, enabling its provider again.
+func DeleteSwitch(id string) (*Switch, error) {
+	b, err := client().HGet(switchesKey, id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrSwitchNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch kill switch %q", id)
+	}
+
+	s := &Switch{}
+	if err = ffjson.Unmarshal(b, s); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal kill switch %q", id)
+	}
+
+	pipe := client().TxPipeline()
+	pipe.HDel(switchesKey, id)
+	pipe.Incr(switchesVersionKey)
+	_, err = pipe.Exec()
+	return s, errors.Wrapf(err, "failed to delete kill switch %q", id)
+}
+
+// validates the switch, normalizing it in place.
+func validateSwitch(s *Switch) error {
+	id, ok := GetInstance().Canonical(s.Provider)
+	if !ok {
+		return ErrUnknownProvider
+	}
+	s.Provider = id
+
+	s.Platform = strings.ToLower(strings.TrimSpace(s.Platform))
+	if s.Country != "" {
+		if s.Country, ok = country.Normalize(s.Country); !ok {
+			return ErrInvalidCountry
+		}
+	}
+	if s.AdType != "" && !contains(adnetwork.AdTypes, s.AdType) {
+		return ErrInvalidAdType
+	}
+
+	return nil
+}
+
+// Switches is a snapshot of all kill switches, applied to served lists.
+type Switches struct {
+	Version  int64
+	switches []*Switch
+}
+
+var (
+	switches   *Switches
+	switchesMu sync.Mutex
+)
+
+// CurrentSwitches returns the kill switches of the current version.
+// Switches are only reloaded when the version changed, since any instance can change them.
+func CurrentSwitches() (*Switches, error) {
+	version, err := client().Get(switchesVersionKey).Int64()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrap(err, "failed to fetch kill switches version")
+	}
+
+	switchesMu.Lock()
+	defer switchesMu.Unlock()
+	if switches != nil && switches.Version == version {
+		return switches, nil
+	}
+
+	all, err := ListSwitches()
+	if err != nil {
+		return nil, err
+	}
+
+	switches = &Switches{Version: version, switches: all}
+	return switches, nil
+}
+
+// Active returns the number of switches active at now.
+// Switches only ever expire, so together with the version it identifies the applied switches.
+func (s *Switches) Active(now time.Time) int {
+	n := 0
+	for _, sw := range s.switches {
+		if sw.Active(now) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// NextExpiry returns the earliest expiry of the switches active at now, false if none of them expires.
+func (s *Switches) NextExpiry(now time.Time) (time.Time, bool) {
+	next, ok := time.Time{}, false
+	for _, sw := range s.switches {
+		if sw.ExpiresAt != nil && sw.Active(now) && (!ok || sw.ExpiresAt.Before(next)) {
+			next, ok = *sw.ExpiresAt, true
+		}
+	}
+
+	return next, ok
+}
+
+// Apply replaces every list of the network with its providers not disabled at now for the platform and country.
+// Lists are replaced rather than filtered in place, so stored networks shared between lists are left untouched.
+func (s *Switches) Apply(an *adnetwork.AdNetwork, platform, country string, now time.Time) {
+	active := []*Switch{}
+	for _, sw := range s.switches {
+		if sw.Active(now) {
+			active = append(active, sw)
+		}
+	}
+	if len(active) == 0 {
+		return
+	}
+
+	platform = strings.ToLower(platform)
+	apply := func(adType string, arr []*adnetwork.SDK) []*adnetwork.SDK {
+		out := []*adnetwork.SDK{}
+		for _, sdk := range arr {
+			if !disabled(active, sdk.Provider, platform, country, adType) {
+				out = append(out, sdk)
+			}
+		}
+		return out
+	}
+
+	an.Banner = apply(adnetwork.Banner, an.Banner)
+	an.Interstitial = apply(adnetwork.Interstitial, an.Interstitial)
+	an.Video = apply(adnetwork.Video, an.Video)
+}
+
+// returns true if any of the switches disables the provider in the list.
+func disabled(active []*Switch, provider, platform, country, adType string) bool {
+	for _, s := range active {
+		if s.matches(provider, platform, country, adType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate random string")
+	}
+
+	return hex.EncodeToString(b), nil
+}
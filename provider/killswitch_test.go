@@ -0,0 +1,94 @@
+package provider
+
+import (
+	"expertisetest/adnetwork"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApply(t *testing.T) {
+	now := time.Now()
+	expired := now.Add(-time.Minute)
+	s := &Switches{switches: []*Switch{
+		{Provider: "AdMob"},
+		{Provider: "Vungle", Platform: "android", Country: "SI"},
+		{Provider: "Moloco", AdType: adnetwork.Video},
+		{Provider: "Facebook", ExpiresAt: &expired},
+	}}
+
+	if got := s.Active(now); got != 3 {
+		t.Errorf("Got: %d active Expected: 3", got)
+	}
+
+	stored := &adnetwork.AdNetwork{
+		Banner: []*adnetwork.SDK{{Provider: "AdMob"}, {Provider: "Vungle"}, {Provider: "Moloco"}, {Provider: "Facebook"}},
+		Video:  []*adnetwork.SDK{{Provider: "Vungle"}, {Provider: "Moloco"}, {Provider: "Facebook"}},
+	}
+
+	tests := []struct {
+		platform, country string
+		banner, video     []string
+	}{
+		{"Android", "SI", []string{"Moloco", "Facebook"}, []string{"Facebook"}},
+		{"android", "GB", []string{"Vungle", "Moloco", "Facebook"}, []string{"Vungle", "Facebook"}},
+		{"ios", "SI", []string{"Vungle", "Moloco", "Facebook"}, []string{"Vungle", "Facebook"}},
+	}
+
+	for _, tt := range tests {
+		an := stored.Copy()
+		s.Apply(an, tt.platform, tt.country, now)
+		if got := providers(an.Banner); !reflect.DeepEqual(got, tt.banner) {
+			t.Errorf("%s %s banner: Got: %v Expected: %v", tt.platform, tt.country, got, tt.banner)
+		}
+		if got := providers(an.Video); !reflect.DeepEqual(got, tt.video) {
+			t.Errorf("%s %s video: Got: %v Expected: %v", tt.platform, tt.country, got, tt.video)
+		}
+	}
+
+	if len(stored.Banner) != 4 || len(stored.Video) != 3 {
+		t.Error("expected stored network to be left untouched")
+	}
+
+	// Switches expire without a version change.
+	an := stored.Copy()
+	s.Apply(an, "ios", "SI", expired.Add(-time.Minute))
+	if got := providers(an.Banner); !reflect.DeepEqual(got, []string{"Vungle", "Moloco"}) {
+		t.Errorf("Got: %v Expected: [Vungle Moloco]", got)
+	}
+}
+
+func TestNextExpiry(t *testing.T) {
+	now := time.Now()
+	expired, soon, later := now.Add(-time.Minute), now.Add(time.Minute), now.Add(time.Hour)
+	s := &Switches{switches: []*Switch{
+		{Provider: "AdMob"},
+		{Provider: "Vungle", ExpiresAt: &later},
+		{Provider: "Moloco", ExpiresAt: &soon},
+		{Provider: "Facebook", ExpiresAt: &expired},
+	}}
+
+	tests := []struct {
+		now      time.Time
+		expected time.Time
+		ok       bool
+	}{
+		{now, soon, true},
+		{soon, later, true},
+		{later, time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		if got, ok := s.NextExpiry(tt.now); !got.Equal(tt.expected) || ok != tt.ok {
+			t.Errorf("%s: Got: %s %v Expected: %s %v", tt.now, got, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+func providers(arr []*adnetwork.SDK) []string {
+	out := []string{}
+	for _, sdk := range arr {
+		out = append(out, sdk.Provider)
+	}
+	return out
+}
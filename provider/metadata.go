@@ -0,0 +1,280 @@
+package provider
+
+import (
+	"expertisetest/adnetwork"
+	"expertisetest/config"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+const (
+	// metadataKey holds provider metadata by provider id.
+	metadataKey = "providers:metadata"
+	// metadataVersionKey is incremented on every metadata change.
+	metadataVersionKey = "providers:metadata:version"
+)
+
+// ErrNotFound is returned when provider has no metadata.
+var ErrNotFound = errors.New("provider metadata not found")
+
+// ErrUnknownProvider is returned when provider is not in the registry.
+var ErrUnknownProvider = errors.New("unknown provider")
+
+// ErrInvalidAdType is returned when placement ad type is not one of adnetwork.AdTypes.
+var ErrInvalidAdType = errors.New("invalid ad type")
+
+// ErrInvalidTimeout is returned when placement timeout is negative.
+var ErrInvalidTimeout = errors.New("invalid timeout")
+
+// ErrInvalidPlacement is returned when placement is null.
+var ErrInvalidPlacement = errors.New("invalid placement")
+
+// ErrDuplicatePlacement is returned when placements share platform and ad type.
+var ErrDuplicatePlacement = errors.New("duplicate placement")
+
+// Metadata holds the placements of a provider.
+type Metadata struct {
+	ID         string       `json:"id"`
+	Placements []*Placement `json:"placements"`
+	UpdatedAt  time.Time    `json:"updatedAt"`
+}
+
+// Placement holds the settings of a provider for a platform and ad type, empty ones match any.
+// Disabled placements drop the provider from lists they match.
+type Placement struct {
+	Platform string             `json:"platform,omitempty"`
+	AdType   string             `json:"adType,omitempty"`
+	Enabled  bool               `json:"enabled"`
+	Settings adnetwork.Settings `json:"settings"`
+}
+
+// returns how well the placement matches, -1 if it does not.
+func (p *Placement) match(platform, adType string) int {
+	rank := 0
+	switch p.Platform {
+	case "":
+	case platform:
+		rank += 2
+	default:
+		return -1
+	}
+
+	switch p.AdType {
+	case "":
+	case adType:
+		rank++
+	default:
+		return -1
+	}
+
+	return rank
+}
+
+// List returns metadata of all providers sorted by id.
+func List() ([]*Metadata, error) {
+	m, err := client().HGetAll(metadataKey).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch provider metadata")
+	}
+
+	out := []*Metadata{}
+	for id, b := range m {
+		md := &Metadata{}
+		if err = ffjson.Unmarshal([]byte(b), md); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal metadata of provider %q", id)
+		}
+		out = append(out, md)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
+
+// Get returns metadata of the provider, named by its id or an alias.
+func Get(name string) (*Metadata, error) {
+	id, ok := GetInstance().Canonical(name)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	b, err := client().HGet(metadataKey, id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch metadata of provider %q", id)
+	}
+
+	md := &Metadata{}
+	return md, errors.Wrapf(ffjson.Unmarshal(b, md), "failed to unmarshal metadata of provider %q", id)
+}
+
+// Put replaces the placements of the provider, named by its id or an alias.
+func Put(name string, placements []*Placement) (*Metadata, error) {
+	id, ok := GetInstance().Canonical(name)
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	if placements == nil {
+		placements = []*Placement{}
+	}
+	if err := validatePlacements(placements); err != nil {
+		return nil, err
+	}
+
+	md := &Metadata{ID: id, Placements: placements, UpdatedAt: time.Now().UTC()}
+	b, err := ffjson.Marshal(md)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal provider metadata")
+	}
+
+	pipe := client().TxPipeline()
+	pipe.HSet(metadataKey, id, b)
+	pipe.Incr(metadataVersionKey)
+	_, err = pipe.Exec()
+	return md, errors.Wrapf(err, "failed to store metadata of provider %q", id)
+}
+
+// Delete removes metadata of the provider, dropping it from lists.
+func Delete(name string) (*Metadata, error) {
+	md, err := Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := client().TxPipeline()
+	pipe.HDel(metadataKey, md.ID)
+	pipe.Incr(metadataVersionKey)
+	_, err = pipe.Exec()
+	return md, errors.Wrapf(err, "failed to delete metadata of provider %q", md.ID)
+}
+
+// validates placements, platforms are lowercased in place.
+func validatePlacements(placements []*Placement) error {
+	seen := map[[2]string]bool{}
+	for _, p := range placements {
+		if p == nil {
+			return ErrInvalidPlacement
+		}
+
+		p.Platform = strings.ToLower(strings.TrimSpace(p.Platform))
+		if p.AdType != "" && !contains(adnetwork.AdTypes, p.AdType) {
+			return ErrInvalidAdType
+		}
+		if p.Settings.TimeoutMs < 0 {
+			return ErrInvalidTimeout
+		}
+
+		key := [2]string{p.Platform, p.AdType}
+		if seen[key] {
+			return ErrDuplicatePlacement
+		}
+		seen[key] = true
+	}
+
+	return nil
+}
+
+// Catalog is a snapshot of all provider metadata, joined into served lists.
+type Catalog struct {
+	Version   int64
+	providers map[string]*Metadata
+}
+
+var (
+	current   *Catalog
+	currentMu sync.Mutex
+)
+
+// Current returns the catalog of the current metadata version.
+// The catalog is only reloaded when the version changed, since any instance can change metadata.
+func Current() (*Catalog, error) {
+	version, err := client().Get(metadataVersionKey).Int64()
+	if err != nil && err != redis.Nil {
+		return nil, errors.Wrap(err, "failed to fetch provider metadata version")
+	}
+
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	if current != nil && current.Version == version {
+		return current, nil
+	}
+
+	all, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Catalog{Version: version, providers: map[string]*Metadata{}}
+	for _, md := range all {
+		c.providers[md.ID] = md
+	}
+
+	current = c
+	return c, nil
+}
+
+// Placement returns the placement of the provider best matching platform and ad type,
+// nil if the provider is unknown or has no matching placement.
+func (c *Catalog) Placement(provider, platform, adType string) *Placement {
+	md := c.providers[provider]
+	if md == nil {
+		return nil
+	}
+
+	var best *Placement
+	bestRank := -1
+	for _, p := range md.Placements {
+		if rank := p.match(platform, adType); rank > bestRank {
+			best, bestRank = p, rank
+		}
+	}
+
+	return best
+}
+
+// Join replaces every list of the network with its providers enabled for the platform, carrying their settings.
+// Providers are copied, so stored networks shared between lists are left untouched.
+func (c *Catalog) Join(an *adnetwork.AdNetwork, platform string) {
+	platform = strings.ToLower(platform)
+	join := func(adType string, arr []*adnetwork.SDK) []*adnetwork.SDK {
+		out := []*adnetwork.SDK{}
+		for _, sdk := range arr {
+			p := c.Placement(sdk.Provider, platform, adType)
+			if p == nil || !p.Enabled {
+				continue
+			}
+
+			settings := p.Settings
+			out = append(out, &adnetwork.SDK{Provider: sdk.Provider, Score: sdk.Score, Settings: &settings})
+		}
+		return out
+	}
+
+	an.Banner = join(adnetwork.Banner, an.Banner)
+	an.Interstitial = join(adnetwork.Interstitial, an.Interstitial)
+	an.Video = join(adnetwork.Video, an.Video)
+}
+
+func client() *redis.Client {
+	return config.GetInstance().MetaRedisClient
+}
+
+func contains(arr []string, target string) bool {
+	for _, item := range arr {
+		if item == target {
+			return true
+		}
+	}
+
+	return false
+}
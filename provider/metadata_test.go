@@ -0,0 +1,111 @@
+package provider
+
+import (
+	"expertisetest/adnetwork"
+	"testing"
+)
+
+func TestPlacement(t *testing.T) {
+	c := &Catalog{providers: map[string]*Metadata{
+		"AdMob": {ID: "AdMob", Placements: []*Placement{
+			{Enabled: true, Settings: adnetwork.Settings{PlacementID: "any"}},
+			{Platform: "android", Enabled: true, Settings: adnetwork.Settings{PlacementID: "android"}},
+			{AdType: adnetwork.Video, Enabled: true, Settings: adnetwork.Settings{PlacementID: "video"}},
+			{Platform: "android", AdType: adnetwork.Video, Enabled: true, Settings: adnetwork.Settings{PlacementID: "android video"}},
+		}},
+		"Vungle": {ID: "Vungle", Placements: []*Placement{
+			{Platform: "ios", AdType: adnetwork.Video, Enabled: true},
+		}},
+	}}
+
+	tests := []struct {
+		provider, platform, adType string
+		expected                   string
+	}{
+		{"AdMob", "ios", adnetwork.Banner, "any"},
+		{"AdMob", "android", adnetwork.Banner, "android"},
+		{"AdMob", "ios", adnetwork.Video, "video"},
+		{"AdMob", "android", adnetwork.Video, "android video"},
+		{"Vungle", "ios", adnetwork.Video, ""},
+		{"Vungle", "android", adnetwork.Video, "<nil>"},
+		{"Facebook", "ios", adnetwork.Video, "<nil>"},
+	}
+
+	for _, tt := range tests {
+		got := "<nil>"
+		if p := c.Placement(tt.provider, tt.platform, tt.adType); p != nil {
+			got = p.Settings.PlacementID
+		}
+		if got != tt.expected {
+			t.Errorf("%s %s %s: Got: %q Expected: %q", tt.provider, tt.platform, tt.adType, got, tt.expected)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	c := &Catalog{providers: map[string]*Metadata{
+		"AdMob": {ID: "AdMob", Placements: []*Placement{
+			{Enabled: true, Settings: adnetwork.Settings{AdUnitID: "unit", TimeoutMs: 3000}},
+			{AdType: adnetwork.Interstitial, Enabled: false},
+		}},
+		"Vungle": {ID: "Vungle", Placements: []*Placement{
+			{Platform: "android", Enabled: true, Settings: adnetwork.Settings{AdapterVersion: "6.5.3"}},
+		}},
+	}}
+
+	admob := &adnetwork.SDK{Provider: "AdMob", Score: 5}
+	stored := &adnetwork.AdNetwork{
+		Banner:       []*adnetwork.SDK{admob, {Provider: "Vungle", Score: 4}, {Provider: "Moloco", Score: 3}},
+		Interstitial: []*adnetwork.SDK{{Provider: "AdMob", Score: 5}, {Provider: "Vungle", Score: 4}},
+	}
+
+	an := stored.Copy()
+	c.Join(an, "Android")
+
+	if len(an.Banner) != 2 || an.Banner[0].Settings.AdUnitID != "unit" || an.Banner[1].Settings.AdapterVersion != "6.5.3" {
+		t.Errorf("unexpected banner: %+v", an.Banner)
+	}
+	if len(an.Interstitial) != 1 || an.Interstitial[0].Provider != "Vungle" {
+		t.Errorf("unexpected interstitial: %+v", an.Interstitial)
+	}
+	if len(an.Video) != 0 {
+		t.Errorf("unexpected video: %+v", an.Video)
+	}
+
+	// Stored providers are shared between lists of different platforms.
+	if admob.Settings != nil {
+		t.Error("expected stored provider to be left untouched")
+	}
+
+	an = stored.Copy()
+	c.Join(an, "ios")
+	if len(an.Banner) != 1 || an.Banner[0].Provider != "AdMob" {
+		t.Errorf("unexpected banner: %+v", an.Banner)
+	}
+}
+
+func TestValidatePlacements(t *testing.T) {
+	valid := []*Placement{{Platform: " Android "}, {Platform: "android", AdType: adnetwork.Video}, {}}
+	if err := validatePlacements(valid); err != nil {
+		t.Fatal(err)
+	}
+	if valid[0].Platform != "android" {
+		t.Errorf("Got: %q Expected: android", valid[0].Platform)
+	}
+
+	tests := []struct {
+		placements []*Placement
+		expected   error
+	}{
+		{[]*Placement{nil}, ErrInvalidPlacement},
+		{[]*Placement{{AdType: "native"}}, ErrInvalidAdType},
+		{[]*Placement{{Settings: adnetwork.Settings{TimeoutMs: -1}}}, ErrInvalidTimeout},
+		{[]*Placement{{Platform: "ios"}, {Platform: "IOS"}}, ErrDuplicatePlacement},
+	}
+
+	for i, tt := range tests {
+		if err := validatePlacements(tt.placements); err != tt.expected {
+			t.Errorf("%d: Got: %v Expected: %v", i, err, tt.expected)
+		}
+	}
+}
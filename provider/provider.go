@@ -0,0 +1,115 @@
+// Package provider maps ad provider names, as spelled by datasets and rules, to canonical provider IDs,
+// and keeps per provider metadata joined into served lists in meta storage.
+package provider
+
+import (
+	"expertisetest/config"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"github.com/pquerna/ffjson/ffjson"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	instance *Registry
+	once     sync.Once
+)
+
+// GetInstance always returns the same Registry loaded from the providers file in Config.
+func GetInstance() *Registry {
+	once.Do(func() {
+		var err error
+		if instance, err = Load(config.GetInstance().Providers); err != nil {
+			logrus.Fatal(err)
+		}
+	})
+	return instance
+}
+
+// Definition is a provider of the registry file.
+type Definition struct {
+	// ID is the canonical name, the one stored and returned by /list.
+	ID      string   `json:"id"`
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// Registry resolves provider names to canonical IDs.
+type Registry struct {
+	ids  []string
+	keys map[string]string
+}
+
+// Load returns the Registry of the providers file.
+func Load(filename string) (*Registry, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read providers")
+	}
+
+	file := struct {
+		Providers []Definition `json:"providers"`
+	}{}
+	if err = ffjson.Unmarshal(b, &file); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal providers")
+	}
+
+	return New(file.Providers)
+}
+
+// New returns the Registry of the definitions. Names are matched regardless of case, spaces and punctuation,
+// so an ID or alias must not match another provider.
+func New(definitions []Definition) (*Registry, error) {
+	r := &Registry{keys: map[string]string{}}
+
+	for _, d := range definitions {
+		if key(d.ID) == "" {
+			return nil, fmt.Errorf("invalid provider id: %q", d.ID)
+		}
+
+		for _, name := range append([]string{d.ID}, d.Aliases...) {
+			k := key(name)
+			if k == "" {
+				return nil, fmt.Errorf("invalid alias of provider %q: %q", d.ID, name)
+			}
+			if id, exists := r.keys[k]; exists {
+				return nil, fmt.Errorf("provider %q: %q already names provider %q", d.ID, name, id)
+			}
+			r.keys[k] = d.ID
+		}
+		r.ids = append(r.ids, d.ID)
+	}
+
+	sort.Strings(r.ids)
+	return r, nil
+}
+
+// Canonical returns the canonical ID of the provider name.
+// Unknown names are returned trimmed, with false.
+func (r *Registry) Canonical(name string) (string, bool) {
+	if id, ok := r.keys[key(name)]; ok {
+		return id, true
+	}
+
+	return strings.TrimSpace(name), false
+}
+
+// IDs returns the sorted canonical IDs of all providers.
+func (r *Registry) IDs() []string {
+	return append([]string{}, r.ids...)
+}
+
+// returns the lookup key of a name, "Huawei Ads", "huawei-ads" and "HuaweiAds" share one.
+func key(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
@@ -0,0 +1,66 @@
+package provider
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCanonical(t *testing.T) {
+	r, err := Load("../handler/providers.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		expected string
+		ok       bool
+	}{
+		{"HuaweiAds", "HuaweiAds", true},
+		{"Huawei Ads", "HuaweiAds", true},
+		{"huawei-ads", "HuaweiAds", true},
+		{"Unity Ads", "UnityAds", true},
+		{"admob", "AdMob", true},
+		{"AdMob OptOut", "AdMob-OptOut", true},
+		{"Meta Audience Network", "Facebook", true},
+		{"Start.io", "Startapp", true},
+		{" Moloco ", "Moloco", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := r.Canonical(tt.name)
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf("%q: Got: %q, %v Expected: %q, %v", tt.name, got, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	r, err := New([]Definition{{ID: "UnityAds", Aliases: []string{"Unity"}}, {ID: "AdMob"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := r.IDs(); !reflect.DeepEqual(got, []string{"AdMob", "UnityAds"}) {
+		t.Errorf("Got: %v Expected: [AdMob UnityAds]", got)
+	}
+
+	for _, invalid := range [][]Definition{
+		{{ID: ""}},
+		{{ID: "--"}},
+		{{ID: "AdMob", Aliases: []string{" "}}},
+		{{ID: "AdMob"}, {ID: "Ad Mob"}},
+		{{ID: "AdMob"}, {ID: "Google", Aliases: []string{"admob"}}},
+	} {
+		if _, err := New(invalid); err == nil {
+			t.Errorf("%+v: expected error", invalid)
+		}
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	if _, err := Load("missing.json"); err == nil {
+		t.Error("expected error loading missing registry")
+	}
+}
@@ -0,0 +1,225 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// Limit of a token bucket, Rate tokens are added each second up to Burst tokens.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result of taking a token from the bucket.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is the time until next token is available, zero when allowed.
+	RetryAfter time.Duration
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+}
+
+// Limiter takes tokens from buckets identified by key.
+type Limiter interface {
+	Allow(key string, limit Limit) (*Result, error)
+}
+
+// ParseLimit parses a limit in "rate:burst" format, e.g. "10:20".
+func ParseLimit(s string) (Limit, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 {
+		return Limit{}, fmt.Errorf("invalid limit %q, expected rate:burst", s)
+	}
+
+	rate, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || rate <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate in limit %q", s)
+	}
+
+	burst, err := strconv.Atoi(parts[1])
+	if err != nil || burst < 1 {
+		return Limit{}, fmt.Errorf("invalid burst in limit %q", s)
+	}
+
+	return Limit{Rate: rate, Burst: burst}, nil
+}
+
+// ParseLimits parses comma separated limits in "name=rate:burst" format, e.g. "admin=50:100,client=10:20".
+func ParseLimits(s string) (map[string]Limit, error) {
+	out := map[string]Limit{}
+	if strings.TrimSpace(s) == "" {
+		return out, nil
+	}
+
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid limit %q, expected name=rate:burst", item)
+		}
+
+		limit, err := ParseLimit(parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		out[strings.TrimSpace(parts[0])] = limit
+	}
+
+	return out, nil
+}
+
+// take refills the bucket for the elapsed time and takes a token if available.
+func take(tokens float64, elapsed time.Duration, limit Limit) (float64, *Result) {
+	tokens = math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*limit.Rate)
+
+	res := &Result{Limit: limit.Burst}
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - tokens) / limit.Rate)
+	}
+
+	res.Remaining = int(math.Floor(tokens))
+	res.Reset = seconds((float64(limit.Burst) - tokens) / limit.Rate)
+
+	return tokens, res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryLimiter keeps buckets in process, limits are per instance.
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryLimiter returns a new MemoryLimiter.
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets:   map[string]*bucket{},
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Allow satisfies Limiter interface.
+func (ml *MemoryLimiter) Allow(key string, limit Limit) (*Result, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	now := ml.now()
+	ml.sweep(now)
+
+	b, ok := ml.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		ml.buckets[key] = b
+	}
+
+	var res *Result
+	b.tokens, res = take(b.tokens, now.Sub(b.last), limit)
+	b.last = now
+
+	return res, nil
+}
+
+// sweep removes buckets untouched for a while, they would be full by now anyway.
+// Runs at most once a minute.
+func (ml *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(ml.lastSweep) < time.Minute {
+		return
+	}
+	ml.lastSweep = now
+
+	for key, b := range ml.buckets {
+		if now.Sub(b.last) > 10*time.Minute {
+			delete(ml.buckets, key)
+		}
+	}
+}
+
+// Token bucket is refilled and taken from atomically, tokens are returned
+// as string to keep their fraction.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// RedisLimiter keeps buckets in redis, limits are shared between all instances.
+type RedisLimiter struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisLimiter returns a new RedisLimiter, bucket keys are prefixed with prefix.
+func NewRedisLimiter(client *redis.Client, prefix string) *RedisLimiter {
+	return &RedisLimiter{client: client, prefix: prefix}
+}
+
+// Allow satisfies Limiter interface.
+func (rl *RedisLimiter) Allow(key string, limit Limit) (*Result, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	out, err := takeScript.Run(rl.client, []string{rl.prefix + key}, limit.Rate, limit.Burst, now).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to run rate limit script")
+	}
+
+	arr, ok := out.([]interface{})
+	if !ok || len(arr) != 2 {
+		return nil, errors.Errorf("unexpected rate limit script result %v", out)
+	}
+
+	str, _ := arr[1].(string)
+	tokens, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse remaining tokens")
+	}
+
+	// Tokens are already refilled and taken, only compute the result.
+	res := &Result{
+		Allowed:   arr[0] == int64(1),
+		Limit:     limit.Burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((float64(limit.Burst) - tokens) / limit.Rate),
+	}
+
+	if !res.Allowed {
+		res.RetryAfter = seconds((1 - tokens) / limit.Rate)
+	}
+
+	return res, nil
+}
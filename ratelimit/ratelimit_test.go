@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseLimits(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected map[string]Limit
+		err      bool
+	}{
+		{"", map[string]Limit{}, false},
+		{"admin=50:100,client=0.5:2", map[string]Limit{"admin": {50, 100}, "client": {0.5, 2}}, false},
+		{"admin=50", nil, true},
+		{"admin=0:10", nil, true},
+		{"=1:1", nil, true},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got, err := ParseLimits(test.in)
+			if (err != nil) != test.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(got) != len(test.expected) {
+				t.Fatalf("Got: %v Expected: %v", got, test.expected)
+			}
+
+			for name, limit := range test.expected {
+				if got[name] != limit {
+					t.Errorf("Got: %v Expected: %v", got[name], limit)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	now := time.Now()
+	ml := NewMemoryLimiter()
+	ml.now = func() time.Time { return now }
+	limit := Limit{Rate: 2, Burst: 3}
+
+	// Burst is available immediately.
+	for i := 0; i < 3; i++ {
+		res, _ := ml.Allow("client", limit)
+		if !res.Allowed || res.Remaining != 2-i {
+			t.Fatalf("request %d: unexpected result %+v", i, res)
+		}
+	}
+
+	res, _ := ml.Allow("client", limit)
+	if res.Allowed || res.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("unexpected result %+v", res)
+	}
+
+	// Other keys have their own bucket.
+	if res, _ = ml.Allow("other", limit); !res.Allowed {
+		t.Fatalf("unexpected result %+v", res)
+	}
+
+	// Bucket refills with rate.
+	now = now.Add(500 * time.Millisecond)
+	if res, _ = ml.Allow("client", limit); !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+
+	now = now.Add(time.Hour)
+	if res, _ = ml.Allow("client", limit); !res.Allowed || res.Remaining != 2 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+
+	if _, ok := ml.buckets["other"]; ok {
+		t.Error("idle bucket was not swept")
+	}
+}
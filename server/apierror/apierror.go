@@ -0,0 +1,216 @@
+package apierror
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/pquerna/ffjson/ffjson"
+	"github.com/sirupsen/logrus"
+)
+
+// Code is a stable machine readable error code, clients should branch on it instead of the message.
+type Code string
+
+// Error codes returned by the api.
+const (
+	CodeMissingArgument      Code = "missing_argument"
+	CodeInvalidArgument      Code = "invalid_argument"
+	CodeInvalidBody          Code = "invalid_body"
+	CodeValidationFailed     Code = "validation_failed"
+	CodeUnauthenticated      Code = "unauthenticated"
+	CodeForbidden            Code = "forbidden"
+	CodeNotFound             Code = "not_found"
+	CodeMethodNotAllowed     Code = "method_not_allowed"
+	CodeNotAcceptable        Code = "not_acceptable"
+	CodeConflict             Code = "conflict"
+	CodeUnsupportedMediaType Code = "unsupported_media_type"
+	CodeRateLimited          Code = "rate_limited"
+	CodeInternal             Code = "internal_error"
+	CodeUnavailable          Code = "service_unavailable"
+)
+
+// FieldError describes why a single field failed validation.
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// Error is the error object returned to clients.
+// Message is meant for humans and never contains internal error text.
+type Error struct {
+	Status  int          `json:"-"`
+	Code    Code         `json:"code"`
+	Message string       `json:"message"`
+	Details []FieldError `json:"details,omitempty"`
+	// headers sent with the error, e.g. Allow or WWW-Authenticate.
+	headers map[string]string
+}
+
+// Error satisfies error interface.
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// WithHeader returns the error with an additional response header.
+func (e *Error) WithHeader(key, value string) *Error {
+	if e.headers == nil {
+		e.headers = map[string]string{}
+	}
+	e.headers[key] = value
+	return e
+}
+
+// Envelope wraps the error in responses.
+type Envelope struct {
+	Err *Error `json:"error"`
+}
+
+// New returns a new Error.
+func New(status int, code Code, message string, details ...FieldError) *Error {
+	return &Error{
+		Status:  status,
+		Code:    code,
+		Message: message,
+		Details: details,
+	}
+}
+
+// InvalidArguments is returned when url arguments are missing or invalid.
+// Code is missing_argument only when all of the arguments are missing.
+func InvalidArguments(details ...FieldError) *Error {
+	for _, d := range details {
+		if d.Reason != "required" {
+			return New(http.StatusBadRequest, CodeInvalidArgument, "invalid arguments", details...)
+		}
+	}
+
+	return New(http.StatusBadRequest, CodeMissingArgument, "missing required arguments", details...)
+}
+
+// InvalidArgument is returned when url argument has an invalid value.
+func InvalidArgument(name, reason string) *Error {
+	return New(http.StatusBadRequest, CodeInvalidArgument, fmt.Sprintf("invalid argument %q", name), FieldError{Field: name, Reason: reason})
+}
+
+// InvalidBody is returned when request body is empty or can not be parsed.
+func InvalidBody() *Error {
+	return New(http.StatusBadRequest, CodeInvalidBody, "invalid or empty request body")
+}
+
+// ValidationFailed is returned when request body was parsed, but its content is invalid.
+func ValidationFailed(details ...FieldError) *Error {
+	return New(http.StatusUnprocessableEntity, CodeValidationFailed, "validation failed", details...)
+}
+
+// Unauthenticated is returned when request carries no valid credentials.
+func Unauthenticated(challenges ...string) *Error {
+	e := New(http.StatusUnauthorized, CodeUnauthenticated, "invalid or missing credentials")
+	if len(challenges) > 0 {
+		e.WithHeader("WWW-Authenticate", strings.Join(challenges, ", "))
+	}
+	return e
+}
+
+// Forbidden is returned when authenticated client is not allowed to perform the request.
+func Forbidden(message string) *Error {
+	return New(http.StatusForbidden, CodeForbidden, message)
+}
+
+// NotFound is returned when requested resource does not exist.
+func NotFound(message string) *Error {
+	return New(http.StatusNotFound, CodeNotFound, message)
+}
+
+// MethodNotAllowed is returned when endpoint does not support the http method.
+func MethodNotAllowed(allowed ...string) *Error {
+	return New(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed").
+		WithHeader("Allow", strings.Join(allowed, ", "))
+}
+
+// NotAcceptable is returned when client accepts none of the available media types.
+func NotAcceptable(available ...string) *Error {
+	return New(http.StatusNotAcceptable, CodeNotAcceptable, fmt.Sprintf("response can only be encoded as %s", strings.Join(available, ", ")))
+}
+
+// Conflict is returned when request conflicts with current state of the resource.
+func Conflict(message string) *Error {
+	return New(http.StatusConflict, CodeConflict, message)
+}
+
+// UnsupportedMediaType is returned when request body is not in the expected format.
+func UnsupportedMediaType(expected string) *Error {
+	return New(http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, fmt.Sprintf("content type must be %q", expected))
+}
+
+// RateLimited is returned when client exceeded its rate limit.
+func RateLimited() *Error {
+	return New(http.StatusTooManyRequests, CodeRateLimited, "rate limit exceeded")
+}
+
+// Internal is returned on unexpected errors, details are only logged.
+func Internal() *Error {
+	return New(http.StatusInternalServerError, CodeInternal, "internal system error")
+}
+
+// Unavailable is returned when storage is unavailable or empty.
+func Unavailable() *Error {
+	return New(http.StatusServiceUnavailable, CodeUnavailable, "service temporarily unavailable")
+}
+
+// Write writes the error to client.
+func Write(w http.ResponseWriter, e *Error) {
+	for key, value := range e.headers {
+		w.Header().Set(key, value)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+
+	body, err := ffjson.Marshal(&Envelope{Err: e})
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	if _, err := w.Write(body); err != nil {
+		logrus.Error(err)
+	}
+}
+
+// NotFoundHandler writes not_found error for unknown routes.
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	Write(w, NotFound("route not found"))
+}
+
+// methods tried when looking up the methods a path allows.
+var methods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// MethodNotAllowedHandler writes method_not_allowed error for routes not supporting the method,
+// with the methods routed for the path as Allow header.
+func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.RawPath
+	if path == "" {
+		path = r.URL.Path
+	}
+
+	allowed := []string{}
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		// Routes of the context is the root router, subrouters are matched by it.
+		for _, method := range methods {
+			if rctx.Routes.Match(chi.NewRouteContext(), method, path) {
+				allowed = append(allowed, method)
+			}
+		}
+	}
+
+	Write(w, MethodNotAllowed(allowed...))
+}
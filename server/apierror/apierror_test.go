@@ -0,0 +1,140 @@
+package apierror
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestInvalidArguments(t *testing.T) {
+	tests := []struct {
+		details  []FieldError
+		expected Code
+	}{
+		{[]FieldError{{Field: "platform", Reason: "required"}}, CodeMissingArgument},
+		{[]FieldError{{Field: "platform", Reason: "required"}, {Field: "device", Reason: "required"}}, CodeMissingArgument},
+		{[]FieldError{{Field: "platform", Reason: "required"}, {Field: "limit", Reason: "must be a positive integer"}}, CodeInvalidArgument},
+		{[]FieldError{{Field: "limit", Reason: "must be given once"}}, CodeInvalidArgument},
+	}
+
+	for _, tt := range tests {
+		e := InvalidArguments(tt.details...)
+		if e.Status != http.StatusBadRequest || e.Code != tt.expected {
+			t.Errorf("%v: Got: %d %s Expected: %d %s", tt.details, e.Status, e.Code, http.StatusBadRequest, tt.expected)
+		}
+		if len(e.Details) != len(tt.details) {
+			t.Errorf("%v: Got details: %v", tt.details, e.Details)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      *Error
+		status   int
+		header   string
+		value    string
+		expected string
+	}{
+		{
+			"validation",
+			ValidationFailed(FieldError{Field: "contexts[0].countryCode", Reason: "required"}),
+			http.StatusUnprocessableEntity, "", "",
+			`{"error":{"code":"validation_failed","message":"validation failed","details":[{"field":"contexts[0].countryCode","reason":"required"}]}}`,
+		},
+		{
+			"unauthenticated",
+			Unauthenticated(`Basic realm="api"`, "Bearer"),
+			http.StatusUnauthorized, "WWW-Authenticate", `Basic realm="api", Bearer`,
+			`{"error":{"code":"unauthenticated","message":"invalid or missing credentials"}}`,
+		},
+		{
+			"method not allowed",
+			MethodNotAllowed(http.MethodGet, http.MethodPost),
+			http.StatusMethodNotAllowed, "Allow", "GET, POST",
+			`{"error":{"code":"method_not_allowed","message":"method not allowed"}}`,
+		},
+		{
+			"internal",
+			Internal(),
+			http.StatusInternalServerError, "", "",
+			`{"error":{"code":"internal_error","message":"internal system error"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		Write(w, tt.err)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: Got status: %d Expected: %d", tt.name, w.Code, tt.status)
+		}
+		if got := w.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("%s: Got content type: %q Expected: application/json", tt.name, got)
+		}
+		if tt.header != "" && w.Header().Get(tt.header) != tt.value {
+			t.Errorf("%s: Got %s: %q Expected: %q", tt.name, tt.header, w.Header().Get(tt.header), tt.value)
+		}
+		if got := w.Body.String(); got != tt.expected {
+			t.Errorf("%s: Got body: %s Expected: %s", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestErrorInterface(t *testing.T) {
+	var err error = NotFound("webhook not found")
+	if err.Error() != "not_found: webhook not found" {
+		t.Errorf("Got: %q", err.Error())
+	}
+
+	var e *Error
+	if !errors.As(err, &e) || e.Status != http.StatusNotFound {
+		t.Errorf("Got: %v Expected an Error with status %d", err, http.StatusNotFound)
+	}
+}
+
+func TestMethodNotAllowedHandler(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+
+	r := chi.NewRouter()
+	r.NotFound(NotFoundHandler)
+	r.MethodNotAllowed(MethodNotAllowedHandler)
+	r.Get("/openapi.json", ok)
+	r.Get("/users/{id}", ok)
+	r.Put("/users/{id}", ok)
+	r.Delete("/users/{id}", ok)
+	r.Route("/v1", func(r chi.Router) {
+		r.Post("/list/batch", ok)
+		r.Get("/users/{id}", ok)
+	})
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		allow  string
+	}{
+		{http.MethodPost, "/openapi.json", http.StatusMethodNotAllowed, "GET"},
+		{http.MethodPost, "/users/1", http.StatusMethodNotAllowed, "GET, PUT, DELETE"},
+		{http.MethodGet, "/v1/list/batch", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodDelete, "/v1/users/1", http.StatusMethodNotAllowed, "GET"},
+		{"PROPFIND", "/users/1", http.StatusMethodNotAllowed, "GET, PUT, DELETE"},
+		{http.MethodGet, "/missing", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+		if w.Code != tt.status {
+			t.Errorf("%s %s: Got status: %d Expected: %d", tt.method, tt.path, w.Code, tt.status)
+		}
+		if got := w.Header().Get("Allow"); got != tt.allow {
+			t.Errorf("%s %s: Got Allow: %q Expected: %q", tt.method, tt.path, got, tt.allow)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package endpoints
+
+import (
+	"context"
+	"expertisetest/audit"
+	"expertisetest/auth"
+	"expertisetest/config"
+	"expertisetest/server/apierror"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// default period during which both rotated and new keys are valid.
+const defaultRotationOverlap = 24 * time.Hour
+
+// APIKeyRequest is the body accepted when creating an API key.
+type APIKeyRequest struct {
+	App       string    `json:"app" validate:"required"`
+	Scopes    []string  `json:"scopes" validate:"required"`
+	Tenants   []string  `json:"tenants"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// RotateKeyRequest is the body accepted when rotating an API key.
+type RotateKeyRequest struct {
+	// Overlap is a duration (e.g. 24h) during which the rotated key stays valid.
+	Overlap string `json:"overlap"`
+}
+
+// APIKeysResponse is returned by API key management endpoints.
+// Key is only returned once, when the key is created.
+type APIKeysResponse struct {
+	Keys []*auth.APIKey `json:"keys,omitempty"`
+	Key  string         `json:"key,omitempty"`
+}
+
+// APIKeys handles /apikeys endpoint functionality, listing and creating keys.
+var APIKeys = func(w http.ResponseWriter, r *http.Request) {
+	// Fetch logger from logger middleware.
+	log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	// Authorize the client.
+	if !authorize(r.Context(), w, auth.PermAccounts) {
+		log.WithField("user", subject(r.Context())).Debug("unauthorized")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		keys, e := ListAPIKeys(r.Context(), log)
+		if e != nil {
+			writeError(w, e)
+			return
+		}
+
+		writeAPIKeys(w, http.StatusOK, "", keys...)
+	case http.MethodPost:
+		in := &APIKeyRequest{}
+		if e := readJSON(r, in); e != nil {
+			writeError(w, e)
+			return
+		}
+
+		k, key, e := CreateAPIKey(r.Context(), log, in)
+		if e != nil {
+			writeError(w, e)
+			return
+		}
+
+		writeAPIKeys(w, http.StatusCreated, key, k)
+	default:
+		log.Error("invalid http method on api keys")
+		writeError(w, apierror.MethodNotAllowed(http.MethodGet, http.MethodPost))
+	}
+}
+
+// APIKeyAction handles /apikeys/{id}/{action} endpoint functionality.
+// Supported actions are revoke and rotate.
+var APIKeyAction = func(w http.ResponseWriter, r *http.Request) {
+	// Fetch logger from logger middleware.
+	log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	// Authorize the client.
+	if !authorize(r.Context(), w, auth.PermAccounts) {
+		log.WithField("user", subject(r.Context())).Debug("unauthorized")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		log.Error("invalid http method on api keys")
+		writeError(w, apierror.MethodNotAllowed(http.MethodPost))
+		return
+	}
+
+	var in *RotateKeyRequest
+	if chi.URLParam(r, "action") == "rotate" {
+		in = &RotateKeyRequest{}
+		_ = readJSON(r, in)
+	}
+
+	k, key, e := UpdateAPIKey(r.Context(), log, chi.URLParam(r, "id"), chi.URLParam(r, "action"), in)
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+
+	writeAPIKeys(w, http.StatusOK, key, k)
+}
+
+// ListAPIKeys returns the keys the identity in ctx can manage, sorted by application and creation time.
+func ListAPIKeys(ctx context.Context, log *logrus.Entry) ([]*auth.APIKey, *apierror.Error) {
+	keys, err := auth.ListAPIKeys()
+	if err != nil {
+		log.Error(errors.Wrap(err, "failed to list api keys"))
+		return nil, apierror.Unavailable()
+	}
+
+	out := []*auth.APIKey{}
+	for _, k := range keys {
+		if manages(ctx, k.Tenants) {
+			out = append(out, k)
+		}
+	}
+
+	return out, nil
+}
+
+// CreateAPIKey validates the request and creates the key, which is returned together with the key record.
+// Identities restricted to tenants can only create keys restricted to their own tenants.
+// The key record is noted in the audit entry of ctx, if any.
+func CreateAPIKey(ctx context.Context, log *logrus.Entry, in *APIKeyRequest) (*auth.APIKey, string, *apierror.Error) {
+	details := []apierror.FieldError{}
+	if in.App == "" {
+		details = append(details, apierror.FieldError{Field: "app", Reason: "required"})
+	}
+
+	scopes, err := auth.ParseScopes(in.Scopes)
+	if err != nil {
+		details = append(details, apierror.FieldError{Field: "scopes", Reason: "unknown or missing scope"})
+	}
+
+	if len(details) > 0 {
+		return nil, "", apierror.ValidationFailed(details...)
+	}
+
+	if !manages(ctx, in.Tenants) {
+		return nil, "", apierror.Forbidden("tenants must be a subset of your own")
+	}
+
+	k, key, err := auth.CreateAPIKey(in.App, scopes, in.Tenants, in.ExpiresAt)
+	if err == auth.ErrInvalidTenant {
+		return nil, "", apierror.ValidationFailed(apierror.FieldError{Field: "tenants", Reason: "unknown tenant"})
+	}
+	if err != nil {
+		log.Error(errors.Wrap(err, "failed to create api key"))
+		return nil, "", apierror.Internal()
+	}
+
+	if entry := audit.FromContext(ctx); entry != nil {
+		entry.Params["app"], entry.Params["key"] = k.App, k.ID
+		entry.Params["scopes"] = strings.Join(in.Scopes, ",")
+		entry.Params["tenants"] = strings.Join(in.Tenants, ",")
+		entry.Tenants = k.Tenants
+	}
+
+	log.WithFields(logrus.Fields{"app": k.App, "key": k.ID}).Info("api key created")
+	return k, key, nil
+}
+
+// UpdateAPIKey runs the action (revoke or rotate) on the key.
+// Rotating returns the new key, the rotated key is noted in the audit entry of ctx, if any.
+// Keys the identity in ctx cannot manage are not found.
+func UpdateAPIKey(ctx context.Context, log *logrus.Entry, id, action string, in *RotateKeyRequest) (*auth.APIKey, string, *apierror.Error) {
+	log = log.WithFields(logrus.Fields{"key": id, "action": action})
+
+	if action != "revoke" && action != "rotate" {
+		return nil, "", apierror.NotFound("unknown action")
+	}
+
+	// Keys of other tenants are reported as missing, not to reveal them.
+	k, err := auth.GetAPIKey(id)
+	if err == nil && !manages(ctx, k.Tenants) {
+		err = auth.ErrKeyNotFound
+	}
+	if err == nil {
+		auditTenants(ctx, k.Tenants)
+	}
+
+	var key string
+	switch {
+	case err != nil:
+	case action == "revoke":
+		k, err = auth.RevokeAPIKey(id)
+	case action == "rotate":
+		overlap := defaultRotationOverlap
+		if in != nil && in.Overlap != "" {
+			if overlap, err = time.ParseDuration(in.Overlap); err != nil || overlap < 0 {
+				return nil, "", apierror.ValidationFailed(apierror.FieldError{Field: "overlap", Reason: "must be a non negative duration"})
+			}
+		}
+
+		k, key, err = auth.RotateAPIKey(id, overlap)
+	}
+
+	switch err {
+	case nil:
+	case auth.ErrKeyNotFound:
+		return nil, "", apierror.NotFound("api key not found")
+	case auth.ErrInvalidKey:
+		return nil, "", apierror.Conflict("api key is revoked or expired")
+	default:
+		log.Error(errors.Wrap(err, "failed to update api key"))
+		return nil, "", apierror.Internal()
+	}
+
+	if entry := audit.FromContext(ctx); entry != nil && action == "rotate" {
+		entry.Params["rotatedTo"] = k.ID
+	}
+
+	log.Info("api key updated")
+	return k, key, nil
+}
+
+// writes keys without their secret hashes.
+func writeAPIKeys(w http.ResponseWriter, status int, key string, keys ...*auth.APIKey) {
+	for _, k := range keys {
+		k.SecretHash = ""
+	}
+
+	writeJSON(w, status, &APIKeysResponse{Keys: keys, Key: key})
+}
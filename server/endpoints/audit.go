@@ -0,0 +1,110 @@
+package endpoints
+
+import (
+	"context"
+	"expertisetest/audit"
+	"expertisetest/auth"
+	"expertisetest/config"
+	"expertisetest/server/apierror"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// AuditResponse is returned by /audit endpoint.
+type AuditResponse struct {
+	Entries []*audit.Entry `json:"entries"`
+}
+
+// Audit handles /audit endpoint functionality, querying the audit log.
+// Entries are returned newest first, as json or as json lines with format=jsonl.
+var Audit = func(w http.ResponseWriter, r *http.Request) {
+	// Fetch logger from logger middleware.
+	log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	// Authorize the client.
+	if !authorize(r.Context(), w, auth.PermRead) {
+		log.WithField("user", subject(r.Context())).Debug("unauthorized")
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		log.Error("invalid http method on audit")
+		writeError(w, apierror.MethodNotAllowed(http.MethodGet))
+		return
+	}
+
+	// Handle arguments
+	vals := r.URL.Query()
+	f := &audit.Filter{
+		Actor:  vals.Get("actor"),
+		Action: vals.Get("action"),
+	}
+
+	var err error
+	for key, dst := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if vals.Get(key) == "" {
+			continue
+		}
+
+		if *dst, err = time.Parse(time.RFC3339, vals.Get(key)); err != nil {
+			writeError(w, apierror.InvalidArgument(key, "must be an RFC3339 timestamp"))
+			return
+		}
+	}
+
+	if vals.Get("limit") != "" {
+		if f.Limit, err = strconv.Atoi(vals.Get("limit")); err != nil || f.Limit < 0 {
+			writeError(w, apierror.InvalidArgument("limit", "must be a non negative integer"))
+			return
+		}
+	}
+
+	entries, e := QueryAudit(r.Context(), log, f)
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+
+	if vals.Get("format") != "jsonl" {
+		writeJSON(w, http.StatusOK, &AuditResponse{Entries: entries})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"audit.jsonl\"")
+	w.WriteHeader(http.StatusOK)
+	if err = audit.WriteJSONL(w, entries); err != nil {
+		log.Error(errors.Wrap(err, "failed to export audit log"))
+	}
+}
+
+// QueryAudit returns entries of the audit log matching f.
+// Identities restricted to tenants only see actions affecting none but their tenants.
+func QueryAudit(ctx context.Context, log *logrus.Entry, f *audit.Filter) ([]*audit.Entry, *apierror.Error) {
+	if id := identity(ctx); id != nil {
+		f.Tenants = id.Tenants
+	}
+
+	entries, err := audit.GetInstance().Query(f)
+	if err != nil {
+		log.Error(errors.Wrap(err, "failed to query audit log"))
+		return nil, apierror.Unavailable()
+	}
+
+	return entries, nil
+}
+
+// notes the tenants affected by the action in the audit entry of ctx, if any.
+func auditTenants(ctx context.Context, tenants []string) {
+	if entry := audit.FromContext(ctx); entry != nil {
+		entry.Tenants = tenants
+	}
+}
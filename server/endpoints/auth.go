@@ -2,52 +2,139 @@ package endpoints
 
 import (
 	"context"
+	"expertisetest/auth"
 	"expertisetest/config"
-	"fmt"
+	"expertisetest/handler"
+	"expertisetest/server/apierror"
 	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
-// This function handle the authorization of the clients
-func authorize(ctx context.Context, w http.ResponseWriter, entity string) bool {
-	// Fetch credentials from authentication middleware.
-	user, ok := ctx.Value(config.UserKey).(string)
-	if !ok {
-		writeResponse(w, http.StatusInternalServerError, fmt.Sprintf("internal system error"), nil)
+// This function handle the authorization of the clients.
+func authorize(ctx context.Context, w http.ResponseWriter, perm auth.Permission) bool {
+	if e := Authorize(ctx, perm); e != nil {
+		writeError(w, e)
 		return false
 	}
 
-	pass, ok := ctx.Value(config.PassKey).(string)
-	if !ok {
-		writeResponse(w, http.StatusInternalServerError, fmt.Sprintf("internal system error"), nil)
+	return true
+}
+
+// This function checks the client is not restricted to tenants, before changes applying to every tenant.
+func authorizeGlobal(ctx context.Context, w http.ResponseWriter) bool {
+	if !manages(ctx, nil) {
+		writeError(w, apierror.Forbidden("changes apply to all tenants"))
 		return false
 	}
 
-	// Validate the client.
+	return true
+}
+
+// This function resolves the tenant from "app" url argument and checks the client can access it.
+// Requests without the argument are served from the default tenant.
+func authorizeTenant(r *http.Request, w http.ResponseWriter) (string, bool) {
+	tenant, e := AuthorizeTenant(r.Context(), r.URL.Query()["app"])
+	if e != nil {
+		writeError(w, e)
+		return "", false
+	}
+
+	return tenant, true
+}
+
+// Authorize checks the identity in ctx has the permission.
+func Authorize(ctx context.Context, perm auth.Permission) *apierror.Error {
+	// Fetch identity from authentication middleware.
+	id := identity(ctx)
+	if id == nil {
+		return unauthenticated()
+	}
+
+	if !id.Can(perm) {
+		return apierror.Forbidden("insufficient permissions")
+	}
+
+	return nil
+}
+
+// AuthorizeTenant resolves the tenant from given app values and checks the identity in ctx can access it.
+// No values resolve to the default tenant. The tenant is noted in the audit entry of ctx, if any.
+func AuthorizeTenant(ctx context.Context, app []string) (string, *apierror.Error) {
 	c := config.GetInstance()
-	switch entity {
-	case "admin":
-		if !(c.AdminUser == user && c.AdminPass == pass) {
-			writeResponse(w, http.StatusUnauthorized, fmt.Sprintf("invalid username or password"), nil)
-			return false
-		}
 
-		return true
-	case "client":
-		if !(c.ClientUser == user && c.ClientPass == pass) {
-			writeResponse(w, http.StatusUnauthorized, fmt.Sprintf("invalid username or password"), nil)
-			return false
+	tenant := c.DefaultTenant
+	if app != nil {
+		if len(app) != 1 || !c.ValidTenant(app[0]) {
+			return "", apierror.InvalidArgument("app", "unknown tenant")
 		}
+		tenant = app[0]
+	}
 
-		return true
-	case "any":
-		if !(c.AdminUser == user && c.AdminPass == pass) && !(c.ClientUser == user && c.ClientPass == pass) {
-			writeResponse(w, http.StatusUnauthorized, fmt.Sprintf("invalid username or password"), nil)
-			return false
-		}
+	id := identity(ctx)
+	if id == nil {
+		return "", unauthenticated()
+	}
 
-		return true
+	if !id.CanAccess(tenant) {
+		return "", apierror.Forbidden("tenant not accessible")
 	}
 
-	writeResponse(w, http.StatusUnauthorized, fmt.Sprintf("invalid username or password"), nil)
-	return false
+	auditTenants(ctx, []string{tenant})
+	return tenant, nil
+}
+
+// manages returns true if the identity in ctx can manage resources restricted to tenants, see auth.Identity.CanManage.
+func manages(ctx context.Context, tenants []string) bool {
+	id := identity(ctx)
+	return id != nil && id.CanManage(tenants)
+}
+
+// TenantHandler authorizes the identity in ctx for the permission and the tenant of given app values,
+// and returns the handler of the tenant.
+func TenantHandler(ctx context.Context, log *logrus.Entry, perm auth.Permission, app []string) (*handler.Handler, *apierror.Error) {
+	if e := Authorize(ctx, perm); e != nil {
+		log.WithField("user", subject(ctx)).Debug("unauthorized")
+		return nil, e
+	}
+
+	tenant, e := AuthorizeTenant(ctx, app)
+	if e != nil {
+		log.WithFields(logrus.Fields{"user": subject(ctx), "app": app}).Debug("unauthorized")
+		return nil, e
+	}
+
+	h, err := handler.ForTenant(tenant)
+	if err != nil {
+		log.Error(errors.Wrapf(err, "failed to init handler for %q", tenant))
+		return nil, apierror.Internal()
+	}
+	h.SetLogger(log)
+
+	return h, nil
+}
+
+// returns identity resolved by authentication middleware or nil.
+func identity(ctx context.Context) *auth.Identity {
+	id, ok := ctx.Value(config.IdentityKey).(*auth.Identity)
+	if !ok {
+		return nil
+	}
+
+	return id
+}
+
+// subject returns the authenticated subject for logging.
+func subject(ctx context.Context) string {
+	if id := identity(ctx); id != nil {
+		return id.Subject
+	}
+
+	return ""
+}
+
+// unauthenticated returns the error with challenges for the enabled authentication methods.
+func unauthenticated() *apierror.Error {
+	return apierror.Unauthenticated(auth.Challenges()...)
 }
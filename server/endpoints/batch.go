@@ -0,0 +1,218 @@
+package endpoints
+
+import (
+	"expertisetest/auth"
+	"expertisetest/config"
+	"expertisetest/handler"
+	"expertisetest/server/apierror"
+	"expertisetest/server/render"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// DeviceContext holds the arguments of a single /list call.
+type DeviceContext struct {
+	CountryCode string `json:"countryCode" validate:"required"`
+	Platform    string `json:"platform" validate:"required"`
+	OsVersion   string `json:"osVersion" validate:"required"`
+	Device      string `json:"device" validate:"required"`
+	// Types optionally restricts the returned ad types, all types by default.
+	Types []string `json:"types,omitempty"`
+	// Limit optionally truncates each list to the providers with the highest score.
+	Limit int `json:"limit,omitempty"`
+}
+
+// Values returns the context as /list url arguments.
+func (dc *DeviceContext) Values() url.Values {
+	vals := url.Values{
+		"countryCode": {dc.CountryCode},
+		"platform":    {dc.Platform},
+		"osVersion":   {dc.OsVersion},
+		"device":      {dc.Device},
+	}
+
+	if dc.Types != nil {
+		vals.Set("types", strings.Join(dc.Types, ","))
+	}
+
+	if dc.Limit != 0 {
+		vals.Set("limit", strconv.Itoa(dc.Limit))
+	}
+
+	return vals
+}
+
+// BatchRequest is the body accepted by /list/batch.
+type BatchRequest struct {
+	Contexts []*DeviceContext `json:"contexts" validate:"required"`
+}
+
+// BatchResponse holds one result per requested context, in the same order.
+type BatchResponse struct {
+	Results []*Response `json:"results"`
+}
+
+// ListBatch handles /list/batch endpoint functionality, resolving many device contexts at once.
+var ListBatch = func(w http.ResponseWriter, r *http.Request) {
+	// Fetch logger from logger middleware.
+	log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	// Authorize the client.
+	if !authorize(r.Context(), w, auth.PermList) {
+		log.WithField("user", subject(r.Context())).Debug("unauthorized")
+		return
+	}
+
+	tenant, ok := authorizeTenant(r, w)
+	if !ok {
+		log.WithFields(logrus.Fields{"user": subject(r.Context()), "app": r.URL.Query().Get("app")}).Debug("unauthorized")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		log.Error("invalid http method on batch list")
+		writeError(w, apierror.MethodNotAllowed(http.MethodPost))
+		return
+	}
+
+	codec, ok := negotiate(w, r, &BatchResponse{})
+	if !ok {
+		log.WithField("accept", r.Header.Get("Accept")).Debug("not acceptable")
+		return
+	}
+
+	in := &BatchRequest{}
+	if e := readJSON(r, in); e != nil {
+		writeError(w, e)
+		return
+	}
+
+	if e := ValidateBatch(in); e != nil {
+		log.WithField("details", e.Details).Error("invalid batch")
+		writeError(w, e)
+		return
+	}
+
+	h, err := handler.ForTenant(tenant)
+	if err != nil {
+		log.Error(errors.Wrapf(err, "failed to init handler for %q", tenant))
+		writeError(w, apierror.Internal())
+		return
+	}
+	h.SetLogger(log)
+
+	out, e := ResolveBatch(log, h, in)
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+
+	render.Write(w, codec, http.StatusOK, out)
+}
+
+// ResolveBatch resolves all contexts of the batch, validated by ValidateBatch.
+// Each country is fetched from storage once, no matter how many contexts share it.
+func ResolveBatch(log *logrus.Entry, h *handler.Handler, in *BatchRequest) (*BatchResponse, *apierror.Error) {
+	// Check if redis is not empty.
+	if data, err := h.Size(); data == 0 || err != nil {
+		log.Error(errors.Wrap(err, "cache empty"))
+		return nil, apierror.Unavailable()
+	}
+
+	countries := []string{}
+	seen := map[string]bool{}
+	for _, dc := range in.Contexts {
+		if !seen[dc.CountryCode] {
+			seen[dc.CountryCode] = true
+			countries = append(countries, dc.CountryCode)
+		}
+	}
+
+	stored, failed, err := h.GetMany(countries)
+	if err != nil {
+		log.Error(errors.Wrap(err, "failed to fetch lists"))
+		return nil, apierror.Unavailable()
+	}
+
+	o, err := loadOverrides()
+	if err != nil {
+		log.Error(err)
+		return nil, apierror.Unavailable()
+	}
+
+	// A failing context does not fail the whole batch.
+	out := &BatchResponse{Results: make([]*Response, len(in.Contexts))}
+	for i, dc := range in.Contexts {
+		// Contexts of a country failing to load fail, instead of falling back to random countries.
+		if err = failed[dc.CountryCode]; err != nil {
+			log.WithField("context", i).Error(err)
+			out.Results[i] = &Response{Err: apierror.Unavailable()}
+			continue
+		}
+
+		var network *Network
+		if network, err = resolve(log, h, dc.Values(), stored[dc.CountryCode], o); err != nil {
+			log.WithField("context", i).Error(err)
+			out.Results[i] = &Response{Err: apierror.Unavailable()}
+			continue
+		}
+
+		out.Results[i] = &Response{Network: network}
+	}
+
+	log.WithFields(logrus.Fields{"contexts": len(in.Contexts), "countries": len(countries)}).Debug("batch resolved")
+	return out, nil
+}
+
+// ValidateBatch validates batch size and that each context carries all arguments, normalizing country codes in place.
+func ValidateBatch(in *BatchRequest) *apierror.Error {
+	if len(in.Contexts) == 0 {
+		return apierror.ValidationFailed(apierror.FieldError{Field: "contexts", Reason: "required"})
+	}
+
+	if max := config.GetInstance().BatchMaxSize; len(in.Contexts) > max {
+		return apierror.ValidationFailed(apierror.FieldError{Field: "contexts", Reason: fmt.Sprintf("must contain at most %d items", max)})
+	}
+
+	details := []apierror.FieldError{}
+	for i, dc := range in.Contexts {
+		if dc == nil {
+			details = append(details, apierror.FieldError{Field: fmt.Sprintf("contexts[%d]", i), Reason: "required"})
+			continue
+		}
+
+		vals := dc.Values()
+		e := validateArgs(vals, required)
+		if e == nil {
+			if e = validateCountry(vals); e == nil {
+				dc.CountryCode = vals.Get("countryCode")
+			}
+		}
+
+		for _, e := range []*apierror.Error{e, validateOptions(vals)} {
+			if e == nil {
+				continue
+			}
+
+			for _, d := range e.Details {
+				details = append(details, apierror.FieldError{Field: fmt.Sprintf("contexts[%d].%s", i, d.Field), Reason: d.Reason})
+			}
+		}
+	}
+
+	if len(details) > 0 {
+		return apierror.ValidationFailed(details...)
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+// +build integration
+
+package endpoints
+
+import (
+	"encoding/json"
+	"expertisetest/config"
+	"expertisetest/server/apierror"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Exclude integration testing since CI currently doesn't support multi container testing.
+
+func TestListBatchPartialFailure(t *testing.T) {
+	h, _ := setup(t)
+
+	// Contexts of a country whose stored network is corrupt fail, the others are served.
+	if err := config.GetInstance().RedisClient.HSet("networks:"+h.Tenant(), "SI", "corrupt").Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	contexts := []string{`{"countryCode": "us", "platform": "android", "osVersion": "9", "device": "phone", "types": ["banner"]}`}
+	for i := 0; i < 3; i++ {
+		contexts = append(contexts, `{"countryCode": "SI", "platform": "android", "osVersion": "9", "device": "phone"}`)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/list/batch", strings.NewReader(`{"contexts": [`+strings.Join(contexts, ",")+`]}`))
+	r.Header.Set("Content-Type", "application/json")
+
+	w := serve(ListBatch, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Got status: %d Expected: %d", w.Code, http.StatusOK)
+	}
+
+	out := struct {
+		Results []struct {
+			Network map[string]json.RawMessage `json:"network"`
+			Err     *struct {
+				Code apierror.Code `json:"code"`
+			} `json:"error"`
+		} `json:"results"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out.Results) != len(contexts) {
+		t.Fatalf("Got results: %d Expected: %d", len(out.Results), len(contexts))
+	}
+
+	first := out.Results[0]
+	if first.Err != nil || string(first.Network["country"]) != `"US"` || first.Network["banner"] == nil || first.Network["video"] != nil {
+		t.Errorf("Got first result: %s Expected the banner list of US", w.Body.String())
+	}
+
+	for i, res := range out.Results[1:] {
+		if res.Err == nil || res.Err.Code != apierror.CodeUnavailable || res.Network != nil {
+			t.Errorf("Got result %d: %+v Expected an unavailable error", i+1, res)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package endpoints
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"expertisetest/config"
+	"expertisetest/handler"
+	"expertisetest/server/middlewares"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// etag returns an entity tag of the /list response for the context in vals.
+// The tag changes with the dataset version, the rules, provider metadata, active kill switches, every postfilter input
+// and the content type.
+// It is weak, since cache misses and retries pick random networks that are equivalent, but not identical.
+func etag(h *handler.Handler, version int64, o *overrides, vals url.Values, contentType string) string {
+	types, limit := options(vals)
+	types = append([]string{}, types...)
+	sort.Strings(types)
+
+	sum := sha256.New()
+	fmt.Fprintf(sum, "%s\n%d\n%s\n", h.Tenant(), version, h.RulesVersion())
+	if o.catalog != nil {
+		fmt.Fprintf(sum, "providers=%d\n", o.catalog.Version)
+	}
+	fmt.Fprintf(sum, "switches=%d/%d\n", o.switches.Version, o.switches.Active(o.now))
+	for _, key := range required {
+		fmt.Fprintf(sum, "%s=%s\n", key, vals.Get(key))
+	}
+	fmt.Fprintf(sum, "types=%s\nlimit=%d\n%s\n", strings.Join(types, ","), limit, contentType)
+
+	return `W/"` + hex.EncodeToString(sum.Sum(nil))[:32] + `"`
+}
+
+// notModified returns true if the If-None-Match header of the request matches tag.
+// Entity tags are compared weakly, as required for If-None-Match.
+func notModified(r *http.Request, tag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(tag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setCacheHeaders lets clients and caches store the response and revalidate it with tag.
+// Responses that are not shared, e.g. depending on the client address, are never stored by shared caches.
+func setCacheHeaders(w http.ResponseWriter, tag string, shared bool) {
+	c := config.GetInstance()
+
+	cacheControl := "no-cache"
+	if c.ListCacheMaxAge > 0 {
+		visibility := "private"
+		if c.ListCachePublic && shared {
+			visibility = "public"
+		}
+		cacheControl = fmt.Sprintf("%s, max-age=%d", visibility, c.ListCacheMaxAge/time.Second)
+	}
+
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Cache-Control", cacheControl)
+	// Responses depend on the tenants the credentials give access to.
+	w.Header().Add("Vary", "Authorization, "+middlewares.APIKeyHeader)
+}
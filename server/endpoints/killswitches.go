@@ -0,0 +1,216 @@
+package endpoints
+
+import (
+	"expertisetest/audit"
+	"expertisetest/auth"
+	"expertisetest/config"
+	"expertisetest/notify"
+	"expertisetest/provider"
+	"expertisetest/server/apierror"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// KillSwitchRequest is the body accepted when creating a kill switch.
+type KillSwitchRequest struct {
+	// Provider is the canonical id or an alias of a registered provider.
+	Provider string `json:"provider" validate:"required"`
+	// Platform, country and ad type the provider is disabled for, all of them when empty.
+	Platform string `json:"platform"`
+	Country  string `json:"country"`
+	AdType   string `json:"adType"`
+	Reason   string `json:"reason"`
+	// ExpiresAt enables the provider again, the switch holds until deleted when empty.
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// KillSwitchesResponse is returned by kill switch endpoints.
+type KillSwitchesResponse struct {
+	Switches []*provider.Switch `json:"switches"`
+}
+
+// KillSwitches handles /killswitches endpoint functionality, listing and creating kill switches.
+// Kill switches drop their provider from every served list they match, on all instances, until they expire or are deleted.
+var KillSwitches = func(w http.ResponseWriter, r *http.Request) {
+	// Fetch logger from logger middleware.
+	log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	// Authorize the client.
+	if !authorize(r.Context(), w, auth.PermRules) {
+		log.WithField("user", subject(r.Context())).Debug("unauthorized")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		all, err := provider.ListSwitches()
+		if err != nil {
+			log.Error(errors.Wrap(err, "failed to list kill switches"))
+			writeError(w, apierror.Unavailable())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, &KillSwitchesResponse{Switches: all})
+	case http.MethodPost:
+		// Switches apply to all tenants.
+		if !authorizeGlobal(r.Context(), w) {
+			log.WithField("user", subject(r.Context())).Debug("unauthorized")
+			return
+		}
+
+		in := &KillSwitchRequest{}
+		if e := readJSON(r, in); e != nil {
+			writeError(w, e)
+			return
+		}
+
+		s, err := provider.CreateSwitch(&provider.Switch{
+			Provider:  in.Provider,
+			Platform:  in.Platform,
+			Country:   in.Country,
+			AdType:    in.AdType,
+			Reason:    in.Reason,
+			CreatedBy: subject(r.Context()),
+			ExpiresAt: in.ExpiresAt,
+		})
+		switch err {
+		case nil:
+		case provider.ErrUnknownProvider:
+			writeError(w, apierror.ValidationFailed(apierror.FieldError{Field: "provider", Reason: "unknown provider"}))
+			return
+		case provider.ErrInvalidCountry:
+			writeError(w, apierror.ValidationFailed(apierror.FieldError{Field: "country", Reason: "must be an ISO 3166-1 country code"}))
+			return
+		case provider.ErrInvalidAdType:
+			writeError(w, apierror.ValidationFailed(apierror.FieldError{Field: "adType", Reason: "unknown ad type"}))
+			return
+		case provider.ErrInvalidExpiry:
+			writeError(w, apierror.ValidationFailed(apierror.FieldError{Field: "expiresAt", Reason: "must be in the future"}))
+			return
+		default:
+			log.Error(errors.Wrap(err, "failed to create kill switch"))
+			writeError(w, apierror.Unavailable())
+			return
+		}
+
+		if entry := audit.FromContext(r.Context()); entry != nil {
+			entry.Params["switch"], entry.Params["provider"] = s.ID, s.Provider
+			// Scopes matching any list are left out.
+			for key, value := range map[string]string{"platform": s.Platform, "country": s.Country, "adType": s.AdType, "reason": s.Reason} {
+				if value != "" {
+					entry.Params[key] = value
+				}
+			}
+			if s.ExpiresAt != nil {
+				entry.Params["expiresAt"] = s.ExpiresAt.UTC().Format(time.RFC3339)
+			}
+		}
+
+		log.WithFields(logrus.Fields{"switch": s.ID, "provider": s.Provider, "platform": s.Platform, "country": s.Country, "adType": s.AdType}).
+			Warn("provider disabled by kill switch")
+		announceProviders(log)
+		writeJSON(w, http.StatusCreated, &KillSwitchesResponse{Switches: []*provider.Switch{s}})
+	default:
+		log.Error("invalid http method on kill switches")
+		writeError(w, apierror.MethodNotAllowed(http.MethodGet, http.MethodPost))
+	}
+}
+
+// KillSwitchAction handles /killswitches/{id}/{action} endpoint functionality.
+// The only supported action is delete, enabling the provider again.
+var KillSwitchAction = func(w http.ResponseWriter, r *http.Request) {
+	// Fetch logger from logger middleware.
+	log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	// Authorize the client, switches apply to all tenants.
+	if !authorize(r.Context(), w, auth.PermRules) || !authorizeGlobal(r.Context(), w) {
+		log.WithField("user", subject(r.Context())).Debug("unauthorized")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		log.Error("invalid http method on kill switches")
+		writeError(w, apierror.MethodNotAllowed(http.MethodPost))
+		return
+	}
+
+	id, action := chi.URLParam(r, "id"), chi.URLParam(r, "action")
+	log = log.WithFields(logrus.Fields{"switch": id, "action": action})
+
+	if action != "delete" {
+		writeError(w, apierror.NotFound("unknown action"))
+		return
+	}
+
+	s, err := provider.DeleteSwitch(id)
+	switch err {
+	case nil:
+	case provider.ErrSwitchNotFound:
+		writeError(w, apierror.NotFound("kill switch not found"))
+		return
+	default:
+		log.Error(errors.Wrap(err, "failed to delete kill switch"))
+		writeError(w, apierror.Unavailable())
+		return
+	}
+
+	if entry := audit.FromContext(r.Context()); entry != nil {
+		entry.Params["switch"], entry.Params["provider"] = s.ID, s.Provider
+	}
+
+	log.WithField("provider", s.Provider).Info("kill switch deleted")
+	announceProviders(log)
+	writeJSON(w, http.StatusOK, &KillSwitchesResponse{Switches: []*provider.Switch{s}})
+}
+
+// ExpireSwitches wakes local watches and subscriptions of every tenant when a kill switch expires, until the broker is closed.
+// Every instance runs its own, so expiry is broadcast to local subscribers only instead of published to all instances.
+// The next expiry is looked up again on every event, so switches changed on any instance are picked up.
+func ExpireSwitches(b *notify.Broker) {
+	log := logrus.WithField("type", "killswitch")
+	events, cancel := b.Subscribe(16)
+	defer cancel()
+
+	for {
+		// Kill switches are looked up again after a while on failure, unless an event arrives first.
+		wait, expiring := time.Minute, false
+		switches, err := provider.CurrentSwitches()
+		if err != nil {
+			log.Error(err)
+		} else if next, ok := switches.NextExpiry(time.Now()); ok {
+			wait, expiring = time.Until(next), true
+		}
+
+		// Without a switch to expire only events wake the loop.
+		timer := time.NewTimer(wait)
+		var expired <-chan time.Time
+		if err != nil || expiring {
+			expired = timer.C
+		}
+
+		select {
+		case _, ok := <-events:
+			timer.Stop()
+			if !ok {
+				return
+			}
+		case <-expired:
+			if expiring {
+				log.Info("kill switch expired")
+				b.Broadcast(&notify.Event{Kind: notify.KindProviders, Time: time.Now().UTC()})
+			}
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package endpoints
+
+import (
+	"expertisetest/auth"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestKillSwitchesRestricted(t *testing.T) {
+	editor := &auth.Identity{Subject: "editor", Role: auth.RoleEditor, Method: auth.MethodToken, Tenants: []string{"default"}}
+	key := &auth.Identity{Subject: "app", Method: auth.MethodAPIKey, Scopes: []auth.Permission{auth.PermRules}, Tenants: []string{"default"}}
+
+	for _, id := range []*auth.Identity{editor, key} {
+		r := httptest.NewRequest(http.MethodPost, "/killswitches", strings.NewReader(`{"provider": "AdMob"}`))
+		r.Header.Set("Content-Type", "application/json")
+		if w := serveAs(id, KillSwitches, r, nil); w.Code != http.StatusForbidden {
+			t.Errorf("%s: Got status: %d Expected: %d creating a switch", id.Subject, w.Code, http.StatusForbidden)
+		}
+
+		r = httptest.NewRequest(http.MethodPost, "/killswitches/1/delete", nil)
+		if w := serveAs(id, KillSwitchAction, r, map[string]string{"id": "1", "action": "delete"}); w.Code != http.StatusForbidden {
+			t.Errorf("%s: Got status: %d Expected: %d deleting a switch", id.Subject, w.Code, http.StatusForbidden)
+		}
+	}
+}
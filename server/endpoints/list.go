@@ -1,22 +1,88 @@
 package endpoints
 
 import (
+	"encoding/json"
 	"expertisetest/adnetwork"
+	"expertisetest/auth"
 	"expertisetest/config"
+	"expertisetest/country"
+	"expertisetest/geoip"
 	"expertisetest/handler"
-	"fmt"
+	"expertisetest/provider"
+	"expertisetest/server/apierror"
+	"expertisetest/server/render"
+	"expertisetest/useragent"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
-	"github.com/pquerna/ffjson/ffjson"
 	"github.com/sirupsen/logrus"
+	"github.com/vmihailenco/msgpack/v4"
 )
 
 // Response is http response object that is returned to the client.
 type Response struct {
-	Network *adnetwork.AdNetwork `json:"network,omitempty"`
-	Err     string               `json:"error,omitempty"`
+	Network *Network        `json:"network,omitempty"`
+	Err     *apierror.Error `json:"error,omitempty"`
+	// DetectedCountry is the country detected from the client address, when countryCode was omitted.
+	DetectedCountry string `json:"detectedCountry,omitempty"`
+	// Derived holds device context arguments derived from the User-Agent and client hints, when they were omitted.
+	Derived *useragent.Context `json:"derived,omitempty"`
+}
+
+// shared returns false if the response depends on the client address or device, not only on the url.
+func (r *Response) shared() bool {
+	return r.DetectedCountry == "" && r.Derived == nil
+}
+
+// Network is the network returned to the client, holding only the requested ad types.
+type Network struct {
+	*adnetwork.AdNetwork
+	types []string
+	// the country is not in the dataset or its lists were empty, networks of random countries were used.
+	fallback bool
+}
+
+// Types returns the requested ad types.
+func (n *Network) Types() []string {
+	return n.types
+}
+
+// networkView holds the lists of requested ad types, the others are nil and omitted.
+type networkView struct {
+	Banner       *[]*adnetwork.SDK `json:"banner,omitempty"`
+	Interstitial *[]*adnetwork.SDK `json:"interstitial,omitempty"`
+	Video        *[]*adnetwork.SDK `json:"video,omitempty"`
+	Country      string            `json:"country"`
+}
+
+func (n *Network) view() *networkView {
+	out := &networkView{Country: n.Country}
+	for _, adType := range n.types {
+		switch adType {
+		case adnetwork.Banner:
+			out.Banner = &n.Banner
+		case adnetwork.Interstitial:
+			out.Interstitial = &n.Interstitial
+		case adnetwork.Video:
+			out.Video = &n.Video
+		}
+	}
+
+	return out
+}
+
+// MarshalJSON omits lists of ad types that were not requested.
+func (n *Network) MarshalJSON() ([]byte, error) {
+	return json.Marshal(n.view())
+}
+
+// EncodeMsgpack omits lists of ad types that were not requested.
+func (n *Network) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return enc.Encode(n.view())
 }
 
 var required = []string{
@@ -36,8 +102,14 @@ var List = func(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Authorize the client.
-	if !authorize(r.Context(), w, "any") {
-		log.WithField("user", r.Context().Value(config.UserKey)).Debug("authorized")
+	if !authorize(r.Context(), w, auth.PermList) {
+		log.WithField("user", subject(r.Context())).Debug("unauthorized")
+		return
+	}
+
+	tenant, ok := authorizeTenant(r, w)
+	if !ok {
+		log.WithFields(logrus.Fields{"user": subject(r.Context()), "app": r.URL.Query().Get("app")}).Debug("unauthorized")
 		return
 	}
 
@@ -45,128 +117,288 @@ var List = func(w http.ResponseWriter, r *http.Request) {
 	// Method check
 	if r.Method != http.MethodGet {
 		log.Error("invalid method")
-		writeResponse(w, 400, fmt.Sprintf("invalid method"), nil)
+		writeError(w, apierror.MethodNotAllowed(http.MethodGet))
+		return
+	}
+
+	codec, ok := negotiate(w, r, &Response{})
+	if !ok {
+		log.WithField("accept", r.Header.Get("Accept")).Debug("not acceptable")
 		return
 	}
 
 	// Validate input
 	vals := r.URL.Query()
-	for _, item := range required {
-		rec := vals[item]
-		if rec == nil || len(rec) > 1 || len(rec) == 0 || rec[0] == "" {
-			log.Error(fmt.Sprintf("missing required argument %q", item))
-			writeResponse(w, http.StatusBadRequest, fmt.Sprintf("missing required argument %q", item), nil)
+	out := detect(log, w, r, vals)
+	if e := ValidateList(vals); e != nil {
+		log.WithField("details", e.Details).Error("invalid arguments")
+		writeError(w, e)
+		return
+	}
+
+	h, err := handler.ForTenant(tenant)
+	if err != nil {
+		log.Error(errors.Wrapf(err, "failed to init handler for %q", tenant))
+		writeError(w, apierror.Internal())
+		return
+	}
+	h.SetLogger(log)
+
+	// Clients holding the current response of the context do not need it again.
+	// Caching is skipped if the dataset version is unavailable, the list is still served.
+	var tag string
+	if version, err := h.Version(); err != nil {
+		log.Error(errors.Wrap(err, "failed to fetch dataset version"))
+	} else if o, err := loadOverrides(); err != nil {
+		log.Error(err)
+	} else {
+		tag = etag(h, version, o, vals, codec.ContentType)
+		if notModified(r, tag) {
+			setCacheHeaders(w, tag, out.shared())
+			w.WriteHeader(http.StatusNotModified)
 			return
 		}
 	}
 
-	// Check if redis is not empty.
-	if data, err := config.GetInstance().RedisClient.DBSize().Result(); data == 0 || err != nil {
-		log.Error("cache empty")
-		writeResponse(w, http.StatusInternalServerError, "internal system error", nil)
+	n, e := ListNetwork(log, h, vals)
+	if e != nil {
+		writeError(w, e)
 		return
 	}
 
-	// Try to fetch desired country
-	h := handler.GetInstance()
-	h.SetLogger(log)
-	out, err := h.Get(vals["countryCode"][0])
+	if tag != "" {
+		setCacheHeaders(w, tag, out.shared())
+	}
+
+	out.Network = n
+	writeResponse(w, codec, http.StatusOK, out)
+}
+
+// detect completes the device context in vals with what is detected from the request, when enabled in Config.
+// The returned response reports the detected arguments.
+func detect(log *logrus.Entry, w http.ResponseWriter, r *http.Request, vals url.Values) *Response {
+	return &Response{
+		DetectedCountry: locate(log, r, vals),
+		Derived:         derive(log, w, r, vals),
+	}
+}
+
+// locate sets countryCode of vals to the country of the client address when it is omitted
+// and a GeoIP database is configured. The detected country is returned, empty if there is none.
+func locate(log *logrus.Entry, r *http.Request, vals url.Values) string {
+	l := geoip.GetInstance()
+	if l == nil || len(vals["countryCode"]) > 0 {
+		return ""
+	}
+
+	ip := geoip.ParseAddr(r.RemoteAddr)
+	code, err := l.Country(ip)
 	if err != nil {
-		log.Error(errors.Wrapf(err, "failed to fetch list for country %q", vals["countryCode"][0]))
-		writeResponse(w, http.StatusInternalServerError, errors.Wrap(err, "internal system error").Error(), nil)
-		return
+		log.Error(errors.Wrap(err, "failed to detect country"))
+		return ""
+	}
+	// Databases may carry continent codes such as EU for unassigned networks.
+	if !country.Valid(code) {
+		log.WithFields(logrus.Fields{"ip": ip, "country": code}).Debug("country not detected")
+		return ""
 	}
 
-	// If cache miss occurs, retry with a random country.
-	// Run both pre- and post- filter processes on random set.
-	// If any of the three arrays is empty, retry with a different random set.
-	// This ensures all 3 lists are returned non empty.
-	// If all checks fail return the error.
-	if out == nil {
-		log.WithField("countryCode", vals["countryCode"][0]).Warn("cache miss")
-		out, err = h.GetRandom()
-		if err != nil {
-			log.Error(errors.Wrap(err, "failed to fetch random"))
-			writeResponse(w, http.StatusInternalServerError, errors.Wrap(err, "internal system error").Error(), nil)
-			return
-		}
+	vals.Set("countryCode", code)
+	return code
+}
 
-		out.Country = vals["countryCode"][0]
-		arr := h.Prefilter([]*adnetwork.AdNetwork{out})
-		if len(arr) != 1 {
-			log.Error(errors.Wrap(err, "failed to prefilter"))
-			writeResponse(w, http.StatusInternalServerError, errors.Wrap(err, "internal system error").Error(), nil)
-			return
+// derive sets platform, osVersion and device omitted from vals to the ones derived from the User-Agent and client hints,
+// when enabled in Config. The derived arguments are returned, nil if none was derived.
+func derive(log *logrus.Entry, w http.ResponseWriter, r *http.Request, vals url.Values) *useragent.Context {
+	if !config.GetInstance().UserAgentDetection {
+		return nil
+	}
+	// Browsers send client hints once asked for them.
+	w.Header().Set("Accept-CH", strings.Join(useragent.Hints, ", "))
+
+	// Explicit arguments always win over derived ones.
+	parsed, derived := useragent.Parse(r.Header), &useragent.Context{}
+	set := func(key, value string, dst *string) {
+		if len(vals[key]) == 0 && value != "" {
+			vals.Set(key, value)
+			*dst = value
 		}
-		out = arr[0]
+	}
+	set("platform", parsed.Platform, &derived.Platform)
+	set("osVersion", parsed.OsVersion, &derived.OsVersion)
+	set("device", parsed.Device, &derived.Device)
 
-		out = h.Postfilter(vals, out)
-		if testEmpty(out) {
-			for i := 0; i < config.GetInstance().RetryAttempts; i++ {
-				err = nil
-				out, err = retry(vals)
-				if err != nil {
-					log.Error(errors.Wrap(err, "failed to retry"))
-				}
+	if *derived == (useragent.Context{}) {
+		return nil
+	}
 
-				if !testEmpty(out) {
-					log.WithField("countryCode", vals["countryCode"][0]).Warn("retry miss")
-					break
-				}
-			}
-		}
+	log.WithFields(logrus.Fields{"platform": derived.Platform, "osVersion": derived.OsVersion, "device": derived.Device}).Debug("device context derived")
+	w.Header().Add("Vary", "User-Agent, "+strings.Join(useragent.Hints, ", "))
+	return derived
+}
 
-		// if last executed retry was an error, return error to client.
+// ValidateList validates /list arguments in vals.
+func ValidateList(vals url.Values) *apierror.Error {
+	if e := validateArgs(vals, required); e != nil {
+		return e
+	}
+	if e := validateCountry(vals); e != nil {
+		return e
+	}
+
+	return validateOptions(vals)
+}
+
+// ListNetwork returns the network for the device context in vals, validated by ValidateList.
+func ListNetwork(log *logrus.Entry, h *handler.Handler, vals url.Values) (*Network, *apierror.Error) {
+	// Check if redis is not empty.
+	if data, err := h.Size(); data == 0 || err != nil {
+		log.Error(errors.Wrap(err, "cache empty"))
+		return nil, apierror.Unavailable()
+	}
+
+	// Try to fetch desired country
+	stored, err := h.Get(vals.Get("countryCode"))
+	if err != nil {
+		log.Error(errors.Wrapf(err, "failed to fetch list for country %q", vals.Get("countryCode")))
+		return nil, apierror.Unavailable()
+	}
+
+	o, err := loadOverrides()
+	if err != nil {
+		log.Error(err)
+		return nil, apierror.Unavailable()
+	}
+
+	out, err := resolve(log, h, vals, stored, o)
+	if err != nil {
+		log.Error(err)
+		return nil, apierror.Unavailable()
+	}
+
+	return out, nil
+}
+
+// resolve returns the network for the device context in vals, starting from the stored network of its country.
+// If cache miss occurs, a random country is used instead and both pre- and post- filter processes are run on it.
+// If any of the requested arrays is empty after postfiltering, retry with a different random set.
+// This ensures all requested lists are returned non empty, truncated to the requested limit.
+// Overrides o are applied to lists before they are tested.
+// If the last retry fails its error is returned.
+func resolve(log *logrus.Entry, h *handler.Handler, vals url.Values, stored *adnetwork.AdNetwork, o *overrides) (*Network, error) {
+	types, limit := options(vals)
+
+	var out *adnetwork.AdNetwork
+	fallback := stored == nil
+	if stored != nil {
+		// Stored network can be shared between contexts, postfilter works on a copy.
+		out = stored.Copy()
+	} else {
+		log.WithField("countryCode", vals.Get("countryCode")).Warn("cache miss")
+
+		random, err := h.GetRandom()
 		if err != nil {
-			writeResponse(w, http.StatusInternalServerError, errors.Wrap(err, "internal system error").Error(), nil)
-			return
+			return nil, errors.Wrap(err, "failed to fetch random")
 		}
 
-		writeResponse(w, http.StatusOK, "", out)
-		return
+		random.Country = vals.Get("countryCode")
+		arr := h.Prefilter([]*adnetwork.AdNetwork{random})
+		if len(arr) != 1 {
+			return nil, errors.New("failed to prefilter")
+		}
+		out = arr[0]
 	}
 
-	// Cache hit, postfilter
-	out = h.Postfilter(vals, out)
+	out = o.apply(vals, h.Postfilter(vals, out))
 
 	// Incase postfilter caused empty lists retry.
-	// If last check before exiting the retrying phase has yielded an error,
-	// then return an error to the user.
-	if testEmpty(out) {
+	var err error
+	if testEmpty(out, types) {
+		fallback = true
 		for i := 0; i < config.GetInstance().RetryAttempts; i++ {
 			err = nil
-			out, err = retry(vals)
+			out, err = retry(h, vals)
 			if err != nil {
 				log.Error(errors.Wrap(err, "failed to retry"))
 			}
+			out = o.apply(vals, out)
 
-			if !testEmpty(out) {
-				log.WithField("countryCode", vals["countryCode"][0]).Warn("retry miss")
+			if !testEmpty(out, types) {
+				log.WithField("countryCode", vals.Get("countryCode")).Warn("retry miss")
 				break
 			}
 		}
 	}
 
-	// if last executed retry was an error, return error to client.
+	// if last executed retry was an error, return it.
 	if err != nil {
-		writeResponse(w, http.StatusInternalServerError, errors.Wrap(err, "internal system error").Error(), nil)
-		return
+		return nil, errors.Wrap(err, "failed to retry")
 	}
 
-	writeResponse(w, http.StatusOK, "", out)
+	if limit > 0 {
+		out.Limit(limit)
+	}
+
+	return &Network{AdNetwork: out, types: types, fallback: fallback}, nil
 }
 
-// returns true/false depending on the size of AdNetwork arrays.
-func testEmpty(an *adnetwork.AdNetwork) bool {
-	return len(an.Banner) == 0 ||
-		len(an.Interstitial) == 0 ||
-		len(an.Video) == 0
+// overrides are applied to every served list, on top of the rules of its tenant.
+type overrides struct {
+	// catalog of provider metadata joined into lists, nil when disabled in Config.
+	catalog *provider.Catalog
+	// switches are applied as active at now, so lists and their entity tags agree.
+	switches *provider.Switches
+	now      time.Time
 }
 
-// retry to fetch and process a random country, to achieve non-null lists.
-func retry(vals url.Values) (*adnetwork.AdNetwork, error) {
-	h := handler.GetInstance()
+// returns the overrides currently in effect.
+func loadOverrides() (*overrides, error) {
+	o := &overrides{now: time.Now()}
 
+	var err error
+	if config.GetInstance().ProviderMetadata {
+		if o.catalog, err = provider.Current(); err != nil {
+			return nil, err
+		}
+	}
+
+	o.switches, err = provider.CurrentSwitches()
+	return o, err
+}
+
+// joins provider metadata into the network and drops providers disabled by kill switches.
+func (o *overrides) apply(vals url.Values, an *adnetwork.AdNetwork) *adnetwork.AdNetwork {
+	if an == nil {
+		return nil
+	}
+
+	if o.catalog != nil {
+		o.catalog.Join(an, vals.Get("platform"))
+	}
+	o.switches.Apply(an, vals.Get("platform"), vals.Get("countryCode"), o.now)
+
+	return an
+}
+
+// returns true/false depending on the size of requested AdNetwork arrays.
+func testEmpty(an *adnetwork.AdNetwork, types []string) bool {
+	return an == nil || an.AnyEmpty(types)
+}
+
+// returns requested ad types and list length, all types and no limit by default.
+// Arguments are expected to be validated by validateOptions.
+func options(vals url.Values) ([]string, int) {
+	types := adnetwork.AdTypes
+	if raw := vals.Get("types"); raw != "" {
+		types = strings.Split(raw, ",")
+	}
+
+	limit, _ := strconv.Atoi(vals.Get("limit"))
+	return types, limit
+}
+
+// retry to fetch and process a random country, to achieve non-null lists.
+func retry(h *handler.Handler, vals url.Values) (*adnetwork.AdNetwork, error) {
 	out, err := h.GetRandom()
 	if err != nil {
 		return out, errors.Wrapf(err, "failed to fetch random key")
@@ -183,22 +415,105 @@ func retry(vals url.Values) (*adnetwork.AdNetwork, error) {
 	return out, nil
 }
 
-// helper to write response to users.
-func writeResponse(w http.ResponseWriter, status int, errStr string, out *adnetwork.AdNetwork) {
-	w.WriteHeader(status)
+// validates that each required argument is given exactly once and not empty.
+func validateArgs(vals url.Values, required []string) *apierror.Error {
+	details := []apierror.FieldError{}
+	for _, item := range required {
+		rec := vals[item]
+		switch {
+		case len(rec) == 0 || rec[0] == "":
+			details = append(details, apierror.FieldError{Field: item, Reason: "required"})
+		case len(rec) > 1:
+			details = append(details, apierror.FieldError{Field: item, Reason: "must be given once"})
+		}
+	}
 
-	body, err := ffjson.Marshal(&Response{
-		Network: out,
-		Err:     errStr,
-	})
-	if err != nil {
-		logrus.Error(err)
-		return
+	if len(details) > 0 {
+		return apierror.InvalidArguments(details...)
+	}
+
+	return nil
+}
+
+// validates countryCode and normalizes it in vals to the ISO 3166-1 alpha-2 code datasets are stored by.
+func validateCountry(vals url.Values) *apierror.Error {
+	code, ok := country.Normalize(vals.Get("countryCode"))
+	if !ok {
+		return apierror.InvalidArguments(apierror.FieldError{Field: "countryCode", Reason: "must be an ISO 3166-1 country code"})
+	}
+
+	vals.Set("countryCode", code)
+	return nil
+}
+
+// validates optional ad types and list length arguments.
+func validateOptions(vals url.Values) *apierror.Error {
+	details := []apierror.FieldError{}
+	if rec, ok := vals["types"]; ok {
+		switch {
+		case len(rec) > 1:
+			details = append(details, apierror.FieldError{Field: "types", Reason: "must be given once"})
+		case rec[0] == "":
+			details = append(details, apierror.FieldError{Field: "types", Reason: "must not be empty"})
+		default:
+			for _, adType := range strings.Split(rec[0], ",") {
+				if !containsString(adnetwork.AdTypes, adType) {
+					details = append(details, apierror.FieldError{Field: "types", Reason: "must be one of " + strings.Join(adnetwork.AdTypes, ", ")})
+					break
+				}
+			}
+		}
 	}
 
-	if _, err := w.Write(body); err != nil {
-		logrus.Error(err)
+	if rec, ok := vals["limit"]; ok {
+		if len(rec) > 1 {
+			details = append(details, apierror.FieldError{Field: "limit", Reason: "must be given once"})
+		} else if limit, err := strconv.Atoi(rec[0]); err != nil || limit < 1 {
+			details = append(details, apierror.FieldError{Field: "limit", Reason: "must be a positive integer"})
+		}
+	}
+
+	if len(details) > 0 {
+		return apierror.InvalidArguments(details...)
+	}
+
+	return nil
+}
+
+func containsString(arr []string, target string) bool {
+	for _, item := range arr {
+		if item == target {
+			return true
+		}
+	}
+
+	return false
+}
+
+// helper to write response to users.
+func writeResponse(w http.ResponseWriter, codec *render.Codec, status int, out *Response) {
+	render.Write(w, codec, status, out)
+}
+
+// helper to write error to users.
+func writeError(w http.ResponseWriter, e *apierror.Error) {
+	apierror.Write(w, e)
+}
+
+// helper to write any json body to users.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	render.Write(w, render.JSON, status, v)
+}
+
+// negotiate returns the codec of v accepted by the client, answering with 406 if there is none.
+func negotiate(w http.ResponseWriter, r *http.Request, v interface{}) (*render.Codec, bool) {
+	w.Header().Add("Vary", "Accept")
+
+	codec := render.Negotiate(r.Header.Get("Accept"), v)
+	if codec == nil {
+		writeError(w, apierror.NotAcceptable(render.Available(v)...))
+		return nil, false
 	}
 
-	return
+	return codec, true
 }
@@ -0,0 +1,141 @@
+// +build integration
+
+package endpoints
+
+import (
+	"context"
+	"expertisetest/adnetwork"
+	"expertisetest/auth"
+	"expertisetest/config"
+	"expertisetest/handler"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Exclude integration testing since CI currently doesn't support multi container testing.
+
+const listQuery = "countryCode=US&platform=android&osVersion=9&device=phone"
+
+func TestListNotModified(t *testing.T) {
+	setup(t)
+
+	w := serve(List, get("/list?"+listQuery, "", ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Got status: %d Expected: %d", w.Code, http.StatusOK)
+	}
+
+	tag := w.Header().Get("ETag")
+	if !strings.HasPrefix(tag, `W/"`) {
+		t.Fatalf("Got ETag: %q Expected a weak entity tag", tag)
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		accept      string
+		ifNoneMatch string
+		status      int
+		// the response has a tag other than the one of the first response.
+		changed bool
+	}{
+		{"same context", listQuery, "", tag, http.StatusNotModified, false},
+		{"strong comparison", listQuery, "", strings.TrimPrefix(tag, "W/"), http.StatusNotModified, false},
+		{"any of the tags", listQuery, "", `W/"other", ` + tag, http.StatusNotModified, false},
+		{"any tag", listQuery, "", "*", http.StatusNotModified, false},
+		{"other tag", listQuery, "", `W/"other"`, http.StatusOK, false},
+		{"other types", listQuery + "&types=banner", "", tag, http.StatusOK, true},
+		{"other limit", listQuery + "&limit=1", "", tag, http.StatusOK, true},
+		{"other content type", listQuery, "application/msgpack", tag, http.StatusOK, true},
+		{"other context", strings.Replace(listQuery, "phone", "tablet", 1), "", tag, http.StatusOK, true},
+	}
+
+	for _, tt := range tests {
+		w := serve(List, get("/list?"+tt.query, tt.accept, tt.ifNoneMatch))
+		if w.Code != tt.status {
+			t.Errorf("%s: Got status: %d Expected: %d", tt.name, w.Code, tt.status)
+		}
+		if tt.status == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Errorf("%s: Got body: %q Expected none", tt.name, w.Body.String())
+		}
+
+		if got := w.Header().Get("ETag"); got == "" || (got != tag) != tt.changed {
+			t.Errorf("%s: Got ETag: %q Expected changed from %q: %v", tt.name, got, tag, tt.changed)
+		}
+	}
+
+	// Types are a set, their order does not change the response.
+	types := serve(List, get("/list?"+listQuery+"&types=banner,video", "", "")).Header().Get("ETag")
+	if w := serve(List, get("/list?"+listQuery+"&types=video,banner", "", types)); w.Code != http.StatusNotModified {
+		t.Errorf("Got status: %d Expected: %d for types in another order", w.Code, http.StatusNotModified)
+	}
+
+	// Publishing a dataset changes the version and with it the tag.
+	setup(t)
+	if w := serve(List, get("/list?"+listQuery, "", tag)); w.Code != http.StatusOK || w.Header().Get("ETag") == tag {
+		t.Errorf("Got status: %d ETag: %q Expected: %d with a new tag after publishing", w.Code, w.Header().Get("ETag"), http.StatusOK)
+	}
+}
+
+// setup points Config at the test redis and publishes the test dataset in the default tenant,
+// returning its handler and the dataset.
+// Config and rules are loaded from the repository root, as by the api.
+func setup(t *testing.T) (*handler.Handler, map[string]*adnetwork.AdNetwork) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.Chdir("../.."); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Chdir(wd); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	config.OverrideInstance(config.New())
+	config.GetInstance().Pipefile = "handler/pipefile_test.json"
+	config.GetInstance().DisableLogging()
+
+	h := handler.GetInstance()
+	networks, err := h.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = h.Store(networks, true); err != nil {
+		t.Fatal(err)
+	}
+
+	return h, networks
+}
+
+// returns a GET request, Accept and If-None-Match headers are only set when given.
+func get(target, accept, ifNoneMatch string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	if ifNoneMatch != "" {
+		r.Header.Set("If-None-Match", ifNoneMatch)
+	}
+
+	return r
+}
+
+// serves the request by the endpoint for a client authenticated with basic credentials.
+func serve(endpoint http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	id := &auth.Identity{Subject: "client", Role: auth.RoleClient, Method: auth.MethodBasic}
+	ctx := context.WithValue(r.Context(), config.IdentityKey, id)
+	ctx = context.WithValue(ctx, config.LogKey, logrus.NewEntry(logrus.StandardLogger()))
+
+	w := httptest.NewRecorder()
+	endpoint(w, r.WithContext(ctx))
+	return w
+}
@@ -0,0 +1,137 @@
+package endpoints
+
+import (
+	"expertisetest/auth"
+	"expertisetest/config"
+	"expertisetest/server/apierror"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/pquerna/ffjson/ffjson"
+	"github.com/sirupsen/logrus"
+)
+
+// LoginRequest is the body accepted by /login.
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// RefreshRequest is the body accepted by /refresh.
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+// Login handles /login endpoint functionality.
+// Credentials are accepted either as json body or basic auth header.
+var Login = func(w http.ResponseWriter, r *http.Request) {
+	// Fetch logger from logger middleware.
+	log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	if r.Method != http.MethodPost {
+		log.Error("invalid http method on login")
+		writeError(w, apierror.MethodNotAllowed(http.MethodPost))
+		return
+	}
+
+	in := &LoginRequest{}
+	if user, pass, ok := r.BasicAuth(); ok {
+		in.Username, in.Password = user, pass
+	} else if e := readJSON(r, in); e != nil {
+		log.Error(errors.Wrap(e, "failed to parse login request"))
+		writeError(w, e)
+		return
+	}
+
+	id, err := auth.Authenticate(in.Username, in.Password)
+	if err != nil {
+		log.WithField("user", in.Username).Warn("failed login")
+		writeError(w, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthenticated, "invalid username or password"))
+		return
+	}
+
+	tokens, err := auth.Issue(id)
+	if err != nil {
+		log.Error(errors.Wrap(err, "failed to issue token"))
+		writeError(w, apierror.Internal())
+		return
+	}
+
+	log.WithField("user", id.Subject).Info("login")
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// Refresh handles /refresh endpoint functionality.
+var Refresh = func(w http.ResponseWriter, r *http.Request) {
+	// Fetch logger from logger middleware.
+	log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	if r.Method != http.MethodPost {
+		log.Error("invalid http method on refresh")
+		writeError(w, apierror.MethodNotAllowed(http.MethodPost))
+		return
+	}
+
+	in := &RefreshRequest{}
+	if e := readJSON(r, in); e != nil {
+		writeError(w, e)
+		return
+	}
+
+	if in.RefreshToken == "" {
+		writeError(w, apierror.ValidationFailed(apierror.FieldError{Field: "refreshToken", Reason: "required"}))
+		return
+	}
+
+	tokens, err := auth.Refresh(in.RefreshToken)
+	if err == auth.ErrInvalidToken {
+		writeError(w, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthenticated, "invalid or expired token"))
+		return
+	}
+
+	if err != nil {
+		log.Error(errors.Wrap(err, "failed to refresh token"))
+		writeError(w, apierror.Internal())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// reads and unmarshals json request body.
+// Returned error is ready to be written to the client.
+func readJSON(r *http.Request, v interface{}) *apierror.Error {
+	if r.Body == nil {
+		return apierror.InvalidBody()
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		return apierror.UnsupportedMediaType("application/json")
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		logrus.Error(errors.Wrap(err, "failed to read body"))
+		return apierror.InvalidBody()
+	}
+
+	if err = r.Body.Close(); err != nil {
+		logrus.Error(errors.Wrap(err, "failed to close body"))
+	}
+
+	if len(b) == 0 || ffjson.Unmarshal(b, v) != nil {
+		return apierror.InvalidBody()
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package endpoints
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative pb/list.proto
+
+import (
+	"expertisetest/adnetwork"
+	"expertisetest/server/endpoints/pb"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Protobuf encoding of responses, messages are defined in pb/list.proto.
+
+// MarshalProto encodes the response as Response message.
+func (r *Response) MarshalProto() ([]byte, error) {
+	return proto.Marshal(r.message())
+}
+
+// MarshalProto encodes the response as BatchResponse message.
+func (r *BatchResponse) MarshalProto() ([]byte, error) {
+	out := &pb.BatchResponse{}
+	for _, result := range r.Results {
+		out.Results = append(out.Results, result.message())
+	}
+
+	return proto.Marshal(out)
+}
+
+func (r *Response) message() *pb.Response {
+	out := &pb.Response{DetectedCountry: r.DetectedCountry}
+	if r.Network != nil {
+		n := r.Network.view()
+		out.Network = &pb.Network{
+			Banner:       sdkMessages(n.Banner),
+			Interstitial: sdkMessages(n.Interstitial),
+			Video:        sdkMessages(n.Video),
+			Country:      n.Country,
+		}
+	}
+
+	if r.Err != nil {
+		out.Error = &pb.Error{Code: string(r.Err.Code), Message: r.Err.Message}
+		for _, d := range r.Err.Details {
+			out.Error.Details = append(out.Error.Details, &pb.FieldError{Field: d.Field, Reason: d.Reason})
+		}
+	}
+
+	if r.Derived != nil {
+		out.Derived = &pb.DeviceContext{Platform: r.Derived.Platform, OsVersion: r.Derived.OsVersion, Device: r.Derived.Device}
+	}
+
+	return out
+}
+
+// returns messages of the list, lists of ad types that were not requested are nil.
+func sdkMessages(list *[]*adnetwork.SDK) []*pb.SDK {
+	if list == nil {
+		return nil
+	}
+
+	out := make([]*pb.SDK, 0, len(*list))
+	for _, sdk := range *list {
+		msg := &pb.SDK{Provider: sdk.Provider, Score: sdk.Score}
+		if s := sdk.Settings; s != nil {
+			msg.Settings = &pb.Settings{PlacementId: s.PlacementID, AdUnitId: s.AdUnitID, AdapterVersion: s.AdapterVersion, TimeoutMs: int32(s.TimeoutMs)}
+		}
+		out = append(out, msg)
+	}
+
+	return out
+}
@@ -0,0 +1,106 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"expertisetest/adnetwork"
+	"expertisetest/server/apierror"
+	"expertisetest/server/endpoints/pb"
+	"expertisetest/useragent"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestMarshalProto(t *testing.T) {
+	network := &Network{
+		AdNetwork: &adnetwork.AdNetwork{
+			Country: "SI",
+			Banner: []*adnetwork.SDK{
+				{Provider: "AdMob", Score: 0.5, Settings: &adnetwork.Settings{PlacementID: "p1", AdUnitID: "u1", AdapterVersion: "19.1.0", TimeoutMs: 3000}},
+				{Provider: "Vungle"},
+			},
+			Interstitial: []*adnetwork.SDK{{Provider: "Moloco", Score: 2}},
+			Video:        []*adnetwork.SDK{{Provider: "Facebook", Score: 1.25}},
+		},
+		types: []string{adnetwork.Banner, adnetwork.Video},
+	}
+
+	tests := []struct {
+		message  proto.Message
+		in       interface{ MarshalProto() ([]byte, error) }
+		expected string
+	}{
+		{
+			&pb.Response{},
+			&Response{Network: network, DetectedCountry: "SI", Derived: &useragent.Context{Platform: "android", OsVersion: "9", Device: "phone"}},
+			`{"network": {"banner": [{"provider": "AdMob", "score": 0.5, "settings": {"placementId": "p1", "adUnitId": "u1", "adapterVersion": "19.1.0", "timeoutMs": 3000}}, {"provider": "Vungle"}],
+			  "video": [{"provider": "Facebook", "score": 1.25}], "country": "SI"},
+			  "detectedCountry": "SI", "derived": {"platform": "android", "osVersion": "9", "device": "phone"}}`,
+		},
+		{
+			&pb.BatchResponse{},
+			&BatchResponse{Results: []*Response{
+				{Network: network},
+				{Err: apierror.ValidationFailed(apierror.FieldError{Field: "countryCode", Reason: "required"})},
+			}},
+			`{"results": [
+			  {"network": {"banner": [{"provider": "AdMob", "score": 0.5, "settings": {"placementId": "p1", "adUnitId": "u1", "adapterVersion": "19.1.0", "timeoutMs": 3000}}, {"provider": "Vungle"}],
+			   "video": [{"provider": "Facebook", "score": 1.25}], "country": "SI"}},
+			  {"error": {"code": "validation_failed", "message": "validation failed", "details": [{"field": "countryCode", "reason": "required"}]}}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		b, err := tt.in.MarshalProto()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		name := tt.message.ProtoReflect().Descriptor().Name()
+		if err = proto.Unmarshal(b, tt.message); err != nil {
+			t.Fatalf("%s: failed to decode: %v", name, err)
+		}
+		if unknown(tt.message.ProtoReflect()) {
+			t.Errorf("%s: fields not in list.proto", name)
+		}
+
+		out, err := protojson.Marshal(tt.message)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got, expected interface{}
+		if err = json.Unmarshal(out, &got); err != nil {
+			t.Fatal(err)
+		}
+		if err = json.Unmarshal([]byte(tt.expected), &expected); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%s: Got: %s Expected: %s", name, out, tt.expected)
+		}
+	}
+}
+
+// returns true if the message or any message it holds has unknown fields.
+func unknown(m protoreflect.Message) bool {
+	found := len(m.GetUnknown()) > 0
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.Message() == nil:
+		case fd.IsList():
+			for i := 0; i < v.List().Len(); i++ {
+				found = found || unknown(v.List().Get(i).Message())
+			}
+		default:
+			found = found || unknown(v.Message())
+		}
+		return !found
+	})
+
+	return found
+}
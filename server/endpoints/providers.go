@@ -0,0 +1,165 @@
+package endpoints
+
+import (
+	"expertisetest/audit"
+	"expertisetest/auth"
+	"expertisetest/config"
+	"expertisetest/notify"
+	"expertisetest/provider"
+	"expertisetest/server/apierror"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ProviderRequest is the body accepted when setting provider metadata.
+type ProviderRequest struct {
+	// ID is the canonical id or an alias of a registered provider.
+	ID         string                `json:"id" validate:"required"`
+	Placements []*provider.Placement `json:"placements"`
+}
+
+// ProvidersResponse is returned by provider metadata endpoints.
+type ProvidersResponse struct {
+	Providers []*provider.Metadata `json:"providers"`
+	// Registry lists the ids of all registered providers, when listing metadata.
+	Registry []string `json:"registry,omitempty"`
+}
+
+// Providers handles /providers endpoint functionality, listing and setting provider metadata.
+var Providers = func(w http.ResponseWriter, r *http.Request) {
+	// Fetch logger from logger middleware.
+	log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	// Authorize the client.
+	if !authorize(r.Context(), w, auth.PermRules) {
+		log.WithField("user", subject(r.Context())).Debug("unauthorized")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		all, err := provider.List()
+		if err != nil {
+			log.Error(errors.Wrap(err, "failed to list provider metadata"))
+			writeError(w, apierror.Unavailable())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, &ProvidersResponse{Providers: all, Registry: provider.GetInstance().IDs()})
+	case http.MethodPost:
+		// Metadata is shared by all tenants.
+		if !authorizeGlobal(r.Context(), w) {
+			log.WithField("user", subject(r.Context())).Debug("unauthorized")
+			return
+		}
+
+		in := &ProviderRequest{}
+		if e := readJSON(r, in); e != nil {
+			writeError(w, e)
+			return
+		}
+
+		md, err := provider.Put(in.ID, in.Placements)
+		switch err {
+		case nil:
+		case provider.ErrUnknownProvider:
+			writeError(w, apierror.ValidationFailed(apierror.FieldError{Field: "id", Reason: "unknown provider"}))
+			return
+		case provider.ErrInvalidPlacement:
+			writeError(w, apierror.ValidationFailed(apierror.FieldError{Field: "placements", Reason: "must not be null"}))
+			return
+		case provider.ErrInvalidAdType:
+			writeError(w, apierror.ValidationFailed(apierror.FieldError{Field: "placements", Reason: "unknown ad type"}))
+			return
+		case provider.ErrInvalidTimeout:
+			writeError(w, apierror.ValidationFailed(apierror.FieldError{Field: "placements", Reason: "timeout must not be negative"}))
+			return
+		case provider.ErrDuplicatePlacement:
+			writeError(w, apierror.ValidationFailed(apierror.FieldError{Field: "placements", Reason: "platform and ad type must be unique"}))
+			return
+		default:
+			log.Error(errors.Wrap(err, "failed to set provider metadata"))
+			writeError(w, apierror.Unavailable())
+			return
+		}
+
+		if entry := audit.FromContext(r.Context()); entry != nil {
+			entry.Params["provider"], entry.Params["placements"] = md.ID, strconv.Itoa(len(md.Placements))
+		}
+
+		log.WithFields(logrus.Fields{"provider": md.ID, "placements": len(md.Placements)}).Info("provider metadata set")
+		announceProviders(log)
+		writeJSON(w, http.StatusOK, &ProvidersResponse{Providers: []*provider.Metadata{md}})
+	default:
+		log.Error("invalid http method on providers")
+		writeError(w, apierror.MethodNotAllowed(http.MethodGet, http.MethodPost))
+	}
+}
+
+// ProviderAction handles /providers/{id}/{action} endpoint functionality.
+// The only supported action is delete, providers without metadata are dropped from lists.
+var ProviderAction = func(w http.ResponseWriter, r *http.Request) {
+	// Fetch logger from logger middleware.
+	log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	// Authorize the client, metadata is shared by all tenants.
+	if !authorize(r.Context(), w, auth.PermRules) || !authorizeGlobal(r.Context(), w) {
+		log.WithField("user", subject(r.Context())).Debug("unauthorized")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		log.Error("invalid http method on providers")
+		writeError(w, apierror.MethodNotAllowed(http.MethodPost))
+		return
+	}
+
+	id, action := chi.URLParam(r, "id"), chi.URLParam(r, "action")
+	log = log.WithFields(logrus.Fields{"provider": id, "action": action})
+
+	if action != "delete" {
+		writeError(w, apierror.NotFound("unknown action"))
+		return
+	}
+
+	md, err := provider.Delete(id)
+	switch err {
+	case nil:
+	case provider.ErrNotFound:
+		writeError(w, apierror.NotFound("provider metadata not found"))
+		return
+	default:
+		log.Error(errors.Wrap(err, "failed to delete provider metadata"))
+		writeError(w, apierror.Unavailable())
+		return
+	}
+
+	if entry := audit.FromContext(r.Context()); entry != nil {
+		entry.Params["provider"] = md.ID
+	}
+
+	log.Info("provider metadata deleted")
+	announceProviders(log)
+	writeJSON(w, http.StatusOK, &ProvidersResponse{Providers: []*provider.Metadata{md}})
+}
+
+// announces changed provider metadata or kill switches, so watches and subscriptions of every tenant refresh.
+// The change is stored either way, subscribers only miss the announcement.
+func announceProviders(log *logrus.Entry) {
+	if err := notify.Publish(&notify.Event{Kind: notify.KindProviders, Time: time.Now().UTC()}); err != nil {
+		log.Error(err)
+	}
+}
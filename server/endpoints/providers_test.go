@@ -0,0 +1,48 @@
+package endpoints
+
+import (
+	"context"
+	"expertisetest/auth"
+	"expertisetest/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+)
+
+func TestProvidersRestricted(t *testing.T) {
+	editor := &auth.Identity{Subject: "editor", Role: auth.RoleEditor, Method: auth.MethodToken, Tenants: []string{"default"}}
+	key := &auth.Identity{Subject: "app", Method: auth.MethodAPIKey, Scopes: []auth.Permission{auth.PermRules}, Tenants: []string{"default"}}
+
+	for _, id := range []*auth.Identity{editor, key} {
+		r := httptest.NewRequest(http.MethodPost, "/providers", strings.NewReader(`{"id": "AdMob", "placements": []}`))
+		r.Header.Set("Content-Type", "application/json")
+		if w := serveAs(id, Providers, r, nil); w.Code != http.StatusForbidden {
+			t.Errorf("%s: Got status: %d Expected: %d setting metadata", id.Subject, w.Code, http.StatusForbidden)
+		}
+
+		r = httptest.NewRequest(http.MethodPost, "/providers/AdMob/delete", nil)
+		if w := serveAs(id, ProviderAction, r, map[string]string{"id": "AdMob", "action": "delete"}); w.Code != http.StatusForbidden {
+			t.Errorf("%s: Got status: %d Expected: %d deleting metadata", id.Subject, w.Code, http.StatusForbidden)
+		}
+	}
+}
+
+// serves the request by the endpoint for the identity, with given url parameters of the route.
+func serveAs(id *auth.Identity, endpoint http.HandlerFunc, r *http.Request, params map[string]string) *httptest.ResponseRecorder {
+	rctx := chi.NewRouteContext()
+	for key, value := range params {
+		rctx.URLParams.Add(key, value)
+	}
+
+	ctx := context.WithValue(r.Context(), config.IdentityKey, id)
+	ctx = context.WithValue(ctx, config.LogKey, logrus.NewEntry(logrus.StandardLogger()))
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+
+	w := httptest.NewRecorder()
+	endpoint(w, r.WithContext(ctx))
+	return w
+}
@@ -1,14 +1,20 @@
 package endpoints
 
 import (
+	"context"
+	"expertisetest/audit"
+	"expertisetest/auth"
 	"expertisetest/config"
+	"expertisetest/country"
 	"expertisetest/handler"
+	"expertisetest/notify"
+	"expertisetest/server/apierror"
+	"expertisetest/webhook"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/pkg/errors"
-	"github.com/pquerna/ffjson/ffjson"
 	"github.com/sirupsen/logrus"
 )
 
@@ -22,8 +28,14 @@ var Update = func(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Authorize the client.
-	if !authorize(r.Context(), w, "admin") {
-		log.WithField("user", r.Context().Value(config.UserKey)).Debug("authorized")
+	if !authorize(r.Context(), w, auth.PermUpdate) {
+		log.WithField("user", subject(r.Context())).Debug("unauthorized")
+		return
+	}
+
+	tenant, ok := authorizeTenant(r, w)
+	if !ok {
+		log.WithFields(logrus.Fields{"user": subject(r.Context()), "app": r.URL.Query().Get("app")}).Debug("unauthorized")
 		return
 	}
 
@@ -31,19 +43,7 @@ var Update = func(w http.ResponseWriter, r *http.Request) {
 	// Method check
 	if r.Method != http.MethodPost {
 		log.Error("invalid http method on update")
-		writeResponse(w, 400, fmt.Sprintf("invalid method"), nil)
-		return
-	}
-
-	// Validate body
-	if r.Body == nil {
-		log.Error("empty body on update")
-		writeResponse(w, 400, fmt.Sprintf("invalid empty request"), nil)
-		return
-	}
-
-	if r.Header.Get("Content-Type") != "application/json" {
-		writeResponse(w, 400, fmt.Sprintf("invalid content-type"), nil)
+		writeError(w, apierror.MethodNotAllowed(http.MethodPost))
 		return
 	}
 
@@ -57,38 +57,124 @@ var Update = func(w http.ResponseWriter, r *http.Request) {
 
 	// Parse body
 	in := &handler.LoadObject{}
-	b, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Error(errors.Wrap(err, "failed to read body"))
-		writeResponse(w, 500, fmt.Sprintf("internal system error"), nil)
+	if e := readJSON(r, in); e != nil {
+		log.Error(errors.Wrap(e, "failed to parse body on update"))
+		writeError(w, e)
 		return
 	}
 
-	if err = r.Body.Close(); err != nil {
-		log.Error(errors.Wrap(err, "failed to close body"))
-	}
-
-	if err = ffjson.Unmarshal(b, in); err != nil {
-		log.Error(errors.Wrap(err, "failed to unmrashal json"))
-		writeResponse(w, 500, fmt.Sprintf("internal system error"), nil)
+	if e := ValidateLoad(in); e != nil {
+		log.WithField("details", e.Details).Error("invalid body on update")
+		writeError(w, e)
 		return
 	}
 
 	// Parse data and store
-	h := handler.GetInstance()
+	h, err := handler.ForTenant(tenant)
+	if err != nil {
+		log.Error(errors.Wrapf(err, "failed to init handler for %q", tenant))
+		writeError(w, apierror.Internal())
+		return
+	}
 	h.SetLogger(log)
 
+	if _, e := StoreDataset(r.Context(), log, h, in, dropDB); e != nil {
+		writeError(w, e)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &Response{})
+}
+
+// StoreDataset canonicalizes provider names, prefilters and stores the dataset, validated by ValidateLoad,
+// announces it to subscribers and webhooks, and returns the new dataset version.
+// The change is recorded in the audit entry of ctx, if any.
+func StoreDataset(ctx context.Context, log *logrus.Entry, h *handler.Handler, in *handler.LoadObject, dropDB bool) (int64, *apierror.Error) {
+	h.Canonicalize(in.AdNetwork)
 	an := h.Prefilter(in.AdNetwork)
 	m, err := handler.ToCountryMap(an)
 	if err != nil {
-		writeResponse(w, 500, fmt.Sprintf("internal system error"), nil)
-		return
+		log.Error(errors.Wrap(err, "failed to map countries"))
+		return 0, apierror.Internal()
 	}
 
-	if err = h.Store(m, dropDB); err != nil {
-		writeResponse(w, 500, fmt.Sprintf("internal system error"), nil)
-		return
+	// Record dataset change in audit log.
+	entry := audit.FromContext(ctx)
+	if entry != nil {
+		entry.Countries = len(m)
+		if entry.VersionBefore, err = h.Version(); err != nil {
+			log.Error(errors.Wrap(err, "failed to fetch dataset version"))
+		}
+	}
+
+	stored, err := h.Store(m, dropDB)
+	if err != nil {
+		log.Error(errors.Wrap(err, "failed to store dataset"))
+		return 0, apierror.Unavailable()
+	}
+
+	if entry != nil {
+		entry.VersionAfter = stored.Version
+	}
+
+	// The dataset is stored either way, subscribers only miss the announcement.
+	diff := notify.Diff(*stored.Diff)
+	event := &notify.Event{
+		Tenant:    h.Tenant(),
+		Version:   stored.Version,
+		Countries: stored.Countries,
+		Wiped:     stored.Wiped,
+		Diff:      &diff,
+		Time:      time.Now().UTC(),
+	}
+	if err = notify.Publish(event); err != nil {
+		log.Error(err)
+	}
+	if err = webhook.DatasetPublished(event); err != nil {
+		log.Error(err)
+	}
+
+	return stored.Version, nil
+}
+
+// ValidateLoad validates that dataset is not empty and each country is given once,
+// normalizing country codes in place to ISO 3166-1 alpha-2.
+func ValidateLoad(in *handler.LoadObject) *apierror.Error {
+	if len(in.AdNetwork) == 0 {
+		return apierror.ValidationFailed(apierror.FieldError{Field: "data", Reason: "required"})
+	}
+
+	details := []apierror.FieldError{}
+	seen := map[string]bool{}
+	for i, network := range in.AdNetwork {
+		field := fmt.Sprintf("data[%d].country", i)
+		switch {
+		case network == nil:
+			details = append(details, apierror.FieldError{Field: fmt.Sprintf("data[%d]", i), Reason: "required"})
+		case network.Country == "":
+			details = append(details, apierror.FieldError{Field: field, Reason: "required"})
+		case !normalize(&network.Country):
+			details = append(details, apierror.FieldError{Field: field, Reason: "unknown ISO 3166-1 country code"})
+		case seen[network.Country]:
+			details = append(details, apierror.FieldError{Field: field, Reason: "duplicate country"})
+		default:
+			seen[network.Country] = true
+		}
+	}
+
+	if len(details) > 0 {
+		return apierror.ValidationFailed(details...)
+	}
+
+	return nil
+}
+
+// normalizes the country code in place, returns false for unknown codes.
+func normalize(code *string) bool {
+	normalized, ok := country.Normalize(*code)
+	if ok {
+		*code = normalized
 	}
 
-	writeResponse(w, 200, "", nil)
+	return ok
 }
@@ -0,0 +1,248 @@
+package endpoints
+
+import (
+	"context"
+	"expertisetest/audit"
+	"expertisetest/auth"
+	"expertisetest/config"
+	"expertisetest/server/apierror"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// UserRequest is the body accepted when creating a user.
+type UserRequest struct {
+	Username string `json:"username" validate:"required"`
+	Password string `json:"password" validate:"required"`
+	Role     string `json:"role" validate:"required"`
+	// Tenants restricts access to given tenants, empty means all.
+	Tenants []string `json:"tenants"`
+}
+
+// PasswordRequest is the body accepted when rotating a password.
+// Password is optional, a random one is generated and returned if omitted.
+type PasswordRequest struct {
+	Password string `json:"password"`
+}
+
+// UsersResponse is returned by user management endpoints.
+type UsersResponse struct {
+	Users    []*auth.User `json:"users,omitempty"`
+	Password string       `json:"password,omitempty"`
+}
+
+// Users handles /users endpoint functionality, listing and creating users.
+var Users = func(w http.ResponseWriter, r *http.Request) {
+	// Fetch logger from logger middleware.
+	log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	// Authorize the client.
+	if !authorize(r.Context(), w, auth.PermAccounts) {
+		log.WithField("user", subject(r.Context())).Debug("unauthorized")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		users, e := ListUsers(r.Context(), log)
+		if e != nil {
+			writeError(w, e)
+			return
+		}
+
+		writeUsers(w, http.StatusOK, users...)
+	case http.MethodPost:
+		in := &UserRequest{}
+		if e := readJSON(r, in); e != nil {
+			writeError(w, e)
+			return
+		}
+
+		u, e := CreateUser(r.Context(), log, in)
+		if e != nil {
+			writeError(w, e)
+			return
+		}
+
+		writeUsers(w, http.StatusCreated, u)
+	default:
+		log.Error("invalid http method on users")
+		writeError(w, apierror.MethodNotAllowed(http.MethodGet, http.MethodPost))
+	}
+}
+
+// UserAction handles /users/{username}/{action} endpoint functionality.
+// Supported actions are disable, enable and rotate.
+var UserAction = func(w http.ResponseWriter, r *http.Request) {
+	// Fetch logger from logger middleware.
+	log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	// Authorize the client.
+	if !authorize(r.Context(), w, auth.PermAccounts) {
+		log.WithField("user", subject(r.Context())).Debug("unauthorized")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		log.Error("invalid http method on users")
+		writeError(w, apierror.MethodNotAllowed(http.MethodPost))
+		return
+	}
+
+	var in *PasswordRequest
+	if chi.URLParam(r, "action") == "rotate" {
+		in = &PasswordRequest{}
+		_ = readJSON(r, in)
+	}
+
+	u, pass, e := UpdateUser(r.Context(), log, chi.URLParam(r, "username"), chi.URLParam(r, "action"), in)
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+
+	if u != nil {
+		writeUsers(w, http.StatusOK, u)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &UsersResponse{Password: pass})
+}
+
+// ListUsers returns the users the identity in ctx can manage, sorted by username.
+func ListUsers(ctx context.Context, log *logrus.Entry) ([]*auth.User, *apierror.Error) {
+	users, err := auth.ListUsers()
+	if err != nil {
+		log.Error(errors.Wrap(err, "failed to list users"))
+		return nil, apierror.Unavailable()
+	}
+
+	out := []*auth.User{}
+	for _, u := range users {
+		if manages(ctx, u.Tenants) {
+			out = append(out, u)
+		}
+	}
+
+	return out, nil
+}
+
+// CreateUser validates the request and creates the user.
+// Identities restricted to tenants can only create users restricted to their own tenants.
+// The user is recorded in the audit entry of ctx, if any.
+func CreateUser(ctx context.Context, log *logrus.Entry, in *UserRequest) (*auth.User, *apierror.Error) {
+	details := []apierror.FieldError{}
+	if in.Username == "" {
+		details = append(details, apierror.FieldError{Field: "username", Reason: "required"})
+	}
+	if in.Password == "" {
+		details = append(details, apierror.FieldError{Field: "password", Reason: "required"})
+	}
+
+	role, err := auth.ParseRole(in.Role)
+	if err != nil {
+		details = append(details, apierror.FieldError{Field: "role", Reason: "unknown role"})
+	}
+
+	if len(details) > 0 {
+		return nil, apierror.ValidationFailed(details...)
+	}
+
+	if !manages(ctx, in.Tenants) {
+		return nil, apierror.Forbidden("tenants must be a subset of your own")
+	}
+
+	u, err := auth.CreateUser(in.Username, in.Password, role, in.Tenants)
+	if err == auth.ErrInvalidTenant {
+		return nil, apierror.ValidationFailed(apierror.FieldError{Field: "tenants", Reason: "unknown tenant"})
+	}
+	if err == auth.ErrUserExists {
+		return nil, apierror.Conflict("user already exists")
+	}
+	if err != nil {
+		log.Error(errors.Wrap(err, "failed to create user"))
+		return nil, apierror.Internal()
+	}
+
+	if entry := audit.FromContext(ctx); entry != nil {
+		entry.Params["username"], entry.Params["role"] = u.Username, string(u.Role)
+		entry.Params["tenants"] = strings.Join(u.Tenants, ",")
+		entry.Tenants = u.Tenants
+	}
+
+	log.WithFields(logrus.Fields{"user": u.Username, "role": u.Role}).Info("user created")
+	return u, nil
+}
+
+// UpdateUser runs the action (disable, enable or rotate) on the user.
+// Rotating returns the generated password, if in holds none.
+// Users the identity in ctx cannot manage are not found.
+func UpdateUser(ctx context.Context, log *logrus.Entry, username, action string, in *PasswordRequest) (*auth.User, string, *apierror.Error) {
+	log = log.WithFields(logrus.Fields{"user": username, "action": action})
+
+	switch action {
+	case "disable", "enable", "rotate":
+	default:
+		return nil, "", apierror.NotFound("unknown action")
+	}
+
+	// Users of other tenants are reported as missing, not to reveal them.
+	target, err := auth.GetUser(username)
+	if err == nil && !manages(ctx, target.Tenants) {
+		err = auth.ErrUserNotFound
+	}
+	if err == nil {
+		auditTenants(ctx, target.Tenants)
+	}
+
+	var (
+		u    *auth.User
+		pass string
+	)
+
+	switch {
+	case err != nil:
+	case action == "rotate":
+		if in == nil {
+			in = &PasswordRequest{}
+		}
+
+		if pass, err = auth.RotatePassword(username, in.Password); err == nil && in.Password != "" {
+			pass = ""
+		}
+	default:
+		u, err = auth.SetDisabled(username, action == "disable")
+	}
+
+	if err == auth.ErrUserNotFound {
+		return nil, "", apierror.NotFound("user not found")
+	}
+	if err != nil {
+		log.Error(errors.Wrap(err, "failed to update user"))
+		return nil, "", apierror.Internal()
+	}
+
+	log.Info("user updated")
+	return u, pass, nil
+}
+
+// writes users without their password hashes.
+func writeUsers(w http.ResponseWriter, status int, users ...*auth.User) {
+	for _, u := range users {
+		u.PasswordHash = ""
+	}
+
+	writeJSON(w, status, &UsersResponse{Users: users})
+}
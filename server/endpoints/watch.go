@@ -0,0 +1,297 @@
+package endpoints
+
+import (
+	"context"
+	"expertisetest/auth"
+	"expertisetest/config"
+	"expertisetest/handler"
+	"expertisetest/notify"
+	"expertisetest/server/apierror"
+	"expertisetest/server/render"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// eventStream is the media type of server-sent events.
+	eventStream = "text/event-stream"
+	// events a watch can fall behind before events are dropped.
+	watchBuffer = 16
+	// delay before event stream clients reconnect, streams end after the watch timeout.
+	reconnectDelay = time.Second
+)
+
+// WatchList handles /list/watch endpoint functionality.
+// Clients learn about the network of their device context whenever a published dataset affects it,
+// or provider metadata or kill switches change.
+// Clients accepting text/event-stream get the current network and every new one as server-sent events.
+// Other clients long-poll, the network is returned as by /list unless If-None-Match holds the current
+// entity tag, in which case the request waits for a dataset affecting it and answers 304 on timeout.
+var WatchList = func(w http.ResponseWriter, r *http.Request) {
+	// Fetch logger from logger middleware.
+	log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	// Authorize the client.
+	if !authorize(r.Context(), w, auth.PermList) {
+		log.WithField("user", subject(r.Context())).Debug("unauthorized")
+		return
+	}
+
+	tenant, ok := authorizeTenant(r, w)
+	if !ok {
+		log.WithFields(logrus.Fields{"user": subject(r.Context()), "app": r.URL.Query().Get("app")}).Debug("unauthorized")
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		log.Error("invalid method")
+		writeError(w, apierror.MethodNotAllowed(http.MethodGet))
+		return
+	}
+
+	vals := r.URL.Query()
+	detected := detect(log, w, r, vals)
+	if e := ValidateList(vals); e != nil {
+		log.WithField("details", e.Details).Error("invalid arguments")
+		writeError(w, e)
+		return
+	}
+
+	timeout, e := watchTimeout(vals)
+	if e != nil {
+		log.WithField("details", e.Details).Error("invalid arguments")
+		writeError(w, e)
+		return
+	}
+
+	h, err := handler.ForTenant(tenant)
+	if err != nil {
+		log.Error(errors.Wrapf(err, "failed to init handler for %q", tenant))
+		writeError(w, apierror.Internal())
+		return
+	}
+	h.SetLogger(log)
+
+	// Subscribing before resolving the network ensures datasets published meanwhile are not missed.
+	// The subscription is closed on shutdown, ending the watch early.
+	events, cancel := notify.GetInstance().Subscribe(watchBuffer)
+	defer cancel()
+
+	ctx, done := context.WithTimeout(r.Context(), timeout)
+	defer done()
+
+	wt := &watch{log: log, h: h, vals: vals, detected: detected, events: events}
+	if render.Explicit(r.Header.Get("Accept"), eventStream) {
+		wt.stream(ctx, w, r)
+		return
+	}
+
+	wt.poll(ctx, w, r)
+}
+
+// watch follows the network of a device context.
+type watch struct {
+	log  *logrus.Entry
+	h    *handler.Handler
+	vals url.Values
+	// arguments detected from the request, echoed in responses.
+	detected *Response
+	events   <-chan *notify.Event
+	// the last network was not resolved from the stored network of the country.
+	fallback bool
+}
+
+// answers with the network once its entity tag differs from If-None-Match, or with 304 when the watch ends.
+func (wt *watch) poll(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	codec, ok := negotiate(w, r, &Response{})
+	if !ok {
+		wt.log.WithField("accept", r.Header.Get("Accept")).Debug("not acceptable")
+		return
+	}
+	// Answers depend on when they are made, caching them would defeat waiting.
+	w.Header().Set("Cache-Control", "no-store")
+
+	tag, n, e := wt.current(codec.ContentType)
+	for e == nil && notModified(r, tag) {
+		if !wt.wait(ctx) {
+			w.Header().Set("ETag", tag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		tag, n, e = wt.current(codec.ContentType)
+	}
+
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+
+	w.Header().Set("ETag", tag)
+	writeResponse(w, codec, http.StatusOK, wt.response(n))
+}
+
+// sends the network as an event whenever it changes, until the watch ends.
+// The entity tag is the event id, clients reconnecting with it as Last-Event-ID skip the network they have.
+func (wt *watch) stream(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		wt.log.Error("streaming unsupported by response writer")
+		writeError(w, apierror.Internal())
+		return
+	}
+
+	// Failures before the stream starts are answered with their status.
+	tag, n, e := wt.current(eventStream)
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+
+	w.Header().Set("Content-Type", eventStream)
+	w.Header().Set("Cache-Control", "no-store")
+	// Disables response buffering of nginx proxies.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	err := wt.write(w, fmt.Sprintf("retry: %d\n\n", reconnectDelay/time.Millisecond))
+	if err == nil && tag != r.Header.Get("Last-Event-ID") {
+		err = wt.send(w, tag, "network", wt.response(n))
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(config.GetInstance().ListWatchKeepAlive)
+	defer keepAlive.Stop()
+
+	for err == nil {
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepAlive.C:
+			err = wt.write(w, ": keep-alive\n\n")
+		case ev, ok := <-wt.events:
+			if !ok {
+				return
+			}
+			if !wt.affects(ev) {
+				continue
+			}
+
+			if tag, n, e = wt.current(eventStream); e != nil {
+				// Failures are reported, the stream goes on with the next dataset.
+				err = wt.send(w, "", "error", &apierror.Envelope{Err: e})
+			} else {
+				err = wt.send(w, tag, "network", wt.response(n))
+			}
+		}
+		flusher.Flush()
+	}
+
+	wt.log.Debug(errors.Wrap(err, "event stream closed"))
+}
+
+// waits for a dataset affecting the network, false is returned when the watch ends first.
+func (wt *watch) wait(ctx context.Context) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case ev, ok := <-wt.events:
+			if !ok {
+				return false
+			}
+			if wt.affects(ev) {
+				return true
+			}
+		}
+	}
+}
+
+// returns the entity tag and network of the device context, the tag is the one /list answers with.
+func (wt *watch) current(contentType string) (string, *Network, *apierror.Error) {
+	version, err := wt.h.Version()
+	if err != nil {
+		wt.log.Error(errors.Wrap(err, "failed to fetch dataset version"))
+		return "", nil, apierror.Unavailable()
+	}
+
+	o, err := loadOverrides()
+	if err != nil {
+		wt.log.Error(err)
+		return "", nil, apierror.Unavailable()
+	}
+
+	n, e := ListNetwork(wt.log, wt.h, wt.vals)
+	if e != nil {
+		return "", nil, e
+	}
+	wt.fallback = n.fallback
+
+	return etag(wt.h, version, o, wt.vals, contentType), n, nil
+}
+
+// returns true if the event can change the network.
+// Networks of random countries change with any dataset of the tenant, not only with their country.
+func (wt *watch) affects(ev *notify.Event) bool {
+	if wt.fallback {
+		return ev.AffectsTenant(wt.h.Tenant())
+	}
+
+	return ev.Affects(wt.h.Tenant(), wt.vals.Get("countryCode"))
+}
+
+// returns the response holding the network and the detected arguments.
+func (wt *watch) response(n *Network) *Response {
+	out := *wt.detected
+	out.Network = n
+	return &out
+}
+
+// sends v as a server-sent event, events without id keep the last id of the client.
+func (wt *watch) send(w http.ResponseWriter, id, event string, v interface{}) error {
+	data, err := render.JSON.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode event")
+	}
+
+	msg := fmt.Sprintf("event: %s\ndata: %s\n\n", event, data)
+	if id != "" {
+		msg = "id: " + id + "\n" + msg
+	}
+
+	return wt.write(w, msg)
+}
+
+func (wt *watch) write(w http.ResponseWriter, msg string) error {
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return errors.Wrap(err, "failed to write event")
+	}
+
+	return nil
+}
+
+// returns the requested watch timeout, the configured maximum by default.
+func watchTimeout(vals url.Values) (time.Duration, *apierror.Error) {
+	max := config.GetInstance().ListWatchTimeout
+
+	raw, ok := vals["timeout"]
+	if !ok {
+		return max, nil
+	}
+
+	seconds, err := strconv.Atoi(raw[0])
+	if len(raw) > 1 || err != nil || seconds < 1 || time.Duration(seconds)*time.Second > max {
+		return 0, apierror.InvalidArgument("timeout", fmt.Sprintf("must be a number of seconds between 1 and %d", max/time.Second))
+	}
+
+	return time.Duration(seconds) * time.Second, nil
+}
@@ -0,0 +1,127 @@
+// +build integration
+
+package endpoints
+
+import (
+	"expertisetest/notify"
+	"expertisetest/provider"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Exclude integration testing since CI currently doesn't support multi container testing.
+
+// US has no interstitial networks, watches of contexts resolved from random countries wake with any dataset of the tenant.
+const watchQuery = listQuery + "&types=banner,video"
+
+func TestWatchList(t *testing.T) {
+	h, networks := setup(t)
+
+	tests := []struct {
+		name  string
+		query string
+		// published once the watch waits, announced by the event.
+		publish bool
+		event   *notify.Event
+		status  int
+	}{
+		{"no event", watchQuery, false, nil, http.StatusNotModified},
+		{"other country", watchQuery, true, &notify.Event{Tenant: h.Tenant(), Countries: []string{"CN"}}, http.StatusNotModified},
+		{"other tenant", watchQuery, true, &notify.Event{Tenant: "other", Countries: []string{"US"}}, http.StatusNotModified},
+		{"published", watchQuery, true, &notify.Event{Tenant: h.Tenant(), Countries: []string{"US"}}, http.StatusOK},
+		{"providers", watchQuery, true, &notify.Event{Kind: notify.KindProviders}, http.StatusOK},
+		{"other country of random countries", listQuery, true, &notify.Event{Tenant: h.Tenant(), Countries: []string{"CN"}}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		// Without If-None-Match the current network is returned at once.
+		tag := serve(WatchList, get("/list/watch?"+tt.query, "", "")).Header().Get("ETag")
+		if tag == "" {
+			t.Fatalf("%s: Got no ETag", tt.name)
+		}
+
+		done := make(chan struct{})
+		go func(publish bool, ev *notify.Event) {
+			// Waits for the watch to start, it answers at once if the tag it is sent is already stale.
+			time.Sleep(200 * time.Millisecond)
+			if publish {
+				if _, err := h.Store(networks, true); err != nil {
+					t.Error(err)
+				}
+			}
+			if ev != nil {
+				announce(ev, done)
+			}
+		}(tt.publish, tt.event)
+
+		start := time.Now()
+		w := serve(WatchList, get("/list/watch?"+tt.query+"&timeout=1", "", tag))
+		elapsed := time.Since(start)
+		close(done)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: Got status: %d Expected: %d", tt.name, w.Code, tt.status)
+		}
+
+		if got := w.Header().Get("ETag"); (got != tag) != (tt.status == http.StatusOK) {
+			t.Errorf("%s: Got ETag: %q Expected changed from %q: %v", tt.name, got, tag, tt.status == http.StatusOK)
+		}
+
+		// Watches woken by an event answer before the timeout, others wait for it.
+		if woken := elapsed < time.Second; woken != (tt.status == http.StatusOK) {
+			t.Errorf("%s: Got answer after %s Expected woken: %v", tt.name, elapsed, tt.status == http.StatusOK)
+		}
+	}
+}
+
+func TestExpireSwitches(t *testing.T) {
+	setup(t)
+
+	expiresAt := time.Now().Add(300 * time.Millisecond)
+	if _, err := provider.CreateSwitch(&provider.Switch{Provider: "AdMob", ExpiresAt: &expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := notify.NewBroker()
+	events, cancel := b.Subscribe(1)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		ExpireSwitches(b)
+		close(done)
+	}()
+
+	select {
+	case ev := <-events:
+		if ev.Kind != notify.KindProviders || time.Now().Before(expiresAt) {
+			t.Errorf("Got event: %+v Expected a providers event once the switch expired", ev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Got no event Expected a providers event once the switch expired")
+	}
+
+	// The loop ends with the broker.
+	b.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Got the loop running Expected it to end with the broker")
+	}
+}
+
+// broadcasts the event to local subscribers until done is closed, so it reaches watches subscribing meanwhile.
+func announce(ev *notify.Event, done <-chan struct{}) {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			notify.GetInstance().Broadcast(ev)
+		}
+	}
+}
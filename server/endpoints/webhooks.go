@@ -0,0 +1,375 @@
+package endpoints
+
+import (
+	"context"
+	"expertisetest/audit"
+	"expertisetest/auth"
+	"expertisetest/config"
+	"expertisetest/server/apierror"
+	"expertisetest/webhook"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// WebhookRequest is the body accepted when creating a webhook.
+type WebhookRequest struct {
+	URL string `json:"url" validate:"required"`
+	// Events and tenants the webhook receives, all of them when empty.
+	Events  []string `json:"events"`
+	Tenants []string `json:"tenants"`
+}
+
+// WebhooksResponse is returned by webhook management endpoints.
+// Secret signing the payloads is only returned once, when the webhook is created.
+type WebhooksResponse struct {
+	Webhooks []*webhook.Subscription `json:"webhooks,omitempty"`
+	Secret   string                  `json:"secret,omitempty"`
+}
+
+// DeliveriesResponse is returned by /webhooks/{id}/deliveries endpoint.
+type DeliveriesResponse struct {
+	Deliveries []*webhook.Attempt `json:"deliveries"`
+}
+
+// DeadLettersResponse is returned by /webhooks/deadletters endpoint.
+type DeadLettersResponse struct {
+	DeadLetters []*webhook.DeadLetter `json:"deadLetters"`
+}
+
+// Webhooks handles /webhooks endpoint functionality, listing and creating webhooks.
+var Webhooks = func(w http.ResponseWriter, r *http.Request) {
+	// Fetch logger from logger middleware.
+	log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	// Authorize the client.
+	if !authorize(r.Context(), w, auth.PermAccounts) {
+		log.WithField("user", subject(r.Context())).Debug("unauthorized")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		subs, err := webhook.List()
+		if err != nil {
+			log.Error(errors.Wrap(err, "failed to list webhooks"))
+			writeError(w, apierror.Unavailable())
+			return
+		}
+
+		out := []*webhook.Subscription{}
+		for _, s := range subs {
+			if manages(r.Context(), s.Tenants) {
+				out = append(out, s)
+			}
+		}
+
+		writeWebhooks(w, http.StatusOK, "", out...)
+	case http.MethodPost:
+		in := &WebhookRequest{}
+		if e := readJSON(r, in); e != nil {
+			writeError(w, e)
+			return
+		}
+
+		if !manages(r.Context(), in.Tenants) {
+			writeError(w, apierror.Forbidden("tenants must be a subset of your own"))
+			return
+		}
+
+		s, err := webhook.Create(in.URL, in.Events, in.Tenants)
+		switch err {
+		case nil:
+		case webhook.ErrInvalidURL:
+			writeError(w, apierror.ValidationFailed(apierror.FieldError{Field: "url", Reason: "must be an absolute http or https url"}))
+			return
+		case webhook.ErrInvalidEvent:
+			writeError(w, apierror.ValidationFailed(apierror.FieldError{Field: "events", Reason: "unknown event"}))
+			return
+		case webhook.ErrInvalidTenant:
+			writeError(w, apierror.ValidationFailed(apierror.FieldError{Field: "tenants", Reason: "unknown tenant"}))
+			return
+		default:
+			log.Error(errors.Wrap(err, "failed to create webhook"))
+			writeError(w, apierror.Internal())
+			return
+		}
+
+		if entry := audit.FromContext(r.Context()); entry != nil {
+			entry.Params["webhook"], entry.Params["url"] = s.ID, s.URL
+			entry.Params["events"] = strings.Join(in.Events, ",")
+			entry.Params["tenants"] = strings.Join(in.Tenants, ",")
+			entry.Tenants = s.Tenants
+		}
+
+		log.WithFields(logrus.Fields{"webhook": s.ID, "url": s.URL}).Info("webhook created")
+		writeWebhooks(w, http.StatusCreated, s.Secret, s)
+	default:
+		log.Error("invalid http method on webhooks")
+		writeError(w, apierror.MethodNotAllowed(http.MethodGet, http.MethodPost))
+	}
+}
+
+// WebhookAction handles /webhooks/{id}/{action} endpoint functionality.
+// Supported actions are enable, disable and delete, disabled webhooks drop their queued deliveries.
+var WebhookAction = func(w http.ResponseWriter, r *http.Request) {
+	// Fetch logger from logger middleware.
+	log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	// Authorize the client.
+	if !authorize(r.Context(), w, auth.PermAccounts) {
+		log.WithField("user", subject(r.Context())).Debug("unauthorized")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		log.Error("invalid http method on webhooks")
+		writeError(w, apierror.MethodNotAllowed(http.MethodPost))
+		return
+	}
+
+	id, action := chi.URLParam(r, "id"), chi.URLParam(r, "action")
+	log = log.WithFields(logrus.Fields{"webhook": id, "action": action})
+
+	if action != "enable" && action != "disable" && action != "delete" {
+		writeError(w, apierror.NotFound("unknown action"))
+		return
+	}
+
+	s, err := managedWebhook(r.Context(), id)
+	if err == nil {
+		auditTenants(r.Context(), s.Tenants)
+		switch action {
+		case "enable", "disable":
+			s, err = webhook.SetActive(id, action == "enable")
+		case "delete":
+			s, err = webhook.Delete(id)
+		}
+	}
+
+	switch err {
+	case nil:
+	case webhook.ErrNotFound:
+		writeError(w, apierror.NotFound("webhook not found"))
+		return
+	default:
+		log.Error(errors.Wrap(err, "failed to update webhook"))
+		writeError(w, apierror.Internal())
+		return
+	}
+
+	log.Info("webhook updated")
+	writeWebhooks(w, http.StatusOK, "", s)
+}
+
+// WebhookDeliveries handles /webhooks/{id}/deliveries endpoint functionality.
+// The latest delivery attempts are returned newest first, limit restricts their number.
+var WebhookDeliveries = func(w http.ResponseWriter, r *http.Request) {
+	// Fetch logger from logger middleware.
+	log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	// Authorize the client.
+	if !authorize(r.Context(), w, auth.PermRead) {
+		log.WithField("user", subject(r.Context())).Debug("unauthorized")
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		log.Error("invalid http method on webhook deliveries")
+		writeError(w, apierror.MethodNotAllowed(http.MethodGet))
+		return
+	}
+
+	limit, e := webhookLimit(r)
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	_, err := managedWebhook(r.Context(), id)
+	var attempts []*webhook.Attempt
+	if err == nil {
+		attempts, err = webhook.Deliveries(id, limit)
+	}
+
+	switch err {
+	case nil:
+	case webhook.ErrNotFound:
+		writeError(w, apierror.NotFound("webhook not found"))
+		return
+	default:
+		log.Error(errors.Wrap(err, "failed to fetch webhook deliveries"))
+		writeError(w, apierror.Unavailable())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &DeliveriesResponse{Deliveries: attempts})
+}
+
+// DeadLetters handles /webhooks/deadletters endpoint functionality.
+// Deliveries that failed every attempt are returned with their last attempt, latest failures first.
+var DeadLetters = func(w http.ResponseWriter, r *http.Request) {
+	// Fetch logger from logger middleware.
+	log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	// Authorize the client.
+	if !authorize(r.Context(), w, auth.PermRead) {
+		log.WithField("user", subject(r.Context())).Debug("unauthorized")
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		log.Error("invalid http method on dead letters")
+		writeError(w, apierror.MethodNotAllowed(http.MethodGet))
+		return
+	}
+
+	limit, e := webhookLimit(r)
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+
+	// Dead letters are limited after those of other tenants are left out.
+	letters, err := webhook.DeadLetters(0)
+	if err != nil {
+		log.Error(errors.Wrap(err, "failed to fetch dead letters"))
+		writeError(w, apierror.Unavailable())
+		return
+	}
+
+	subs, err := webhook.List()
+	if err != nil {
+		log.Error(errors.Wrap(err, "failed to list webhooks"))
+		writeError(w, apierror.Unavailable())
+		return
+	}
+
+	tenants := map[string][]string{}
+	for _, s := range subs {
+		tenants[s.ID] = s.Tenants
+	}
+
+	out := []*webhook.DeadLetter{}
+	for _, dl := range letters {
+		// Dead letters of deleted webhooks have no tenants, so they are only listed to unrestricted clients.
+		if manages(r.Context(), tenants[dl.Webhook]) {
+			out = append(out, dl)
+		}
+	}
+
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+
+	writeJSON(w, http.StatusOK, &DeadLettersResponse{DeadLetters: out})
+}
+
+// Redeliver handles /webhooks/deadletters/{delivery}/redeliver endpoint functionality.
+// The dead letter is queued again with a fresh set of attempts.
+var Redeliver = func(w http.ResponseWriter, r *http.Request) {
+	// Fetch logger from logger middleware.
+	log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	// Authorize the client.
+	if !authorize(r.Context(), w, auth.PermAccounts) {
+		log.WithField("user", subject(r.Context())).Debug("unauthorized")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		log.Error("invalid http method on dead letters")
+		writeError(w, apierror.MethodNotAllowed(http.MethodPost))
+		return
+	}
+
+	delivery := chi.URLParam(r, "delivery")
+	dl, err := webhook.GetDeadLetter(delivery)
+	if err == nil {
+		var s *webhook.Subscription
+		if s, err = managedWebhook(r.Context(), dl.Webhook); err == nil {
+			auditTenants(r.Context(), s.Tenants)
+			dl, err = webhook.Redeliver(delivery)
+		}
+	}
+
+	switch err {
+	case nil:
+	case webhook.ErrNotFound:
+		writeError(w, apierror.NotFound("dead letter not found"))
+		return
+	default:
+		log.Error(errors.Wrap(err, "failed to redeliver"))
+		writeError(w, apierror.Internal())
+		return
+	}
+
+	if entry := audit.FromContext(r.Context()); entry != nil {
+		entry.Params["webhook"], entry.Params["delivery"] = dl.Webhook, dl.Delivery
+	}
+
+	log.WithFields(logrus.Fields{"webhook": dl.Webhook, "delivery": dl.Delivery}).Info("dead letter queued")
+	writeJSON(w, http.StatusAccepted, &DeadLettersResponse{DeadLetters: []*webhook.DeadLetter{dl}})
+}
+
+// returns the webhook if the identity in ctx manages its tenants, webhooks of other tenants are not found.
+func managedWebhook(ctx context.Context, id string) (*webhook.Subscription, error) {
+	s, err := webhook.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !manages(ctx, s.Tenants) {
+		return nil, webhook.ErrNotFound
+	}
+
+	return s, nil
+}
+
+// returns the limit argument, 0 when missing.
+func webhookLimit(r *http.Request) (int, *apierror.Error) {
+	raw := r.URL.Query().Get("limit")
+	if raw == "" {
+		return 0, nil
+	}
+
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 0 {
+		return 0, apierror.InvalidArgument("limit", "must be a non negative integer")
+	}
+
+	return limit, nil
+}
+
+// writes webhooks without their secrets, secret is the one of a created webhook.
+func writeWebhooks(w http.ResponseWriter, status int, secret string, subs ...*webhook.Subscription) {
+	for _, s := range subs {
+		s.Secret = ""
+	}
+
+	writeJSON(w, status, &WebhooksResponse{Webhooks: subs, Secret: secret})
+}
@@ -0,0 +1,151 @@
+// Package graphql serves the GraphQL api, sharing handlers, authentication and audit log with the http api.
+// Queries and mutations are posted as json, subscriptions are served over websockets.
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"expertisetest/config"
+	"expertisetest/server/apierror"
+	"expertisetest/server/render"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+	gql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
+)
+
+// maximum nesting of selections, the schema is at most four levels deep.
+const maxDepth = 8
+
+var schema = gql.MustParseSchema(schemaString, &resolver{},
+	gql.UseStringDescriptions(),
+	gql.MaxDepth(maxDepth),
+)
+
+// Request is the body accepted by /graphql.
+type Request struct {
+	Query         string                 `json:"query" validate:"required"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Handler handles /graphql endpoint functionality.
+// Queries and mutations are answered with 200 even when resolvers fail, errors are listed in the response.
+var Handler = func(w http.ResponseWriter, r *http.Request) {
+	log := logger(r.Context())
+
+	if websocket.IsWebSocketUpgrade(r) {
+		serveWebsocket(w, r, log)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		log.Error("invalid http method on graphql")
+		apierror.Write(w, apierror.MethodNotAllowed(http.MethodPost))
+		return
+	}
+
+	in := &Request{}
+	if err := json.NewDecoder(r.Body).Decode(in); err != nil {
+		log.Debug(errors.Wrap(err, "failed to parse body on graphql"))
+		apierror.Write(w, apierror.InvalidBody())
+		return
+	}
+
+	out := schema.Exec(withBudget(withRequest(r.Context(), r)), in.Query, in.OperationName, in.Variables)
+	render.Write(w, render.JSON, http.StatusOK, out)
+}
+
+// requestInfo holds details of the http request, resolvers only see the context.
+type requestInfo struct {
+	ip         string
+	clientCert bool
+}
+
+type requestKey struct{}
+
+func withRequest(ctx context.Context, r *http.Request) context.Context {
+	info := &requestInfo{
+		ip:         r.RemoteAddr,
+		clientCert: r.TLS != nil && len(r.TLS.VerifiedChains) > 0,
+	}
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		info.ip = host
+	}
+
+	return context.WithValue(ctx, requestKey{}, info)
+}
+
+func fromRequest(ctx context.Context) *requestInfo {
+	info, ok := ctx.Value(requestKey{}).(*requestInfo)
+	if !ok {
+		return &requestInfo{}
+	}
+
+	return info
+}
+
+// budget is the number of device contexts an operation may still resolve.
+// Every root field takes at least one, so aliased fields cannot exceed the batch size limit either.
+type budget struct {
+	max  int
+	left int64
+}
+
+type budgetKey struct{}
+
+// returns ctx with a fresh budget of BatchMaxSize for an operation.
+func withBudget(ctx context.Context) context.Context {
+	max := config.GetInstance().BatchMaxSize
+	return context.WithValue(ctx, budgetKey{}, &budget{max: max, left: int64(max)})
+}
+
+// takes n from the budget of the operation, root fields resolved concurrently share it.
+func spend(ctx context.Context, n int) *apierror.Error {
+	b, ok := ctx.Value(budgetKey{}).(*budget)
+	if !ok {
+		return nil
+	}
+
+	if n < 1 {
+		n = 1
+	}
+	if atomic.AddInt64(&b.left, -int64(n)) < 0 {
+		return apierror.ValidationFailed(apierror.FieldError{
+			Field:  "query",
+			Reason: fmt.Sprintf("root fields and device contexts of an operation must be at most %d", b.max),
+		})
+	}
+
+	return nil
+}
+
+// resolverError exposes the code and field errors of an api error in graphql error extensions.
+type resolverError struct {
+	e *apierror.Error
+}
+
+// fail returns e as a resolver error.
+func fail(e *apierror.Error) error {
+	return &resolverError{e}
+}
+
+// Error satisfies error interface, only the message is meant for clients.
+func (e *resolverError) Error() string {
+	return e.e.Message
+}
+
+// Extensions satisfies graphql resolver error interface.
+func (e *resolverError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.e.Code}
+	if len(e.e.Details) > 0 {
+		ext["details"] = e.e.Details
+	}
+
+	return ext
+}
@@ -0,0 +1,100 @@
+package graphql
+
+import (
+	"context"
+	"expertisetest/server/apierror"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	tests := []struct {
+		query string
+		valid bool
+	}{
+		{`{ network(context: {countryCode: "US", platform: "android", osVersion: "9", device: "phone"}) { country banner { provider score } } }`, true},
+		{`{ networks(app: "game", contexts: [{countryCode: "US", platform: "ios", osVersion: "13", device: "tablet", types: [video], limit: 2}]) { network { video { provider } } error { code message } } }`, true},
+		{`{ dataset { app version rules } }`, true},
+		{`mutation { update(data: [{country: "US", banner: [{provider: "AdMob", score: 1}]}], wipe: true) { version } }`, true},
+		{`subscription { datasetPublished { app version countries wiped time } }`, true},
+		{`{ network(context: {countryCode: "US"}) { country } }`, false},
+		{`{ network(context: {countryCode: "US", platform: "android", osVersion: "9", device: "phone", types: [popup]}) { country } }`, false},
+		{`{ dataset { unknown } }`, false},
+	}
+
+	for _, tt := range tests {
+		errs := schema.Validate(tt.query)
+		if got := len(errs) == 0; got != tt.valid {
+			t.Errorf("%s: Got valid: %t Expected: %t (%v)", tt.query, got, tt.valid, errs)
+		}
+	}
+}
+
+func TestResolverError(t *testing.T) {
+	err := fail(apierror.ValidationFailed(apierror.FieldError{Field: "limit", Reason: "must be a non negative integer"}))
+
+	ext := err.(*resolverError).Extensions()
+	if ext["code"] != apierror.CodeValidationFailed {
+		t.Errorf("Got code: %v Expected: %v", ext["code"], apierror.CodeValidationFailed)
+	}
+
+	if _, ok := ext["details"]; !ok {
+		t.Errorf("expected details in extensions, got %v", ext)
+	}
+
+	if ext := fail(apierror.Unavailable()).(*resolverError).Extensions(); len(ext) != 1 {
+		t.Errorf("expected only the code in extensions, got %v", ext)
+	}
+}
+
+func TestSpend(t *testing.T) {
+	if e := spend(context.Background(), 100); e != nil {
+		t.Errorf("expected no limit without a budget, got %v", e)
+	}
+
+	ctx := context.WithValue(context.Background(), budgetKey{}, &budget{max: 3, left: 3})
+	// Aliased root fields spend from the same budget, fields without contexts count once.
+	for i, n := range []int{2, 0} {
+		if e := spend(ctx, n); e != nil {
+			t.Errorf("%d: expected %d to be within budget, got %v", i, n, e)
+		}
+	}
+
+	if e := spend(ctx, 1); e == nil || e.Code != apierror.CodeValidationFailed {
+		t.Errorf("Got: %v Expected: %s", e, apierror.CodeValidationFailed)
+	}
+}
+
+func TestDeviceContextValues(t *testing.T) {
+	zero, two := int32(0), int32(2)
+	types := []string{"video", "banner"}
+
+	tests := []struct {
+		dc       *deviceContext
+		expected map[string]string
+	}{
+		{&deviceContext{CountryCode: "US", Platform: "ios"}, map[string]string{"countryCode": "US", "platform": "ios", "limit": ""}},
+		{&deviceContext{CountryCode: "US", Limit: &zero}, map[string]string{"limit": "0"}},
+		{&deviceContext{CountryCode: "US", Limit: &two, Types: &types}, map[string]string{"limit": "2", "types": "video,banner"}},
+	}
+
+	for _, tt := range tests {
+		vals := tt.dc.values()
+		for k, v := range tt.expected {
+			if got := vals.Get(k); got != v {
+				t.Errorf("%s: Got: %q Expected: %q", k, got, v)
+			}
+		}
+	}
+}
+
+func TestPayloadString(t *testing.T) {
+	payload := map[string]interface{}{"Authorization": "Bearer token", "x-api-key": 42}
+
+	if got := payloadString(payload, "authorization"); got != "Bearer token" {
+		t.Errorf("Got: %q Expected: %q", got, "Bearer token")
+	}
+
+	if got := payloadString(payload, "X-API-Key"); got != "" {
+		t.Errorf("Got: %q Expected empty for non string values", got)
+	}
+}
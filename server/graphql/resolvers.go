@@ -0,0 +1,481 @@
+package graphql
+
+import (
+	"context"
+	"expertisetest/adnetwork"
+	"expertisetest/audit"
+	"expertisetest/auth"
+	"expertisetest/config"
+	"expertisetest/handler"
+	"expertisetest/notify"
+	"expertisetest/server/apierror"
+	"expertisetest/server/endpoints"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	gql "github.com/graph-gophers/graphql-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// events a subscriber can fall behind before events are dropped.
+const subscriptionBuffer = 16
+
+// resolver is the root resolver of the schema.
+type resolver struct{}
+
+type deviceContext struct {
+	CountryCode string
+	Platform    string
+	OsVersion   string
+	Device      string
+	Types       *[]string
+	Limit       *int32
+}
+
+func (dc *deviceContext) toDeviceContext() *endpoints.DeviceContext {
+	out := &endpoints.DeviceContext{
+		CountryCode: dc.CountryCode,
+		Platform:    dc.Platform,
+		OsVersion:   dc.OsVersion,
+		Device:      dc.Device,
+	}
+
+	if dc.Types != nil {
+		out.Types = *dc.Types
+	}
+
+	if dc.Limit != nil {
+		out.Limit = int(*dc.Limit)
+	}
+
+	return out
+}
+
+// returns /list url arguments, an explicit zero limit is kept so it fails validation as on /list.
+func (dc *deviceContext) values() url.Values {
+	vals := dc.toDeviceContext().Values()
+	if dc.Limit != nil {
+		vals.Set("limit", strconv.Itoa(int(*dc.Limit)))
+	}
+
+	return vals
+}
+
+func (r *resolver) Network(ctx context.Context, args struct {
+	App     *string
+	Context *deviceContext
+}) (*networkResolver, error) {
+	log := logger(ctx)
+
+	if e := spend(ctx, 1); e != nil {
+		return nil, fail(e)
+	}
+
+	h, e := endpoints.TenantHandler(ctx, log, auth.PermList, apps(args.App))
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	vals := args.Context.values()
+	if e = endpoints.ValidateList(vals); e != nil {
+		return nil, fail(e)
+	}
+
+	n, e := endpoints.ListNetwork(log, h, vals)
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	return &networkResolver{n}, nil
+}
+
+func (r *resolver) Networks(ctx context.Context, args struct {
+	App      *string
+	Contexts []*deviceContext
+}) ([]*networkResultResolver, error) {
+	log := logger(ctx)
+
+	if e := spend(ctx, len(args.Contexts)); e != nil {
+		return nil, fail(e)
+	}
+
+	h, e := endpoints.TenantHandler(ctx, log, auth.PermList, apps(args.App))
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	batch := &endpoints.BatchRequest{}
+	for _, dc := range args.Contexts {
+		batch.Contexts = append(batch.Contexts, dc.toDeviceContext())
+	}
+
+	if e = endpoints.ValidateBatch(batch); e != nil {
+		return nil, fail(e)
+	}
+
+	resolved, e := endpoints.ResolveBatch(log, h, batch)
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	out := make([]*networkResultResolver, 0, len(resolved.Results))
+	for _, result := range resolved.Results {
+		out = append(out, &networkResultResolver{result})
+	}
+
+	return out, nil
+}
+
+func (r *resolver) Dataset(ctx context.Context, args struct{ App *string }) (*datasetResolver, error) {
+	log := logger(ctx)
+
+	if e := spend(ctx, 1); e != nil {
+		return nil, fail(e)
+	}
+
+	h, e := endpoints.TenantHandler(ctx, log, auth.PermList, apps(args.App))
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	version, err := h.Version()
+	if err != nil {
+		log.Error(err)
+		return nil, fail(apierror.Unavailable())
+	}
+
+	return &datasetResolver{h: h, version: version}, nil
+}
+
+type networkInput struct {
+	Country      string
+	Banner       *[]*sdkInput
+	Interstitial *[]*sdkInput
+	Video        *[]*sdkInput
+}
+
+type sdkInput struct {
+	Provider string
+	Score    float64
+}
+
+// Update is recorded in the audit log as an /update call.
+func (r *resolver) Update(ctx context.Context, args struct {
+	App  *string
+	Data []*networkInput
+	Wipe *bool
+}) (*datasetResolver, error) {
+	log := logger(ctx)
+	wipe := args.Wipe != nil && *args.Wipe
+
+	entry := &audit.Entry{
+		Time:      time.Now().UTC(),
+		RequestID: middleware.GetReqID(ctx),
+		Actor:     "anonymous",
+		IP:        fromRequest(ctx).ip,
+		Action:    "update",
+		Params:    map[string]string{},
+	}
+	if id, ok := ctx.Value(config.IdentityKey).(*auth.Identity); ok {
+		entry.Actor, entry.Method = id.Subject, id.Method
+	}
+	if args.App != nil {
+		entry.Params["app"] = *args.App
+	}
+	if wipe {
+		entry.Params["wipe"] = strconv.FormatBool(wipe)
+	}
+
+	out, e := r.update(context.WithValue(ctx, config.AuditKey, entry), log, args.App, args.Data, wipe)
+
+	entry.Status = http.StatusOK
+	if e != nil {
+		entry.Status = e.Status
+	}
+	audit.Record(entry)
+
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	return out, nil
+}
+
+func (r *resolver) update(ctx context.Context, log *logrus.Entry, app *string, data []*networkInput, wipe bool) (*datasetResolver, *apierror.Error) {
+	if e := spend(ctx, 1); e != nil {
+		return nil, e
+	}
+
+	if config.GetInstance().TLSRequireAdminCert && !fromRequest(ctx).clientCert {
+		log.Warn("missing client certificate")
+		return nil, apierror.Forbidden("client certificate required")
+	}
+
+	h, e := endpoints.TenantHandler(ctx, log, auth.PermUpdate, apps(app))
+	if e != nil {
+		return nil, e
+	}
+
+	load := &handler.LoadObject{}
+	for _, n := range data {
+		load.AdNetwork = append(load.AdNetwork, &adnetwork.AdNetwork{
+			Country:      n.Country,
+			Banner:       toSDKs(n.Banner),
+			Interstitial: toSDKs(n.Interstitial),
+			Video:        toSDKs(n.Video),
+		})
+	}
+
+	if e = endpoints.ValidateLoad(load); e != nil {
+		log.WithField("details", e.Details).Error("invalid body on update")
+		return nil, e
+	}
+
+	version, e := endpoints.StoreDataset(ctx, log, h, load, wipe)
+	if e != nil {
+		return nil, e
+	}
+
+	return &datasetResolver{h: h, version: version}, nil
+}
+
+// DatasetPublished streams announcements for the tenant until the subscription is stopped.
+func (r *resolver) DatasetPublished(ctx context.Context, args struct{ App *string }) (<-chan *publishedResolver, error) {
+	log := logger(ctx)
+
+	h, e := endpoints.TenantHandler(ctx, log, auth.PermList, apps(args.App))
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	events, cancel := notify.GetInstance().Subscribe(subscriptionBuffer)
+	out := make(chan *publishedResolver)
+
+	go func() {
+		defer close(out)
+		defer cancel()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				if !ev.AffectsTenant(h.Tenant()) {
+					continue
+				}
+
+				// Provider changes are announced with the current dataset of the tenant.
+				if ev.Kind == notify.KindProviders {
+					version, err := h.Version()
+					if err != nil {
+						log.Error(errors.Wrap(err, "failed to fetch dataset version"))
+					}
+					ev = &notify.Event{Kind: ev.Kind, Tenant: h.Tenant(), Version: version, Countries: []string{}, Time: ev.Time}
+				}
+
+				select {
+				case out <- &publishedResolver{ev}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+type networkResolver struct {
+	n *endpoints.Network
+}
+
+func (r *networkResolver) Country() string {
+	return r.n.Country
+}
+
+func (r *networkResolver) Banner() *[]*sdkResolver {
+	return r.list(adnetwork.Banner)
+}
+
+func (r *networkResolver) Interstitial() *[]*sdkResolver {
+	return r.list(adnetwork.Interstitial)
+}
+
+func (r *networkResolver) Video() *[]*sdkResolver {
+	return r.list(adnetwork.Video)
+}
+
+// returns nil for ad types that were not requested.
+func (r *networkResolver) list(adType string) *[]*sdkResolver {
+	requested := false
+	for _, t := range r.n.Types() {
+		requested = requested || t == adType
+	}
+	if !requested {
+		return nil
+	}
+
+	out := []*sdkResolver{}
+	for _, sdk := range r.n.List(adType) {
+		out = append(out, &sdkResolver{sdk})
+	}
+
+	return &out
+}
+
+type sdkResolver struct {
+	sdk *adnetwork.SDK
+}
+
+func (r *sdkResolver) Provider() string {
+	return r.sdk.Provider
+}
+
+func (r *sdkResolver) Score() float64 {
+	return r.sdk.Score
+}
+
+func (r *sdkResolver) Settings() *settingsResolver {
+	if r.sdk.Settings == nil {
+		return nil
+	}
+
+	return &settingsResolver{r.sdk.Settings}
+}
+
+type settingsResolver struct {
+	s *adnetwork.Settings
+}
+
+func (r *settingsResolver) PlacementID() string {
+	return r.s.PlacementID
+}
+
+func (r *settingsResolver) AdUnitID() string {
+	return r.s.AdUnitID
+}
+
+func (r *settingsResolver) AdapterVersion() string {
+	return r.s.AdapterVersion
+}
+
+func (r *settingsResolver) TimeoutMs() int32 {
+	return int32(r.s.TimeoutMs)
+}
+
+type networkResultResolver struct {
+	res *endpoints.Response
+}
+
+func (r *networkResultResolver) Network() *networkResolver {
+	if r.res.Network == nil {
+		return nil
+	}
+
+	return &networkResolver{r.res.Network}
+}
+
+func (r *networkResultResolver) Error() *errorResolver {
+	if r.res.Err == nil {
+		return nil
+	}
+
+	return &errorResolver{r.res.Err}
+}
+
+type errorResolver struct {
+	e *apierror.Error
+}
+
+func (r *errorResolver) Code() string {
+	return string(r.e.Code)
+}
+
+func (r *errorResolver) Message() string {
+	return r.e.Message
+}
+
+type datasetResolver struct {
+	h       *handler.Handler
+	version int64
+}
+
+func (r *datasetResolver) App() string {
+	return r.h.Tenant()
+}
+
+func (r *datasetResolver) Version() int32 {
+	return int32(r.version)
+}
+
+func (r *datasetResolver) Rules() string {
+	return r.h.RulesVersion()
+}
+
+type publishedResolver struct {
+	e *notify.Event
+}
+
+func (r *publishedResolver) App() string {
+	return r.e.Tenant
+}
+
+func (r *publishedResolver) Version() int32 {
+	return int32(r.e.Version)
+}
+
+func (r *publishedResolver) Countries() []string {
+	return r.e.Countries
+}
+
+func (r *publishedResolver) Wiped() bool {
+	return r.e.Wiped
+}
+
+func (r *publishedResolver) Providers() bool {
+	return r.e.Kind == notify.KindProviders
+}
+
+func (r *publishedResolver) Time() gql.Time {
+	return gql.Time{Time: r.e.Time}
+}
+
+func toSDKs(in *[]*sdkInput) []*adnetwork.SDK {
+	out := []*adnetwork.SDK{}
+	if in == nil {
+		return out
+	}
+
+	for _, sdk := range *in {
+		out = append(out, &adnetwork.SDK{Provider: sdk.Provider, Score: sdk.Score})
+	}
+
+	return out
+}
+
+// returns app values as given by the "app" url argument, a missing app selects the default tenant.
+func apps(app *string) []string {
+	if app == nil {
+		return nil
+	}
+
+	return []string{*app}
+}
+
+// returns the logger set by the logger middleware.
+func logger(ctx context.Context) *logrus.Entry {
+	log, ok := ctx.Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	return log
+}
@@ -0,0 +1,117 @@
+package graphql
+
+// schemaString mirrors /list, /list/batch and /update, and announces published datasets.
+const schemaString = `
+schema {
+	query: Query
+	mutation: Mutation
+	subscription: Subscription
+}
+
+type Query {
+	"Ad networks ordered by score for the device context, as /list."
+	network(app: String, context: DeviceContext!): Network!
+	"Ad networks for many device contexts, one result per context in request order, as /list/batch."
+	networks(app: String, contexts: [DeviceContext!]!): [NetworkResult!]!
+	"Current dataset and rules of the tenant."
+	dataset(app: String): Dataset!
+}
+
+type Mutation {
+	"Store a new dataset, as /update. Countries that are not listed are kept unless wipe is true."
+	update(app: String, data: [AdNetworkInput!]!, wipe: Boolean): Dataset!
+}
+
+type Subscription {
+	"Announces every dataset published for the tenant, by any instance, and changes of provider metadata and kill switches."
+	datasetPublished(app: String): DatasetPublished!
+}
+
+enum AdType {
+	banner
+	interstitial
+	video
+}
+
+input DeviceContext {
+	"ISO-3166-1 alpha-2 country code."
+	countryCode: String!
+	"Lowercase name of the device operating system."
+	platform: String!
+	"Numeric version of the operating system."
+	osVersion: String!
+	"Type of the device (phone, tablet, tv, ...)."
+	device: String!
+	"Ad types to return, all types by default."
+	types: [AdType!]
+	"Maximum number of providers per list, highest score first."
+	limit: Int
+}
+
+type SDK {
+	provider: String!
+	score: Float!
+	"Settings joined from provider metadata, null unless enabled."
+	settings: Settings
+}
+
+type Settings {
+	placementId: String!
+	adUnitId: String!
+	adapterVersion: String!
+	timeoutMs: Int!
+}
+
+"Lists of ad types that were not requested are null."
+type Network {
+	country: String!
+	banner: [SDK!]
+	interstitial: [SDK!]
+	video: [SDK!]
+}
+
+type Error {
+	code: String!
+	message: String!
+}
+
+"Either the network or the error of a device context."
+type NetworkResult {
+	network: Network
+	error: Error
+}
+
+type Dataset {
+	app: String!
+	"Incremented on every stored dataset, zero if none was stored yet."
+	version: Int!
+	"Identifies the loaded pre- and postfilter rules."
+	rules: String!
+}
+
+type DatasetPublished {
+	app: String!
+	version: Int!
+	"Countries stored by the publish."
+	countries: [String!]!
+	"Countries that are not listed were removed."
+	wiped: Boolean!
+	"Provider metadata or kill switches changed, affecting every country of the current dataset version. No countries are listed."
+	providers: Boolean!
+	time: Time!
+}
+
+input SDKInput {
+	provider: String!
+	score: Float!
+}
+
+input AdNetworkInput {
+	country: String!
+	banner: [SDKInput!]
+	interstitial: [SDKInput!]
+	video: [SDKInput!]
+}
+
+scalar Time
+`
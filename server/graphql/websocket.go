@@ -0,0 +1,287 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"expertisetest/auth"
+	"expertisetest/config"
+	"expertisetest/server/middlewares"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Message types of the graphql-ws protocol,
+// see https://github.com/apollographql/subscriptions-transport-ws/blob/master/PROTOCOL.md
+const (
+	msgConnectionInit      = "connection_init"
+	msgConnectionAck       = "connection_ack"
+	msgConnectionError     = "connection_error"
+	msgConnectionTerminate = "connection_terminate"
+	msgKeepAlive           = "ka"
+	msgStart               = "start"
+	msgStop                = "stop"
+	msgData                = "data"
+	msgError               = "error"
+	msgComplete            = "complete"
+)
+
+const (
+	subprotocol = "graphql-ws"
+	// time given to clients to send connection_init after connecting.
+	initTimeout = 10 * time.Second
+	// interval of keep alive messages, so idle connections are not dropped by proxies.
+	keepAliveInterval = 15 * time.Second
+	writeTimeout      = 10 * time.Second
+	maxMessageSize    = 64 << 10
+	// maximum number of operations running at once on a connection.
+	maxOperations = 16
+)
+
+var upgrader = websocket.Upgrader{
+	Subprotocols: []string{subprotocol},
+	// Clients authenticate with headers or the init payload, never with cookies, so any origin is allowed,
+	// as it is by CORS. Basic credentials browsers attach on their own are dropped in serveWebsocket.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// message is a message received from the client.
+type message struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// reply is a message sent to the client.
+type reply struct {
+	ID      string      `json:"id,omitempty"`
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+// connection runs operations started by a client of a websocket.
+type connection struct {
+	conn *websocket.Conn
+	log  *logrus.Entry
+
+	writeMu sync.Mutex
+
+	mu         sync.Mutex
+	operations map[string]context.CancelFunc
+}
+
+// serves the graphql-ws protocol on the upgraded connection, until either side closes it.
+func serveWebsocket(w http.ResponseWriter, r *http.Request, log *logrus.Entry) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrader already answered with an http error.
+		log.Debug(errors.Wrap(err, "failed to upgrade to websocket"))
+		return
+	}
+	defer conn.Close()
+
+	c := &connection{conn: conn, log: log, operations: map[string]context.CancelFunc{}}
+	if conn.Subprotocol() != subprotocol {
+		c.close(websocket.CloseProtocolError, "graphql-ws subprotocol required")
+		return
+	}
+	conn.SetReadLimit(maxMessageSize)
+
+	ctx, cancel := context.WithCancel(withRequest(r.Context(), r))
+	defer cancel()
+
+	// Browsers send cached basic credentials with handshakes started by any page,
+	// so browser clients have to authenticate with the init payload instead.
+	if id, ok := ctx.Value(config.IdentityKey).(*auth.Identity); ok && id.Method == auth.MethodBasic && r.Header.Get("Origin") != "" {
+		ctx = context.WithValue(ctx, config.IdentityKey, nil)
+	}
+
+	ctx, ok := c.init(ctx)
+	if !ok {
+		return
+	}
+
+	go c.keepAlive(ctx)
+
+	log.Debug("websocket connected")
+	c.read(ctx)
+	log.Debug("websocket disconnected")
+}
+
+// waits for connection_init and authenticates the client from its payload, unless it already did with headers.
+// The payload holds the same credentials as headers: {"authorization": "Bearer ..."} or {"x-api-key": "..."}.
+func (c *connection) init(ctx context.Context) (context.Context, bool) {
+	if err := c.conn.SetReadDeadline(time.Now().Add(initTimeout)); err != nil {
+		c.log.Debug(errors.Wrap(err, "failed to set read deadline"))
+		return ctx, false
+	}
+
+	msg := &message{}
+	if err := c.conn.ReadJSON(msg); err != nil || msg.Type != msgConnectionInit {
+		c.write(&reply{Type: msgConnectionError, Payload: errorPayload("connection_init expected")})
+		c.close(websocket.ClosePolicyViolation, "connection_init expected")
+		return ctx, false
+	}
+
+	if err := c.conn.SetReadDeadline(time.Time{}); err != nil {
+		c.log.Debug(errors.Wrap(err, "failed to clear read deadline"))
+		return ctx, false
+	}
+
+	if ctx.Value(config.IdentityKey) == nil {
+		payload := map[string]interface{}{}
+		_ = json.Unmarshal(msg.Payload, &payload)
+
+		id := middlewares.Identify(c.log, payloadString(payload, "authorization"), payloadString(payload, middlewares.APIKeyHeader))
+		if id == nil {
+			c.write(&reply{Type: msgConnectionError, Payload: errorPayload("invalid or missing credentials")})
+			c.close(websocket.ClosePolicyViolation, "unauthenticated")
+			return ctx, false
+		}
+
+		ctx = context.WithValue(ctx, config.IdentityKey, id)
+	}
+
+	c.write(&reply{Type: msgConnectionAck})
+	return ctx, true
+}
+
+// reads messages until the client disconnects or terminates the connection.
+func (c *connection) read(ctx context.Context) {
+	for {
+		msg := &message{}
+		if err := c.conn.ReadJSON(msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				c.log.Debug(errors.Wrap(err, "failed to read websocket message"))
+			}
+			return
+		}
+
+		switch msg.Type {
+		case msgStart:
+			c.start(ctx, msg)
+		case msgStop:
+			// Resolvers of stopped operations stop as soon as possible.
+			c.remove(msg.ID)
+		case msgConnectionTerminate:
+			c.close(websocket.CloseNormalClosure, "")
+			return
+		default:
+			c.write(&reply{ID: msg.ID, Type: msgError, Payload: errorPayload("unknown message type")})
+		}
+	}
+}
+
+// starts the operation, subscriptions stream data until they end or are stopped,
+// queries and mutations send a single data message.
+func (c *connection) start(ctx context.Context, msg *message) {
+	in := &Request{}
+	if err := json.Unmarshal(msg.Payload, in); err != nil || msg.ID == "" || in.Query == "" {
+		c.write(&reply{ID: msg.ID, Type: msgError, Payload: errorPayload("id and query are required")})
+		return
+	}
+
+	c.mu.Lock()
+	if _, ok := c.operations[msg.ID]; ok || len(c.operations) >= maxOperations {
+		c.mu.Unlock()
+		c.write(&reply{ID: msg.ID, Type: msgError, Payload: errorPayload("operation id in use or too many operations")})
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	c.operations[msg.ID] = cancel
+	c.mu.Unlock()
+
+	responses, err := schema.Subscribe(withBudget(ctx), in.Query, in.OperationName, in.Variables)
+	if err != nil {
+		c.remove(msg.ID)
+		c.write(&reply{ID: msg.ID, Type: msgError, Payload: errorPayload("operation not supported")})
+		return
+	}
+
+	go func() {
+		for resp := range responses {
+			c.write(&reply{ID: msg.ID, Type: msgData, Payload: resp})
+		}
+
+		// Operations stopped by the client are not completed.
+		if c.remove(msg.ID) {
+			c.write(&reply{ID: msg.ID, Type: msgComplete})
+		}
+	}()
+}
+
+// removes and cancels the operation, and returns true if it was still running.
+func (c *connection) remove(id string) bool {
+	c.mu.Lock()
+	cancel, ok := c.operations[id]
+	delete(c.operations, id)
+	c.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+
+	return ok
+}
+
+func (c *connection) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		c.write(&reply{Type: msgKeepAlive})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// writes the message, failures mean the connection is gone and end the read loop.
+func (c *connection) write(r *reply) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if err := c.conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		c.log.Debug(errors.Wrap(err, "failed to set write deadline"))
+		return
+	}
+
+	if err := c.conn.WriteJSON(r); err != nil {
+		c.log.Debug(errors.Wrap(err, "failed to write websocket message"))
+	}
+}
+
+func (c *connection) close(code int, text string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	msg := websocket.FormatCloseMessage(code, text)
+	if err := c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeTimeout)); err != nil {
+		c.log.Debug(errors.Wrap(err, "failed to close websocket"))
+	}
+}
+
+// returns the payload of protocol errors.
+func errorPayload(message string) map[string]string {
+	return map[string]string{"message": message}
+}
+
+// returns the string value of key, compared case insensitively as header names.
+func payloadString(payload map[string]interface{}, key string) string {
+	for k, v := range payload {
+		if s, ok := v.(string); ok && strings.EqualFold(k, key) {
+			return s
+		}
+	}
+
+	return ""
+}
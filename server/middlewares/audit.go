@@ -0,0 +1,67 @@
+package middlewares
+
+import (
+	"context"
+	"expertisetest/audit"
+	"expertisetest/auth"
+	"expertisetest/config"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// AuditMiddleware records every state changing request to the route in the audit log.
+// The entry is sent down the context, so endpoints can add details about the change.
+func AuditMiddleware(action string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			e := &audit.Entry{
+				Time:      time.Now().UTC(),
+				RequestID: middleware.GetReqID(r.Context()),
+				Actor:     "anonymous",
+				IP:        clientIP(r),
+				Action:    action,
+				Params:    map[string]string{},
+			}
+
+			if id, ok := r.Context().Value(config.IdentityKey).(*auth.Identity); ok {
+				e.Actor, e.Method = id.Subject, id.Method
+			}
+
+			// Only url arguments are recorded, bodies may contain secrets.
+			for key, values := range r.URL.Query() {
+				if len(values) > 0 {
+					e.Params[key] = values[0]
+				}
+			}
+
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				for i, key := range rctx.URLParams.Keys {
+					if key == "action" {
+						e.Action += "." + rctx.URLParams.Values[i]
+						continue
+					}
+					e.Params[key] = rctx.URLParams.Values[i]
+				}
+			}
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			r = r.WithContext(context.WithValue(r.Context(), config.AuditKey, e))
+
+			h.ServeHTTP(ww, r)
+
+			if e.Status = ww.Status(); e.Status == 0 {
+				e.Status = http.StatusOK
+			}
+
+			audit.Record(e)
+		})
+	}
+}
@@ -2,25 +2,72 @@ package middlewares
 
 import (
 	"context"
+	"expertisetest/auth"
 	"expertisetest/config"
 	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
 )
 
-// AuthenticationMiddleware sends user/password to be validated down the context.
+// APIKeyHeader carries API keys of client applications.
+const APIKeyHeader = "X-API-Key"
+
+// AuthenticationMiddleware authenticates the client and sends its identity down the context.
 func AuthenticationMiddleware(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Simply check if BasicAuth exists and is okay.
-		// Auth and authorization happens on endpoint level.
-		// In case of fail return 401.
-		user, pass, ok := r.BasicAuth()
-
+		// Only resolve the identity, authorization happens on endpoint level,
+		// so unauthenticated requests are still passed on (/login requires none).
+		log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
 		if !ok {
-			w.WriteHeader(401)
+			log = logrus.NewEntry(logrus.StandardLogger())
 		}
 
-		r = r.WithContext(context.WithValue(r.Context(), config.UserKey, user))
-		r = r.WithContext(context.WithValue(r.Context(), config.PassKey, pass))
+		id := Identify(log, r.Header.Get("Authorization"), r.Header.Get(APIKeyHeader))
+		if id != nil {
+			r = r.WithContext(context.WithValue(r.Context(), config.IdentityKey, id))
+		}
 
 		h.ServeHTTP(w, r)
 	})
 }
+
+// Identify resolves the identity of an API key or an authorization header (bearer token or basic auth).
+// Nil is returned if no credentials are given or they are invalid.
+func Identify(log *logrus.Entry, authorization, apiKey string) *auth.Identity {
+	var (
+		id  *auth.Identity
+		err error
+	)
+
+	switch {
+	case apiKey != "":
+		if id, err = auth.VerifyAPIKey(apiKey); err != nil {
+			log.WithField("method", auth.MethodAPIKey).Debug(err)
+		}
+	case strings.HasPrefix(authorization, "Bearer "):
+		if id, err = auth.Verify(strings.TrimPrefix(authorization, "Bearer ")); err != nil {
+			log.WithField("method", auth.MethodToken).Debug(err)
+		}
+	case config.GetInstance().AuthBasicEnabled:
+		user, pass, ok := parseBasicAuth(authorization)
+		if !ok {
+			break
+		}
+
+		if id, err = auth.Authenticate(user, pass); err != nil {
+			log.WithField("method", auth.MethodBasic).Debug(err)
+			break
+		}
+		id.Method = auth.MethodBasic
+	}
+
+	return id
+}
+
+// parses "Basic base64(user:pass)" authorization header, as net/http does for requests.
+// Headers of other transports, e.g. gRPC metadata, are parsed the same way.
+func parseBasicAuth(authorization string) (string, string, bool) {
+	r := &http.Request{Header: http.Header{"Authorization": {authorization}}}
+	return r.BasicAuth()
+}
@@ -0,0 +1,28 @@
+package middlewares
+
+import (
+	"expertisetest/config"
+	"expertisetest/server/apierror"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ClientCertMiddleware rejects requests that were not made with a verified client certificate.
+// Certificate verification itself happens during TLS handshake.
+func ClientCertMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+			if !ok {
+				log = logrus.NewEntry(logrus.StandardLogger())
+			}
+
+			log.Warn("missing client certificate")
+			apierror.Write(w, apierror.Forbidden("client certificate required"))
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
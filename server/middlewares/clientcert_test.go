@@ -0,0 +1,35 @@
+package middlewares
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientCertMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		state  *tls.ConnectionState
+		status int
+	}{
+		{"plain http", nil, http.StatusForbidden},
+		{"no certificate", &tls.ConnectionState{}, http.StatusForbidden},
+		{"unverified certificate", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}, http.StatusForbidden},
+		{"verified certificate", &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}, http.StatusOK},
+	}
+
+	h := ClientCertMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/update", nil)
+		r.TLS = tt.state
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: Got status: %d Expected: %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
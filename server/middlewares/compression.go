@@ -0,0 +1,124 @@
+package middlewares
+
+import (
+	"bufio"
+	"compress/gzip"
+	"expertisetest/server/render"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+
+	"github.com/andybalholm/brotli"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// compressible lists content types worth compressing.
+var compressible = map[string]bool{
+	"application/json":       true,
+	"application/x-ndjson":   true,
+	"application/msgpack":    true,
+	"application/x-protobuf": true,
+}
+
+// encoders in order of preference when clients accept several equally.
+var encoders = []string{"br", "gzip"}
+
+// CompressionMiddleware compresses responses with brotli or gzip, as negotiated by Accept-Encoding.
+func CompressionMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := render.Preferred(r.Header.Get("Accept-Encoding"), encoders)
+		if encoding == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer func() {
+			if err := cw.Close(); err != nil {
+				logrus.Error(errors.Wrap(err, "failed to close compressor"))
+			}
+		}()
+
+		h.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter compresses the body if its content type is compressible.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	encoder     io.WriteCloser
+	wroteHeader bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	contentType, _, _ := mime.ParseMediaType(cw.Header().Get("Content-Type"))
+	hasBody := status != http.StatusNoContent && status != http.StatusNotModified && status >= http.StatusOK
+	if hasBody && compressible[contentType] && cw.Header().Get("Content-Encoding") == "" {
+		cw.Header().Set("Content-Encoding", cw.encoding)
+		// Length of the compressed body is unknown.
+		cw.Header().Del("Content-Length")
+
+		switch cw.encoding {
+		case "br":
+			cw.encoder = brotli.NewWriter(cw.ResponseWriter)
+		case "gzip":
+			cw.encoder = gzip.NewWriter(cw.ResponseWriter)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.encoder != nil {
+		return cw.encoder.Write(p)
+	}
+
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush flushes compressed data written so far, so streamed responses reach clients.
+func (cw *compressWriter) Flush() {
+	if f, ok := cw.encoder.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			logrus.Error(errors.Wrap(err, "failed to flush compressor"))
+		}
+	}
+
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack allows upgrading compressed connections, e.g. to websockets.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	return hj.Hijack()
+}
+
+// Close writes remaining compressed data.
+func (cw *compressWriter) Close() error {
+	if cw.encoder == nil {
+		return nil
+	}
+
+	return cw.encoder.Close()
+}
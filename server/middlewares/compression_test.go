@@ -0,0 +1,174 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+const body = `{"network":{"banner":[{"provider":"AdMob","score":1}],"country":"SI"}}`
+
+func TestCompression(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"gzip", "gzip"},
+		{"br", "br"},
+		{"gzip, br", "br"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"", ""},
+		{"deflate", ""},
+	}
+
+	for _, tt := range tests {
+		w := serve(tt.acceptEncoding, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Header().Set("Content-Length", "70")
+			_, _ = w.Write([]byte(body))
+		})
+
+		if got := w.Header().Get("Content-Encoding"); got != tt.expected {
+			t.Errorf("%q: Got encoding: %q Expected: %q", tt.acceptEncoding, got, tt.expected)
+		}
+		if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("%q: Got vary: %q Expected: Accept-Encoding", tt.acceptEncoding, got)
+		}
+		if tt.expected != "" && w.Header().Get("Content-Length") != "" {
+			t.Errorf("%q: expected content length of the uncompressed body to be removed", tt.acceptEncoding)
+		}
+
+		if got := decode(t, tt.expected, w.Body); got != body {
+			t.Errorf("%q: Got body: %q Expected: %q", tt.acceptEncoding, got, body)
+		}
+	}
+}
+
+func TestCompressionSkipped(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		encoding    string
+	}{
+		{"not modified", http.StatusNotModified, "application/json", ""},
+		{"no content", http.StatusNoContent, "application/json", ""},
+		{"not compressible", http.StatusOK, "image/png", ""},
+		{"event stream", http.StatusOK, "text/event-stream", ""},
+		{"already encoded", http.StatusOK, "application/json", "identity"},
+	}
+
+	for _, tt := range tests {
+		w := serve("gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", tt.contentType)
+			if tt.encoding != "" {
+				w.Header().Set("Content-Encoding", tt.encoding)
+			}
+			w.WriteHeader(tt.status)
+			if tt.status == http.StatusOK {
+				_, _ = w.Write([]byte(body))
+			}
+		})
+
+		if w.Code != tt.status {
+			t.Errorf("%s: Got status: %d Expected: %d", tt.name, w.Code, tt.status)
+		}
+		if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s: Got encoding: %q Expected: %q", tt.name, got, tt.encoding)
+		}
+		if tt.status == http.StatusOK && w.Body.String() != body {
+			t.Errorf("%s: Got body: %q Expected: %q", tt.name, w.Body.String(), body)
+		}
+	}
+}
+
+func TestCompressionFlush(t *testing.T) {
+	tests := []struct {
+		contentType string
+		encoding    string
+	}{
+		{"text/event-stream", ""},
+		{"application/x-ndjson", "gzip"},
+	}
+
+	for _, tt := range tests {
+		// Data written before a flush reaches the client while the handler is still running.
+		var flushed string
+		rec := serve("gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", tt.contentType)
+			_, _ = w.Write([]byte(body))
+			w.(http.Flusher).Flush()
+
+			rec := w.(*compressWriter).ResponseWriter.(*httptest.ResponseRecorder)
+			if !rec.Flushed {
+				t.Errorf("%s: expected the response to be flushed", tt.contentType)
+			}
+			flushed = decodePrefix(t, tt.encoding, rec.Body.Bytes(), len(body))
+		})
+
+		if flushed != body {
+			t.Errorf("%s: Got flushed: %q Expected: %q", tt.contentType, flushed, body)
+		}
+		if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s: Got encoding: %q Expected: %q", tt.contentType, got, tt.encoding)
+		}
+	}
+}
+
+// serves a request accepting the encoding through the compression middleware.
+func serve(acceptEncoding string, h http.HandlerFunc) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/list", nil)
+	if acceptEncoding != "" {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+
+	w := httptest.NewRecorder()
+	CompressionMiddleware(h).ServeHTTP(w, r)
+	return w
+}
+
+// returns the decoded body.
+func decode(t *testing.T, encoding string, r io.Reader) string {
+	switch encoding {
+	case "gzip":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	case "br":
+		r = brotli.NewReader(r)
+	}
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+// returns the first n decoded bytes of a body that may not be complete yet.
+func decodePrefix(t *testing.T, encoding string, b []byte, n int) string {
+	if encoding == "" {
+		return string(b)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := make([]byte, n)
+	if _, err = io.ReadFull(zr, out); err != nil {
+		t.Fatal(err)
+	}
+
+	return string(out)
+}
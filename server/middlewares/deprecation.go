@@ -0,0 +1,18 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// NewDeprecationMiddleware marks responses of deprecated routes and links to their successor under prefix.
+func NewDeprecationMiddleware(prefix string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", prefix, r.URL.Path))
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
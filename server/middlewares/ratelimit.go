@@ -0,0 +1,140 @@
+package middlewares
+
+import (
+	"expertisetest/auth"
+	"expertisetest/config"
+	"expertisetest/ratelimit"
+	"expertisetest/server/apierror"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// apiKeyLimit is the name of the limit applied to API keys.
+const apiKeyLimit = "apikey"
+
+// bucket key and its limit checked on request.
+type limitCheck struct {
+	key   string
+	limit ratelimit.Limit
+}
+
+// RateLimiter limits calls per client IP and per authenticated credential.
+// It is shared by the http and grpc apis, so both draw from the same buckets.
+type RateLimiter struct {
+	ipLimit ratelimit.Limit
+	limits  map[string]ratelimit.Limit
+	limiter ratelimit.Limiter
+}
+
+// NewRateLimiter returns a RateLimiter with the limits in Config.
+func NewRateLimiter() (*RateLimiter, error) {
+	c := config.GetInstance()
+
+	ipLimit, err := ratelimit.ParseLimit(c.RateLimitIP)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse ip rate limit")
+	}
+
+	limits, err := ratelimit.ParseLimits(c.RateLimitRoles)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse role rate limits")
+	}
+
+	var limiter ratelimit.Limiter = ratelimit.NewMemoryLimiter()
+	if c.RateLimitBackend == "redis" {
+		limiter = ratelimit.NewRedisLimiter(c.MetaRedisClient, "ratelimit:")
+	}
+
+	return &RateLimiter{ipLimit: ipLimit, limits: limits, limiter: limiter}, nil
+}
+
+// Allow takes a call of the client ip and identity, which may be nil, from their buckets.
+// It returns the most restrictive of the limits, nil if none could be checked.
+func (l *RateLimiter) Allow(log *logrus.Entry, ip string, id *auth.Identity) *ratelimit.Result {
+	checks := []limitCheck{{"ip:" + ip, l.ipLimit}}
+
+	if id != nil {
+		// Users share a bucket regardless of the authentication method.
+		name, key := string(id.Role), "user:"+id.Subject
+		if id.Method == auth.MethodAPIKey {
+			name, key = apiKeyLimit, "apikey:"+id.KeyID
+		}
+
+		if limit, ok := l.limits[name]; ok {
+			checks = append(checks, limitCheck{key, limit})
+		}
+	}
+
+	var strictest *ratelimit.Result
+	for _, check := range checks {
+		res, err := l.limiter.Allow(check.key, check.limit)
+		if err != nil {
+			// Rather serve the call than fail on storage errors.
+			log.Error(errors.Wrap(err, "failed to check rate limit"))
+			continue
+		}
+
+		if strictest == nil || !res.Allowed || (strictest.Allowed && res.Remaining < strictest.Remaining) {
+			strictest = res
+		}
+
+		if !res.Allowed {
+			break
+		}
+	}
+
+	return strictest
+}
+
+// NewRateLimitMiddleware returns a middleware limiting requests with the limiter,
+// must run after AuthenticationMiddleware.
+func NewRateLimitMiddleware(l *RateLimiter) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+			if !ok {
+				log = logrus.NewEntry(logrus.StandardLogger())
+			}
+
+			id, _ := r.Context().Value(config.IdentityKey).(*auth.Identity)
+			strictest := l.Allow(log, clientIP(r), id)
+			if strictest == nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(strictest.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(strictest.Remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.Itoa(CeilSeconds(strictest.Reset.Seconds())))
+
+			if !strictest.Allowed {
+				log.WithField("remote_addr", r.RemoteAddr).Warn("rate limit exceeded")
+				w.Header().Set("Retry-After", strconv.Itoa(CeilSeconds(strictest.RetryAfter.Seconds())))
+				apierror.Write(w, apierror.RateLimited())
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// returns remote address without port, RealIP middleware already resolved proxy headers.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// CeilSeconds returns s rounded up to whole seconds, as reported in rate limit headers.
+func CeilSeconds(s float64) int {
+	return int(math.Ceil(s))
+}
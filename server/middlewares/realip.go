@@ -0,0 +1,28 @@
+package middlewares
+
+import (
+	"expertisetest/config"
+	"expertisetest/geoip"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// NewRealIPMiddleware returns a middleware setting RemoteAddr to the client address,
+// following proxy headers only as far as they were set by TRUSTED_PROXIES.
+// Rate limits, audit entries and country detection all see the resolved address.
+func NewRealIPMiddleware() (func(http.Handler) http.Handler, error) {
+	proxies, err := geoip.ParseProxies(config.GetInstance().TrustedProxies)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse trusted proxies")
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := proxies.ClientIP(r.RemoteAddr, r.Header); ip != nil {
+				r.RemoteAddr = ip.String()
+			}
+			h.ServeHTTP(w, r)
+		})
+	}, nil
+}
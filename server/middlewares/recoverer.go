@@ -0,0 +1,29 @@
+package middlewares
+
+import (
+	"expertisetest/server/apierror"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+// RecovererMiddleware recovers from panics, logs them and returns internal_error to the client.
+func RecovererMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rvr := recover(); rvr != nil && rvr != http.ErrAbortHandler {
+				logrus.WithFields(logrus.Fields{
+					"request_id": middleware.GetReqID(r.Context()),
+					"panic":      rvr,
+					"stack":      string(debug.Stack()),
+				}).Error("recovered from panic")
+
+				apierror.Write(w, apierror.Internal())
+			}
+		}()
+
+		h.ServeHTTP(w, r)
+	})
+}
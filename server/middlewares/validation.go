@@ -0,0 +1,79 @@
+package middlewares
+
+import (
+	"bytes"
+	"expertisetest/auth"
+	"expertisetest/config"
+	"expertisetest/server/apierror"
+	"expertisetest/server/openapi"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// NewValidationMiddleware validates requests against the operation of the OpenAPI document,
+// so handlers only receive requests the published specification allows.
+func NewValidationMiddleware(doc *openapi.Document, op *openapi.Operation) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log, ok := r.Context().Value(config.LogKey).(*logrus.Entry)
+			if !ok {
+				log = logrus.NewEntry(logrus.StandardLogger())
+			}
+			log = log.WithField("operation", op.OperationID)
+
+			// Unauthenticated clients should not learn about the rules of secured operations.
+			if id, _ := r.Context().Value(config.IdentityKey).(*auth.Identity); len(op.Security) > 0 && id == nil {
+				log.Debug("unauthenticated")
+				apierror.Write(w, apierror.Unauthenticated(auth.Challenges()...))
+				return
+			}
+
+			path, query := doc.ValidateParameters(op, r.URL.Query(), func(name string) string {
+				return chi.URLParam(r, name)
+			})
+
+			if len(path) > 0 {
+				log.WithField("details", path).Debug("invalid path")
+				apierror.Write(w, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "resource not found", path...))
+				return
+			}
+
+			if len(query) > 0 {
+				log.WithField("details", query).Debug("invalid arguments")
+				apierror.Write(w, apierror.InvalidArguments(query...))
+				return
+			}
+
+			if op.RequestBody != nil {
+				body := []byte{}
+				if r.Body != nil {
+					var err error
+					if body, err = ioutil.ReadAll(r.Body); err != nil {
+						log.Error(errors.Wrap(err, "failed to read body"))
+						apierror.Write(w, apierror.InvalidBody())
+						return
+					}
+
+					if err = r.Body.Close(); err != nil {
+						log.Error(errors.Wrap(err, "failed to close body"))
+					}
+				}
+
+				if e := doc.ValidateBody(op.RequestBody, r.Header.Get("Content-Type"), body); e != nil {
+					log.WithField("details", e.Details).Debug("invalid body")
+					apierror.Write(w, e)
+					return
+				}
+
+				// Handlers read the body again.
+				r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package openapi
+
+import (
+	"expertisetest/server/apierror"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+// Version of the OpenAPI specification documents are generated for.
+const Version = "3.0.3"
+
+// Document is the root object of an OpenAPI document.
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Servers    []Server             `json:"servers,omitempty"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+
+	// types maps component names to go types, to detect name collisions.
+	types map[string]reflect.Type
+}
+
+// Info holds metadata of the API.
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// Server is a base url the paths are relative to.
+type Server struct {
+	URL string `json:"url"`
+}
+
+// Components holds reusable schemas and security schemes.
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes an authentication method.
+type SecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme,omitempty"`
+	In     string `json:"in,omitempty"`
+	Name   string `json:"name,omitempty"`
+	// BearerFormat is a hint for bearer tokens, e.g. JWT.
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// PathItem holds operations of a single path, keyed by lowercase http method.
+type PathItem map[string]*Operation
+
+// Operation describes a single endpoint.
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+}
+
+// Parameter describes a single path or query parameter.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Style       string  `json:"style,omitempty"`
+	Explode     *bool   `json:"explode,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// Delimited makes an array parameter accept a single comma separated value.
+func (p *Parameter) Delimited() *Parameter {
+	explode := false
+	p.Style = "form"
+	p.Explode = &explode
+	return p
+}
+
+// delimited returns true if array values are given comma separated.
+func (p *Parameter) delimited() bool {
+	return p.Explode != nil && !*p.Explode
+}
+
+// RequestBody describes the body of a request.
+type RequestBody struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response describes a single response of an operation.
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// MediaType holds the schema of a body.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// New returns an empty document.
+func New(title, version string) *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info:    Info{Title: title, Version: version},
+		Paths:   map[string]*PathItem{},
+		Components: Components{
+			Schemas:         map[string]*Schema{},
+			SecuritySchemes: map[string]*SecurityScheme{},
+		},
+	}
+}
+
+// Add adds the operation to the document under given method and path.
+func (d *Document) Add(method, path string, op *Operation) {
+	item, ok := d.Paths[path]
+	if !ok {
+		item = &PathItem{}
+		d.Paths[path] = item
+	}
+
+	(*item)[strings.ToLower(method)] = op
+}
+
+// Methods returns http methods documented for the path, sorted.
+func (d *Document) Methods(path string) []string {
+	item, ok := d.Paths[path]
+	if !ok {
+		return nil
+	}
+
+	out := []string{}
+	for method := range *item {
+		out = append(out, strings.ToUpper(method))
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Resolve returns the schema referenced by s, or s itself.
+func (d *Document) Resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, refPrefix)]
+	}
+
+	return s
+}
+
+// Handler serves the document as json.
+func (d *Document) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ffjson.Marshal(d)
+		if err != nil {
+			apierror.Write(w, apierror.Internal())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}
+}
+
+// Query returns a query parameter.
+func Query(name string, schema *Schema, required bool, description string) *Parameter {
+	return &Parameter{Name: name, In: "query", Schema: schema, Required: required, Description: description}
+}
+
+// Path returns a path parameter, path parameters are always required.
+func Path(name string, schema *Schema, description string) *Parameter {
+	return &Parameter{Name: name, In: "path", Schema: schema, Required: true, Description: description}
+}
+
+// Header returns an optional header parameter.
+func Header(name string, schema *Schema, description string) *Parameter {
+	return &Parameter{Name: name, In: "header", Schema: schema, Description: description}
+}
+
+// JSONBody returns a json request body with given schema.
+func JSONBody(schema *Schema, required bool) *RequestBody {
+	return &RequestBody{
+		Required: required,
+		Content:  map[string]*MediaType{"application/json": {Schema: schema}},
+	}
+}
+
+// JSONResponse returns a json response with given schema.
+func JSONResponse(description string, schema *Schema) *Response {
+	return &Response{
+		Description: description,
+		Content:     map[string]*MediaType{"application/json": {Schema: schema}},
+	}
+}
@@ -0,0 +1,176 @@
+package openapi
+
+import (
+	"expertisetest/server/apierror"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testItem struct {
+	Name  string  `json:"name" validate:"required"`
+	Score float64 `json:"score"`
+}
+
+type testBody struct {
+	Items   []*testItem       `json:"items" validate:"required"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Created time.Time         `json:"created"`
+	Skipped string            `json:"-"`
+	hidden  string
+}
+
+func TestSchemaOf(t *testing.T) {
+	d := New("test", "1")
+	ref := d.SchemaOf(testBody{})
+	if ref.Ref != refPrefix+"testBody" {
+		t.Fatalf("expected reference to testBody, got %q", ref.Ref)
+	}
+
+	body := d.Component("testBody")
+	if !reflect.DeepEqual(body.Required, []string{"items"}) {
+		t.Errorf("expected items to be required, got %v", body.Required)
+	}
+
+	if _, ok := body.Properties["Skipped"]; ok {
+		t.Error("expected skipped field to be omitted")
+	}
+
+	if len(body.Properties) != 3 {
+		t.Errorf("expected 3 properties, got %d", len(body.Properties))
+	}
+
+	if body.Properties["created"].Format != "date-time" {
+		t.Errorf("expected time to be a date-time, got %+v", body.Properties["created"])
+	}
+
+	if body.Properties["items"].Items.Ref != refPrefix+"testItem" || *body.Properties["items"].MinItems != 1 {
+		t.Errorf("expected non empty array of testItem, got %+v", body.Properties["items"])
+	}
+
+	if body.Properties["labels"].AdditionalProperties.Type != "string" {
+		t.Errorf("expected map of strings, got %+v", body.Properties["labels"])
+	}
+}
+
+func TestValidateBody(t *testing.T) {
+	d := New("test", "1")
+	rb := JSONBody(d.SchemaOf(testBody{}), true)
+	d.Component("testBody").Properties["items"].MaxLen(2)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		details     []apierror.FieldError
+	}{
+		{"valid", "application/json", `{"items":[{"name":"a","score":1}]}`, 0, nil},
+		{"charset", "application/json; charset=utf-8", `{"items":[{"name":"a"}]}`, 0, nil},
+		{"content type", "text/plain", `{}`, http.StatusUnsupportedMediaType, nil},
+		{"empty", "application/json", ``, http.StatusBadRequest, nil},
+		{"null", "application/json", `null`, http.StatusBadRequest, nil},
+		{"malformed", "application/json", `{"items":`, http.StatusBadRequest, nil},
+		{"missing", "application/json", `{}`, http.StatusUnprocessableEntity, []apierror.FieldError{
+			{Field: "items", Reason: "required"},
+		}},
+		{"nested", "application/json", `{"items":[{"name":""},{"score":"1"}],"created":"yesterday"}`, http.StatusUnprocessableEntity, []apierror.FieldError{
+			{Field: "created", Reason: "must be an RFC3339 timestamp"},
+			{Field: "items[0].name", Reason: "must not be empty"},
+			{Field: "items[1].name", Reason: "required"},
+			{Field: "items[1].score", Reason: "must be a number"},
+		}},
+		{"type", "application/json", `[]`, http.StatusUnprocessableEntity, []apierror.FieldError{
+			{Field: "body", Reason: "must be an object"},
+		}},
+		{"too many", "application/json", `{"items":[{"name":"a"},{"name":"b"},{"name":"c"}]}`, http.StatusUnprocessableEntity, []apierror.FieldError{
+			{Field: "items", Reason: "must contain at most 2 items"},
+		}},
+	}
+
+	for _, tt := range tests {
+		e := d.ValidateBody(rb, tt.contentType, []byte(tt.body))
+		if tt.status == 0 {
+			if e != nil {
+				t.Errorf("%s: expected no error, got %v", tt.name, e)
+			}
+			continue
+		}
+
+		if e == nil || e.Status != tt.status {
+			t.Errorf("%s: expected status %d, got %v", tt.name, tt.status, e)
+			continue
+		}
+
+		if tt.details != nil && !reflect.DeepEqual(e.Details, tt.details) {
+			t.Errorf("%s: expected details %v, got %v", tt.name, tt.details, e.Details)
+		}
+	}
+
+	optional := JSONBody(d.SchemaOf(testBody{}), false)
+	if e := d.ValidateBody(optional, "", nil); e != nil {
+		t.Errorf("expected empty optional body to be valid, got %v", e)
+	}
+}
+
+func TestValidateParameters(t *testing.T) {
+	d := New("test", "1")
+	op := &Operation{
+		Parameters: []*Parameter{
+			Path("action", String().WithEnum("enable", "disable"), ""),
+			Query("country", String().NonEmpty(), true, ""),
+			Query("limit", Integer().Min(0), false, ""),
+			Query("wipe", Boolean(), false, ""),
+			Query("ids", Array(Integer()), false, ""),
+			Query("types", Array(String().WithEnum("banner", "video")), false, "").Delimited(),
+		},
+	}
+
+	path := map[string]string{"action": "enable"}
+	pathParam := func(name string) string {
+		return path[name]
+	}
+
+	p, q := d.ValidateParameters(op, url.Values{"country": {"US"}, "limit": {"3"}, "ids": {"1", "2"}, "types": {"banner,video"}}, pathParam)
+	if len(p) != 0 || len(q) != 0 {
+		t.Errorf("expected valid parameters, got %v %v", p, q)
+	}
+
+	_, q = d.ValidateParameters(op, url.Values{"limit": {"-1"}, "wipe": {"yes"}, "ids": {"1", "a"}, "types": {"banner,audio"}}, pathParam)
+	expected := []apierror.FieldError{
+		{Field: "country", Reason: "required"},
+		{Field: "limit", Reason: "must be at least 0"},
+		{Field: "wipe", Reason: "must be a boolean"},
+		{Field: "ids", Reason: "must be an integer"},
+		{Field: "types[1]", Reason: "must be one of banner, video"},
+	}
+	if !reflect.DeepEqual(q, expected) {
+		t.Errorf("expected %v, got %v", expected, q)
+	}
+
+	_, q = d.ValidateParameters(op, url.Values{"country": {"US", "GB"}}, pathParam)
+	if len(q) != 1 || q[0].Reason != "must be given once" {
+		t.Errorf("expected repeated argument to be invalid, got %v", q)
+	}
+
+	path["action"] = "delete"
+	if p, _ = d.ValidateParameters(op, url.Values{"country": {"US"}}, pathParam); len(p) != 1 {
+		t.Errorf("expected invalid path parameter, got %v", p)
+	}
+}
+
+func TestMethods(t *testing.T) {
+	d := New("test", "1")
+	d.Add(http.MethodPost, "/users", &Operation{})
+	d.Add(http.MethodGet, "/users", &Operation{})
+
+	if methods := d.Methods("/users"); !reflect.DeepEqual(methods, []string{"GET", "POST"}) {
+		t.Errorf("expected GET and POST, got %v", methods)
+	}
+
+	if methods := d.Methods("/unknown"); methods != nil {
+		t.Errorf("expected no methods, got %v", methods)
+	}
+}
@@ -0,0 +1,213 @@
+package openapi
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+const refPrefix = "#/components/schemas/"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Schema is a subset of OpenAPI schema object, enough to describe and validate the api.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+}
+
+// String returns a string schema.
+func String() *Schema {
+	return &Schema{Type: "string"}
+}
+
+// DateTime returns a RFC3339 timestamp schema.
+func DateTime() *Schema {
+	return &Schema{Type: "string", Format: "date-time"}
+}
+
+// Integer returns an integer schema.
+func Integer() *Schema {
+	return &Schema{Type: "integer"}
+}
+
+// Number returns a number schema.
+func Number() *Schema {
+	return &Schema{Type: "number"}
+}
+
+// Boolean returns a boolean schema.
+func Boolean() *Schema {
+	return &Schema{Type: "boolean"}
+}
+
+// Array returns an array schema of given items.
+func Array(items *Schema) *Schema {
+	return &Schema{Type: "array", Items: items}
+}
+
+// WithEnum restricts the schema to given values.
+func (s *Schema) WithEnum(values ...string) *Schema {
+	s.Enum = values
+	return s
+}
+
+// Min sets the minimum of a numeric schema.
+func (s *Schema) Min(min float64) *Schema {
+	s.Minimum = &min
+	return s
+}
+
+// NonEmpty requires strings and arrays to have at least one element.
+func (s *Schema) NonEmpty() *Schema {
+	one := 1
+	switch s.Type {
+	case "string":
+		s.MinLength = &one
+	case "array":
+		s.MinItems = &one
+	}
+	return s
+}
+
+// MaxLen limits the number of items of an array schema.
+func (s *Schema) MaxLen(n int) *Schema {
+	s.MaxItems = &n
+	return s
+}
+
+// Describe sets the description of the schema.
+func (s *Schema) Describe(description string) *Schema {
+	s.Description = description
+	return s
+}
+
+// SchemaOf registers the schema of v's type and returns a reference to it.
+// Schemas are derived from json struct tags, fields tagged with `validate:"required"` are required and non empty.
+func (d *Document) SchemaOf(v interface{}) *Schema {
+	return d.schemaOf(reflect.TypeOf(v))
+}
+
+// Component returns registered schema with given name.
+func (d *Document) Component(name string) *Schema {
+	return d.Components.Schemas[name]
+}
+
+func (d *Document) schemaOf(t reflect.Type) *Schema {
+	if t == timeType {
+		return DateTime()
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return d.schemaOf(t.Elem())
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+
+		name := d.componentName(t)
+		if _, ok := d.Components.Schemas[name]; !ok {
+			// Register before building properties, so self references terminate.
+			d.Components.Schemas[name] = &Schema{}
+			*d.Components.Schemas[name] = *d.structSchema(t)
+		}
+		return &Schema{Ref: refPrefix + name}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return Array(d.schemaOf(t.Elem()))
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schemaOf(t.Elem())}
+	case reflect.String:
+		return String()
+	case reflect.Bool:
+		return Boolean()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Integer()
+	case reflect.Float32, reflect.Float64:
+		return Number()
+	default:
+		// Any value.
+		return &Schema{}
+	}
+}
+
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name, omit := jsonName(f)
+		if omit {
+			continue
+		}
+
+		// Embedded structs without a json name are flattened like encoding/json does.
+		if f.Anonymous && name == "" {
+			embedded := d.Resolve(d.schemaOf(f.Type))
+			for key, prop := range embedded.Properties {
+				s.Properties[key] = prop
+			}
+			s.Required = append(s.Required, embedded.Required...)
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		prop := d.schemaOf(f.Type)
+		if f.Tag.Get("validate") == "required" {
+			s.Required = append(s.Required, name)
+			prop.NonEmpty()
+		}
+		s.Properties[name] = prop
+	}
+
+	return s
+}
+
+// returns a unique component name, types with the same name are prefixed with their package.
+func (d *Document) componentName(t reflect.Type) string {
+	if d.types == nil {
+		d.types = map[string]reflect.Type{}
+	}
+
+	name := t.Name()
+	if other, ok := d.types[name]; ok && other != t {
+		pkg := t.PkgPath()
+		pkg = pkg[strings.LastIndex(pkg, "/")+1:]
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	d.types[name] = t
+
+	return name
+}
+
+// returns json name of the field and whether it is skipped.
+func jsonName(f reflect.StructField) (string, bool) {
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+
+	return strings.Split(tag, ",")[0], false
+}
@@ -0,0 +1,269 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"expertisetest/server/apierror"
+	"fmt"
+	"math"
+	"mime"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidateParameters validates path and query parameters of the operation.
+// Path parameters are read with pathParam, errors are returned separately
+// as invalid path parameters mean the resource does not exist.
+func (d *Document) ValidateParameters(op *Operation, query url.Values, pathParam func(string) string) (path, q []apierror.FieldError) {
+	for _, p := range op.Parameters {
+		switch p.In {
+		case "path":
+			path = append(path, d.validateParameter(p, []string{pathParam(p.Name)})...)
+		case "query":
+			q = append(q, d.validateParameter(p, query[p.Name])...)
+		}
+	}
+
+	return path, q
+}
+
+func (d *Document) validateParameter(p *Parameter, vals []string) []apierror.FieldError {
+	s := d.Resolve(p.Schema)
+
+	if len(vals) == 0 || (len(vals) == 1 && vals[0] == "") {
+		if p.Required {
+			return []apierror.FieldError{{Field: p.Name, Reason: "required"}}
+		}
+		return nil
+	}
+
+	if s == nil {
+		return nil
+	}
+
+	if s.Type != "array" {
+		if len(vals) > 1 {
+			return []apierror.FieldError{{Field: p.Name, Reason: "must be given once"}}
+		}
+
+		v, err := parseValue(s, vals[0])
+		if err != nil {
+			return []apierror.FieldError{{Field: p.Name, Reason: err.Error()}}
+		}
+		return d.ValidateValue(s, v, p.Name)
+	}
+
+	if p.delimited() {
+		if len(vals) > 1 {
+			return []apierror.FieldError{{Field: p.Name, Reason: "must be given once"}}
+		}
+		vals = strings.Split(vals[0], ",")
+	}
+
+	arr := []interface{}{}
+	for _, raw := range vals {
+		v, err := parseValue(d.Resolve(s.Items), raw)
+		if err != nil {
+			return []apierror.FieldError{{Field: p.Name, Reason: err.Error()}}
+		}
+		arr = append(arr, v)
+	}
+	return d.ValidateValue(s, arr, p.Name)
+}
+
+// converts a string parameter into the json type of the schema.
+func parseValue(s *Schema, raw string) (interface{}, error) {
+	if s == nil {
+		return raw, nil
+	}
+
+	switch s.Type {
+	case "integer":
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be an integer")
+		}
+		return float64(v), nil
+	case "number":
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be a number")
+		}
+		return v, nil
+	case "boolean":
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("must be a boolean")
+		}
+		return v, nil
+	default:
+		return raw, nil
+	}
+}
+
+// ValidateBody validates content type and json body of the request.
+// Returned error is ready to be written to the client.
+func (d *Document) ValidateBody(rb *RequestBody, contentType string, body []byte) *apierror.Error {
+	if len(bytes.TrimSpace(body)) == 0 {
+		if rb.Required {
+			return apierror.InvalidBody()
+		}
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+
+	content, ok := rb.Content[mediaType]
+	if !ok {
+		expected := []string{}
+		for key := range rb.Content {
+			expected = append(expected, key)
+		}
+		sort.Strings(expected)
+		return apierror.UnsupportedMediaType(strings.Join(expected, ", "))
+	}
+
+	var v interface{}
+	if err = json.Unmarshal(body, &v); err != nil {
+		return apierror.InvalidBody()
+	}
+
+	if v == nil && rb.Required {
+		return apierror.InvalidBody()
+	}
+
+	if details := d.ValidateValue(content.Schema, v, ""); len(details) > 0 {
+		return apierror.ValidationFailed(details...)
+	}
+
+	return nil
+}
+
+// ValidateValue validates a decoded json value against the schema.
+// Null values are accepted for optional fields, like decoding into go types does.
+func (d *Document) ValidateValue(s *Schema, v interface{}, field string) []apierror.FieldError {
+	s = d.Resolve(s)
+	if s == nil || v == nil {
+		return nil
+	}
+
+	invalid := func(reason string) []apierror.FieldError {
+		if field == "" {
+			field = "body"
+		}
+		return []apierror.FieldError{{Field: field, Reason: reason}}
+	}
+
+	switch s.Type {
+	case "object":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return invalid("must be an object")
+		}
+		return d.validateObject(s, m, field)
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return invalid("must be an array")
+		}
+
+		if s.MinItems != nil && len(arr) < *s.MinItems {
+			return invalid("must not be empty")
+		}
+
+		if s.MaxItems != nil && len(arr) > *s.MaxItems {
+			return invalid(fmt.Sprintf("must contain at most %d items", *s.MaxItems))
+		}
+
+		out := []apierror.FieldError{}
+		for i, item := range arr {
+			out = append(out, d.ValidateValue(s.Items, item, fmt.Sprintf("%s[%d]", field, i))...)
+		}
+		return out
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return invalid("must be a string")
+		}
+
+		if s.MinLength != nil && len(str) < *s.MinLength {
+			return invalid("must not be empty")
+		}
+
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return invalid("must be an RFC3339 timestamp")
+			}
+		}
+
+		if len(s.Enum) > 0 && !contains(s.Enum, str) {
+			return invalid(fmt.Sprintf("must be one of %s", strings.Join(s.Enum, ", ")))
+		}
+	case "integer", "number":
+		n, ok := v.(float64)
+		if !ok {
+			return invalid("must be a number")
+		}
+
+		if s.Type == "integer" && n != math.Trunc(n) {
+			return invalid("must be an integer")
+		}
+
+		if s.Minimum != nil && n < *s.Minimum {
+			return invalid(fmt.Sprintf("must be at least %v", *s.Minimum))
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return invalid("must be a boolean")
+		}
+	}
+
+	return nil
+}
+
+func (d *Document) validateObject(s *Schema, m map[string]interface{}, field string) []apierror.FieldError {
+	prefix := field
+	if prefix != "" {
+		prefix += "."
+	}
+
+	out := []apierror.FieldError{}
+	for _, name := range s.Required {
+		if m[name] == nil {
+			out = append(out, apierror.FieldError{Field: prefix + name, Reason: "required"})
+		}
+	}
+
+	// Validate in a stable order, so clients get the same details for the same body.
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		prop, ok := s.Properties[key]
+		if !ok {
+			prop = s.AdditionalProperties
+		}
+		out = append(out, d.ValidateValue(prop, m[key], prefix+key)...)
+	}
+
+	return out
+}
+
+func contains(arr []string, s string) bool {
+	for _, item := range arr {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
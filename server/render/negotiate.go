@@ -0,0 +1,93 @@
+package render
+
+import (
+	"strconv"
+	"strings"
+)
+
+// preference is a single entry of an Accept or Accept-Encoding header.
+type preference struct {
+	value string
+	q     float64
+}
+
+// parses comma separated header values with their quality, values without quality have q=1.
+func parse(header string) []preference {
+	out := []preference{}
+	for _, item := range strings.Split(header, ",") {
+		parts := strings.Split(item, ";")
+		value := strings.ToLower(strings.TrimSpace(parts[0]))
+		if value == "" {
+			continue
+		}
+
+		p := preference{value: value, q: 1}
+		for _, param := range parts[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) != 2 || strings.ToLower(kv[0]) != "q" {
+				continue
+			}
+
+			q, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil || q < 0 || q > 1 {
+				q = 0
+			}
+			p.q = q
+		}
+
+		out = append(out, p)
+	}
+
+	return out
+}
+
+// returns how specifically value matches offer, 0 if it does not match.
+// Exact matches win over "type/*" ranges, which win over "*" and "*/*".
+func specificity(value, offer string) int {
+	switch {
+	case value == offer:
+		return 3
+	case strings.HasSuffix(value, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(value, "*")):
+		return 2
+	case value == "*" || value == "*/*":
+		return 1
+	}
+
+	return 0
+}
+
+// Preferred returns the offer the client prefers according to header,
+// using the quality of the most specific matching entry of each offer.
+// Offers are given in order of server preference, which breaks ties.
+// An empty string is returned if no offer is acceptable.
+func Preferred(header string, offers []string) string {
+	prefs := parse(header)
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, matched := 0.0, 0
+		for _, p := range prefs {
+			if s := specificity(p.value, offer); s > matched {
+				q, matched = p.q, s
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	return best
+}
+
+// Explicit returns true if header accepts the media type by name, ranges such as "*/*" do not count.
+// It tells apart clients asking for a different kind of response, e.g. an event stream, from generic ones.
+func Explicit(header, mediaType string) bool {
+	for _, p := range parse(header) {
+		if p.value == mediaType && p.q > 0 {
+			return true
+		}
+	}
+
+	return false
+}
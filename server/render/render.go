@@ -0,0 +1,121 @@
+package render
+
+import (
+	"bytes"
+	"expertisetest/server/apierror"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/pquerna/ffjson/ffjson"
+	"github.com/sirupsen/logrus"
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// ProtoMarshaler is implemented by responses with a protobuf encoding.
+type ProtoMarshaler interface {
+	MarshalProto() ([]byte, error)
+}
+
+// Codec encodes responses into a media type.
+// All codecs encode the same response types, field names follow their json tags.
+type Codec struct {
+	ContentType string
+	aliases     []string
+	marshal     func(v interface{}) ([]byte, error)
+	supports    func(v interface{}) bool
+}
+
+// Marshal encodes v.
+func (c *Codec) Marshal(v interface{}) ([]byte, error) {
+	return c.marshal(v)
+}
+
+// Codecs in order of preference when clients accept several equally.
+var (
+	JSON = &Codec{
+		ContentType: "application/json",
+		marshal:     ffjson.Marshal,
+		supports:    func(interface{}) bool { return true },
+	}
+
+	MessagePack = &Codec{
+		ContentType: "application/msgpack",
+		aliases:     []string{"application/x-msgpack"},
+		marshal: func(v interface{}) ([]byte, error) {
+			buf := &bytes.Buffer{}
+			err := msgpack.NewEncoder(buf).UseJSONTag(true).Encode(v)
+			return buf.Bytes(), err
+		},
+		supports: func(interface{}) bool { return true },
+	}
+
+	Protobuf = &Codec{
+		ContentType: "application/x-protobuf",
+		aliases:     []string{"application/protobuf"},
+		marshal: func(v interface{}) ([]byte, error) {
+			pm, ok := v.(ProtoMarshaler)
+			if !ok {
+				return nil, errors.Errorf("%T has no protobuf encoding", v)
+			}
+			return pm.MarshalProto()
+		},
+		supports: func(v interface{}) bool {
+			_, ok := v.(ProtoMarshaler)
+			return ok
+		},
+	}
+
+	codecs = []*Codec{JSON, MessagePack, Protobuf}
+)
+
+// Negotiate returns the codec for v preferred by the Accept header.
+// JSON is used when the header is empty, nil is returned if no codec able to encode v is acceptable.
+func Negotiate(accept string, v interface{}) *Codec {
+	if accept == "" {
+		return JSON
+	}
+
+	offers := []string{}
+	byType := map[string]*Codec{}
+	for _, c := range codecs {
+		if !c.supports(v) {
+			continue
+		}
+
+		for _, mediaType := range append([]string{c.ContentType}, c.aliases...) {
+			offers = append(offers, mediaType)
+			byType[mediaType] = c
+		}
+	}
+
+	return byType[Preferred(accept, offers)]
+}
+
+// Available returns content types of codecs able to encode v.
+func Available(v interface{}) []string {
+	out := []string{}
+	for _, c := range codecs {
+		if c.supports(v) {
+			out = append(out, c.ContentType)
+		}
+	}
+
+	return out
+}
+
+// Write encodes v with the codec and writes it with status.
+func Write(w http.ResponseWriter, c *Codec, status int, v interface{}) {
+	body, err := c.Marshal(v)
+	if err != nil {
+		logrus.Error(errors.Wrapf(err, "failed to encode %s", c.ContentType))
+		apierror.Write(w, apierror.Internal())
+		return
+	}
+
+	w.Header().Set("Content-Type", c.ContentType)
+	w.WriteHeader(status)
+
+	if _, err := w.Write(body); err != nil {
+		logrus.Error(err)
+	}
+}
@@ -0,0 +1,108 @@
+package render
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+func TestPreferred(t *testing.T) {
+	offers := []string{"application/json", "application/msgpack", "application/x-protobuf"}
+
+	tests := []struct {
+		header   string
+		offers   []string
+		expected string
+	}{
+		{"", offers, ""},
+		{"*/*", offers, "application/json"},
+		{"application/msgpack", offers, "application/msgpack"},
+		{"application/json;q=0.5, application/x-protobuf", offers, "application/x-protobuf"},
+		{"application/*;q=0.2, application/msgpack;q=0.8", offers, "application/msgpack"},
+		{"application/json;q=0, */*", offers, "application/msgpack"},
+		{"text/html", offers, ""},
+		{"APPLICATION/JSON", offers, "application/json"},
+		{"gzip, br", []string{"br", "gzip"}, "br"},
+		{"gzip;q=1.0, br;q=0.5", []string{"br", "gzip"}, "gzip"},
+		{"*;q=0.1, br;q=0", []string{"br", "gzip"}, "gzip"},
+		{"identity", []string{"br", "gzip"}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := Preferred(tt.header, tt.offers); got != tt.expected {
+			t.Errorf("%q: Got: %q Expected: %q", tt.header, got, tt.expected)
+		}
+	}
+}
+
+func TestExplicit(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"text/*", false},
+		{"text/event-stream", true},
+		{"application/json, Text/Event-Stream;q=0.5", true},
+		{"text/event-stream;q=0", false},
+	}
+
+	for _, tt := range tests {
+		if got := Explicit(tt.header, "text/event-stream"); got != tt.expected {
+			t.Errorf("%q: Got: %t Expected: %t", tt.header, got, tt.expected)
+		}
+	}
+}
+
+type plain struct {
+	Name   string `json:"name"`
+	Hidden string `json:"-"`
+}
+
+type withProto struct{}
+
+func (withProto) MarshalProto() ([]byte, error) {
+	return []byte{0x0a, 0x00}, nil
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept   string
+		v        interface{}
+		expected *Codec
+	}{
+		{"", plain{}, JSON},
+		{"application/x-msgpack", plain{}, MessagePack},
+		{"application/x-protobuf", plain{}, nil},
+		{"application/x-protobuf", withProto{}, Protobuf},
+		{"application/protobuf, application/json;q=0.1", withProto{}, Protobuf},
+	}
+
+	for _, tt := range tests {
+		if got := Negotiate(tt.accept, tt.v); got != tt.expected {
+			t.Errorf("%q %T: Got: %v Expected: %v", tt.accept, tt.v, got, tt.expected)
+		}
+	}
+
+	if available := Available(plain{}); len(available) != 2 {
+		t.Errorf("expected json and msgpack, got %v", available)
+	}
+}
+
+func TestMessagePack(t *testing.T) {
+	b, err := MessagePack.Marshal(plain{Name: "a", Hidden: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := map[string]interface{}{}
+	if err = msgpack.NewDecoder(bytes.NewReader(b)).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out) != 1 || out["name"] != "a" {
+		t.Errorf("expected json field names, got %v", out)
+	}
+}
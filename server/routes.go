@@ -0,0 +1,622 @@
+package server
+
+import (
+	"expertisetest/adnetwork"
+	"expertisetest/auth"
+	"expertisetest/config"
+	"expertisetest/handler"
+	"expertisetest/server/apierror"
+	"expertisetest/server/endpoints"
+	"expertisetest/server/graphql"
+	"expertisetest/server/middlewares"
+	"expertisetest/server/openapi"
+	"expertisetest/server/render"
+	"expertisetest/webhook"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// route binds a handler to a method and path, together with its OpenAPI operation.
+// Routes are the single source for registration, documentation and validation.
+type route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+	op      *openapi.Operation
+	// admin routes can additionally require a verified client certificate.
+	admin bool
+	// audit is the action recorded in the audit log, empty for no record.
+	audit string
+}
+
+// api is a version of the api, mounted under its prefix with its own document.
+// Response shapes can only change in a new version, so shipped clients keep working.
+type api struct {
+	prefix string
+	doc    *openapi.Document
+	routes []route
+}
+
+// mount registers routes of the api on the router.
+// Paths answer methods they do not support with 405 and the list of allowed methods.
+func (a *api) mount(r chi.Router, c *config.Config) {
+	// Catch-all is registered first, method handlers registered later take precedence.
+	for path := range a.doc.Paths {
+		methods := a.doc.Methods(path)
+		r.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			apierror.Write(w, apierror.MethodNotAllowed(methods...))
+		})
+	}
+
+	for _, rt := range a.routes {
+		mws := []func(http.Handler) http.Handler{}
+		if rt.admin && c.TLSRequireAdminCert {
+			mws = append(mws, middlewares.ClientCertMiddleware)
+		}
+		if rt.audit != "" {
+			mws = append(mws, middlewares.AuditMiddleware(rt.audit))
+		}
+		mws = append(mws, middlewares.NewValidationMiddleware(a.doc, rt.op))
+
+		r.With(mws...).Method(rt.method, rt.path, rt.handler)
+	}
+}
+
+// newV1 returns the first version of the api.
+func newV1(c *config.Config) *api {
+	doc := openapi.New("Ad network API", "1.0.0")
+	doc.Components.SecuritySchemes["bearerAuth"] = &openapi.SecurityScheme{Type: "http", Scheme: "bearer", BearerFormat: "JWT"}
+	doc.Components.SecuritySchemes["apiKey"] = &openapi.SecurityScheme{Type: "apiKey", In: "header", Name: middlewares.APIKeyHeader}
+
+	secured := []map[string][]string{{"bearerAuth": {}}, {"apiKey": {}}}
+	if c.AuthBasicEnabled {
+		doc.Components.SecuritySchemes["basicAuth"] = &openapi.SecurityScheme{Type: "http", Scheme: "basic"}
+		secured = append(secured, map[string][]string{"basicAuth": {}})
+	}
+
+	errorSchema := doc.SchemaOf(apierror.Envelope{})
+	responses := func(status int, description string, v interface{}, errs ...int) map[string]*openapi.Response {
+		out := map[string]*openapi.Response{}
+		if v != nil {
+			out[strconv.Itoa(status)] = openapi.JSONResponse(description, doc.SchemaOf(v))
+		} else {
+			out[strconv.Itoa(status)] = &openapi.Response{Description: description}
+		}
+
+		for _, code := range errs {
+			out[strconv.Itoa(code)] = openapi.JSONResponse(http.StatusText(code), errorSchema)
+		}
+		return out
+	}
+
+	roles := []string{}
+	for _, role := range auth.Roles {
+		roles = append(roles, string(role))
+	}
+
+	scopes := []string{}
+	for _, scope := range auth.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+
+	// Without countryCode, clients are located by their address when a GeoIP database is configured.
+	countryCode := openapi.Query("countryCode", openapi.String().NonEmpty(), true, "ISO-3166-1 alpha-2 country code")
+	if c.GeoIPFile != "" {
+		countryCode = openapi.Query("countryCode", openapi.String().NonEmpty(), false, "ISO-3166-1 alpha-2 country code, detected from the client address when omitted")
+	}
+
+	// Without device arguments, they are derived from the User-Agent and client hints when enabled.
+	deviceRequired, derivedNote := !c.UserAgentDetection, ""
+	if c.UserAgentDetection {
+		derivedNote = ", derived from the User-Agent and client hints when omitted"
+	}
+	platform := openapi.Query("platform", openapi.String().NonEmpty(), deviceRequired, "lowercase name of the device operating system"+derivedNote)
+	osVersion := openapi.Query("osVersion", openapi.String().NonEmpty(), deviceRequired, "numeric version of the operating system"+derivedNote)
+	device := openapi.Query("device", openapi.String().NonEmpty(), deviceRequired, "type of the device (phone, tablet, tv, ...)"+derivedNote)
+
+	tenant := openapi.Query("app", openapi.String().WithEnum(c.Tenants...), false, "tenant (app) identifier, defaults to the default tenant")
+
+	// Request schemas are registered first, so enums can be added to them.
+	userRequest := doc.SchemaOf(endpoints.UserRequest{})
+	doc.Component("UserRequest").Properties["role"].WithEnum(roles...)
+	doc.Component("UserRequest").Properties["tenants"].Items.WithEnum(c.Tenants...)
+
+	keyRequest := doc.SchemaOf(endpoints.APIKeyRequest{})
+	doc.Component("APIKeyRequest").Properties["scopes"].Items.WithEnum(scopes...)
+	doc.Component("APIKeyRequest").Properties["tenants"].Items.WithEnum(c.Tenants...)
+
+	webhookRequest := doc.SchemaOf(endpoints.WebhookRequest{})
+	doc.Component("WebhookRequest").Properties["events"].Items.WithEnum(webhook.Events...)
+	doc.Component("WebhookRequest").Properties["tenants"].Items.WithEnum(c.Tenants...)
+
+	providerRequest := doc.SchemaOf(endpoints.ProviderRequest{})
+	doc.Component("Placement").Properties["adType"].WithEnum(adnetwork.AdTypes...)
+	doc.Component("Settings").Properties["timeoutMs"].Min(0)
+
+	killSwitchRequest := doc.SchemaOf(endpoints.KillSwitchRequest{})
+	doc.Component("KillSwitchRequest").Properties["adType"].WithEnum(adnetwork.AdTypes...)
+
+	batchRequest := doc.SchemaOf(endpoints.BatchRequest{})
+	doc.Component("BatchRequest").Properties["contexts"].MaxLen(c.BatchMaxSize)
+	doc.Component("DeviceContext").Properties["types"].NonEmpty().Items.WithEnum(adnetwork.AdTypes...)
+	doc.Component("DeviceContext").Properties["limit"].Min(1)
+
+	// Network responses are also encoded as MessagePack, with the json schema, and as protobuf, see endpoints/pb/list.proto.
+	negotiated := func(out map[string]*openapi.Response) map[string]*openapi.Response {
+		ok := out[strconv.Itoa(http.StatusOK)]
+		ok.Content[render.MessagePack.ContentType] = &openapi.MediaType{Schema: ok.Content[render.JSON.ContentType].Schema}
+		ok.Content[render.Protobuf.ContentType] = &openapi.MediaType{Schema: &openapi.Schema{Type: "string", Format: "binary"}}
+		out[strconv.Itoa(http.StatusNotAcceptable)] = openapi.JSONResponse(http.StatusText(http.StatusNotAcceptable), errorSchema)
+		return out
+	}
+
+	listResponses := negotiated(responses(http.StatusOK, "ad networks", endpoints.Response{}, 400, 401, 403, 503))
+	listResponses[strconv.Itoa(http.StatusNotModified)] = &openapi.Response{Description: "response given in If-None-Match is current"}
+
+	watchResponses := negotiated(responses(http.StatusOK, "ad networks, or a stream of them as server-sent events", endpoints.Response{}, 400, 401, 403, 503))
+	watchResponses[strconv.Itoa(http.StatusOK)].Content["text/event-stream"] = &openapi.MediaType{Schema: &openapi.Schema{Type: "string"}}
+	watchResponses[strconv.Itoa(http.StatusNotModified)] = &openapi.Response{Description: "no dataset affecting the response given in If-None-Match was published before the timeout"}
+
+	a := &api{prefix: "/v1", doc: doc}
+	doc.Servers = []openapi.Server{{URL: a.prefix}}
+	a.routes = []route{
+		{
+			method:  http.MethodPost,
+			path:    "/login",
+			handler: endpoints.Login,
+			op: &openapi.Operation{
+				OperationID: "login",
+				Summary:     "Exchange credentials, in the body or basic auth header, for a token pair",
+				Tags:        []string{"auth"},
+				RequestBody: openapi.JSONBody(doc.SchemaOf(endpoints.LoginRequest{}), false),
+				Responses:   responses(http.StatusOK, "token pair", auth.TokenPair{}, 400, 401, 415),
+			},
+		},
+		{
+			method:  http.MethodPost,
+			path:    "/refresh",
+			handler: endpoints.Refresh,
+			op: &openapi.Operation{
+				OperationID: "refresh",
+				Summary:     "Exchange a refresh token for a new token pair",
+				Tags:        []string{"auth"},
+				RequestBody: openapi.JSONBody(doc.SchemaOf(endpoints.RefreshRequest{}), true),
+				Responses:   responses(http.StatusOK, "token pair", auth.TokenPair{}, 400, 401, 415, 422),
+			},
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/list",
+			handler: endpoints.List,
+			op: &openapi.Operation{
+				OperationID: "list",
+				Summary:     "Ad networks ordered by score for the device context",
+				Tags:        []string{"networks"},
+				Parameters: []*openapi.Parameter{
+					countryCode,
+					platform,
+					osVersion,
+					device,
+					openapi.Query("types", openapi.Array(openapi.String().WithEnum(adnetwork.AdTypes...)).NonEmpty(), false, "comma separated ad types to return, all types by default").Delimited(),
+					openapi.Query("limit", openapi.Integer().Min(1), false, "maximum number of providers per list, highest score first"),
+					tenant,
+					openapi.Header("If-None-Match", openapi.String(), "entity tag of a previous response, answered with 304 while it is current"),
+				},
+				Responses: listResponses,
+				Security:  secured,
+			},
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/list/watch",
+			handler: endpoints.WatchList,
+			op: &openapi.Operation{
+				OperationID: "watchList",
+				Summary:     "Wait for ad networks of the device context to change, by long-poll or as server-sent events with Accept: text/event-stream",
+				Tags:        []string{"networks"},
+				Parameters: []*openapi.Parameter{
+					countryCode,
+					platform,
+					osVersion,
+					device,
+					openapi.Query("types", openapi.Array(openapi.String().WithEnum(adnetwork.AdTypes...)).NonEmpty(), false, "comma separated ad types to return, all types by default").Delimited(),
+					openapi.Query("limit", openapi.Integer().Min(1), false, "maximum number of providers per list, highest score first"),
+					openapi.Query("timeout", openapi.Integer().Min(1), false, fmt.Sprintf("seconds to wait, at most and by default %d", c.ListWatchTimeout/time.Second)),
+					tenant,
+					openapi.Header("If-None-Match", openapi.String(), "entity tag of a previous response, the long-poll waits while it is current"),
+					openapi.Header("Last-Event-ID", openapi.String(), "id of the last event received, the stream skips the network while it is current"),
+				},
+				Responses: watchResponses,
+				Security:  secured,
+			},
+		},
+		{
+			method:  http.MethodPost,
+			path:    "/list/batch",
+			handler: endpoints.ListBatch,
+			op: &openapi.Operation{
+				OperationID: "listBatch",
+				Summary:     "Ad networks for many device contexts, one result per context in request order",
+				Tags:        []string{"networks"},
+				Parameters:  []*openapi.Parameter{tenant},
+				RequestBody: openapi.JSONBody(batchRequest, true),
+				Responses:   negotiated(responses(http.StatusOK, "one result per context", endpoints.BatchResponse{}, 400, 401, 403, 415, 422, 503)),
+				Security:    secured,
+			},
+		},
+		{
+			method:  http.MethodPost,
+			path:    "/update",
+			handler: endpoints.Update,
+			admin:   true,
+			audit:   "update",
+			op: &openapi.Operation{
+				OperationID: "update",
+				Summary:     "Store a new dataset",
+				Tags:        []string{"networks"},
+				Parameters: []*openapi.Parameter{
+					openapi.Query("wipe", openapi.Boolean(), false, "drop the stored dataset before inserting the new one"),
+					tenant,
+				},
+				RequestBody: openapi.JSONBody(doc.SchemaOf(handler.LoadObject{}), true),
+				Responses:   responses(http.StatusOK, "dataset stored", endpoints.Response{}, 400, 401, 403, 415, 422, 503),
+				Security:    secured,
+			},
+		},
+		{
+			method:  http.MethodPost,
+			path:    "/graphql",
+			handler: graphql.Handler,
+			op: &openapi.Operation{
+				OperationID: "graphql",
+				Summary:     "Execute a GraphQL query or mutation, resolver errors are listed in the response",
+				Tags:        []string{"graphql"},
+				RequestBody: openapi.JSONBody(doc.SchemaOf(graphql.Request{}), true),
+				Responses: map[string]*openapi.Response{
+					strconv.Itoa(http.StatusOK): openapi.JSONResponse("GraphQL response", &openapi.Schema{
+						Type: "object",
+						Properties: map[string]*openapi.Schema{
+							"data":   {Type: "object"},
+							"errors": openapi.Array(&openapi.Schema{Type: "object"}),
+						},
+					}),
+					strconv.Itoa(http.StatusBadRequest):           openapi.JSONResponse(http.StatusText(http.StatusBadRequest), errorSchema),
+					strconv.Itoa(http.StatusUnauthorized):         openapi.JSONResponse(http.StatusText(http.StatusUnauthorized), errorSchema),
+					strconv.Itoa(http.StatusUnsupportedMediaType): openapi.JSONResponse(http.StatusText(http.StatusUnsupportedMediaType), errorSchema),
+					strconv.Itoa(http.StatusUnprocessableEntity):  openapi.JSONResponse(http.StatusText(http.StatusUnprocessableEntity), errorSchema),
+				},
+				Security: secured,
+			},
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/graphql",
+			handler: graphql.Handler,
+			op: &openapi.Operation{
+				OperationID: "graphqlWebsocket",
+				Summary:     "Upgrade to a websocket running GraphQL operations and subscriptions with the graphql-ws subprotocol, credentials can be sent in connection_init payload",
+				Tags:        []string{"graphql"},
+				Responses: map[string]*openapi.Response{
+					strconv.Itoa(http.StatusSwitchingProtocols): {Description: "websocket established"},
+					strconv.Itoa(http.StatusBadRequest):         {Description: "not a websocket handshake"},
+				},
+			},
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/users",
+			handler: endpoints.Users,
+			admin:   true,
+			audit:   "users",
+			op: &openapi.Operation{
+				OperationID: "listUsers",
+				Summary:     "List users",
+				Tags:        []string{"accounts"},
+				Responses:   responses(http.StatusOK, "users", endpoints.UsersResponse{}, 401, 403, 503),
+				Security:    secured,
+			},
+		},
+		{
+			method:  http.MethodPost,
+			path:    "/users",
+			handler: endpoints.Users,
+			admin:   true,
+			audit:   "users",
+			op: &openapi.Operation{
+				OperationID: "createUser",
+				Summary:     "Create a user",
+				Tags:        []string{"accounts"},
+				RequestBody: openapi.JSONBody(userRequest, true),
+				Responses:   responses(http.StatusCreated, "created user", endpoints.UsersResponse{}, 400, 401, 403, 409, 415, 422),
+				Security:    secured,
+			},
+		},
+		{
+			method:  http.MethodPost,
+			path:    "/users/{username}/{action}",
+			handler: endpoints.UserAction,
+			admin:   true,
+			audit:   "users",
+			op: &openapi.Operation{
+				OperationID: "updateUser",
+				Summary:     "Disable or enable a user, or rotate its password",
+				Tags:        []string{"accounts"},
+				Parameters: []*openapi.Parameter{
+					openapi.Path("username", openapi.String(), "name of the user"),
+					openapi.Path("action", openapi.String().WithEnum("disable", "enable", "rotate"), "action to perform"),
+				},
+				RequestBody: openapi.JSONBody(doc.SchemaOf(endpoints.PasswordRequest{}), false),
+				Responses:   responses(http.StatusOK, "updated user or generated password", endpoints.UsersResponse{}, 401, 403, 404, 415, 422),
+				Security:    secured,
+			},
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/apikeys",
+			handler: endpoints.APIKeys,
+			admin:   true,
+			audit:   "apikeys",
+			op: &openapi.Operation{
+				OperationID: "listAPIKeys",
+				Summary:     "List API keys",
+				Tags:        []string{"accounts"},
+				Responses:   responses(http.StatusOK, "api keys", endpoints.APIKeysResponse{}, 401, 403, 503),
+				Security:    secured,
+			},
+		},
+		{
+			method:  http.MethodPost,
+			path:    "/apikeys",
+			handler: endpoints.APIKeys,
+			admin:   true,
+			audit:   "apikeys",
+			op: &openapi.Operation{
+				OperationID: "createAPIKey",
+				Summary:     "Create an API key, the key is only returned once",
+				Tags:        []string{"accounts"},
+				RequestBody: openapi.JSONBody(keyRequest, true),
+				Responses:   responses(http.StatusCreated, "created api key", endpoints.APIKeysResponse{}, 400, 401, 403, 415, 422),
+				Security:    secured,
+			},
+		},
+		{
+			method:  http.MethodPost,
+			path:    "/apikeys/{id}/{action}",
+			handler: endpoints.APIKeyAction,
+			admin:   true,
+			audit:   "apikeys",
+			op: &openapi.Operation{
+				OperationID: "updateAPIKey",
+				Summary:     "Revoke or rotate an API key",
+				Tags:        []string{"accounts"},
+				Parameters: []*openapi.Parameter{
+					openapi.Path("id", openapi.String(), "id of the api key"),
+					openapi.Path("action", openapi.String().WithEnum("revoke", "rotate"), "action to perform"),
+				},
+				RequestBody: openapi.JSONBody(doc.SchemaOf(endpoints.RotateKeyRequest{}), false),
+				Responses:   responses(http.StatusOK, "updated api key", endpoints.APIKeysResponse{}, 401, 403, 404, 409, 415, 422),
+				Security:    secured,
+			},
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/webhooks",
+			handler: endpoints.Webhooks,
+			admin:   true,
+			audit:   "webhooks",
+			op: &openapi.Operation{
+				OperationID: "listWebhooks",
+				Summary:     "List webhooks",
+				Tags:        []string{"webhooks"},
+				Responses:   responses(http.StatusOK, "webhooks", endpoints.WebhooksResponse{}, 401, 403, 503),
+				Security:    secured,
+			},
+		},
+		{
+			method:  http.MethodPost,
+			path:    "/webhooks",
+			handler: endpoints.Webhooks,
+			admin:   true,
+			audit:   "webhooks",
+			op: &openapi.Operation{
+				OperationID: "createWebhook",
+				Summary:     "Create a webhook, the signing secret is only returned once",
+				Tags:        []string{"webhooks"},
+				RequestBody: openapi.JSONBody(webhookRequest, true),
+				Responses:   responses(http.StatusCreated, "created webhook", endpoints.WebhooksResponse{}, 400, 401, 403, 415, 422),
+				Security:    secured,
+			},
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/webhooks/deadletters",
+			handler: endpoints.DeadLetters,
+			admin:   true,
+			op: &openapi.Operation{
+				OperationID: "listDeadLetters",
+				Summary:     "List deliveries that failed every attempt, latest failures first",
+				Tags:        []string{"webhooks"},
+				Parameters: []*openapi.Parameter{
+					openapi.Query("limit", openapi.Integer().Min(0), false, "maximum number of dead letters"),
+				},
+				Responses: responses(http.StatusOK, "dead letters", endpoints.DeadLettersResponse{}, 400, 401, 403, 503),
+				Security:  secured,
+			},
+		},
+		{
+			method:  http.MethodPost,
+			path:    "/webhooks/deadletters/{delivery}/redeliver",
+			handler: endpoints.Redeliver,
+			admin:   true,
+			audit:   "webhooks.redeliver",
+			op: &openapi.Operation{
+				OperationID: "redeliverWebhook",
+				Summary:     "Queue a dead letter again with a fresh set of attempts",
+				Tags:        []string{"webhooks"},
+				Parameters: []*openapi.Parameter{
+					openapi.Path("delivery", openapi.String(), "id of the delivery"),
+				},
+				Responses: responses(http.StatusAccepted, "queued dead letter", endpoints.DeadLettersResponse{}, 401, 403, 404),
+				Security:  secured,
+			},
+		},
+		{
+			method:  http.MethodPost,
+			path:    "/webhooks/{id}/{action}",
+			handler: endpoints.WebhookAction,
+			admin:   true,
+			audit:   "webhooks",
+			op: &openapi.Operation{
+				OperationID: "updateWebhook",
+				Summary:     "Enable, disable or delete a webhook",
+				Tags:        []string{"webhooks"},
+				Parameters: []*openapi.Parameter{
+					openapi.Path("id", openapi.String(), "id of the webhook"),
+					openapi.Path("action", openapi.String().WithEnum("enable", "disable", "delete"), "action to perform"),
+				},
+				Responses: responses(http.StatusOK, "updated webhook", endpoints.WebhooksResponse{}, 401, 403, 404),
+				Security:  secured,
+			},
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/webhooks/{id}/deliveries",
+			handler: endpoints.WebhookDeliveries,
+			admin:   true,
+			op: &openapi.Operation{
+				OperationID: "listWebhookDeliveries",
+				Summary:     "List the latest delivery attempts of a webhook, newest first",
+				Tags:        []string{"webhooks"},
+				Parameters: []*openapi.Parameter{
+					openapi.Path("id", openapi.String(), "id of the webhook"),
+					openapi.Query("limit", openapi.Integer().Min(0), false, "maximum number of attempts"),
+				},
+				Responses: responses(http.StatusOK, "delivery attempts", endpoints.DeliveriesResponse{}, 400, 401, 403, 404, 503),
+				Security:  secured,
+			},
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/providers",
+			handler: endpoints.Providers,
+			admin:   true,
+			op: &openapi.Operation{
+				OperationID: "listProviders",
+				Summary:     "List provider metadata and the ids of all registered providers",
+				Tags:        []string{"providers"},
+				Responses:   responses(http.StatusOK, "provider metadata", endpoints.ProvidersResponse{}, 401, 403, 503),
+				Security:    secured,
+			},
+		},
+		{
+			method:  http.MethodPost,
+			path:    "/providers",
+			handler: endpoints.Providers,
+			admin:   true,
+			audit:   "providers",
+			op: &openapi.Operation{
+				OperationID: "setProvider",
+				Summary:     "Set the placements of a provider, replacing its previous ones",
+				Tags:        []string{"providers"},
+				RequestBody: openapi.JSONBody(providerRequest, true),
+				Responses:   responses(http.StatusOK, "provider metadata", endpoints.ProvidersResponse{}, 400, 401, 403, 415, 422, 503),
+				Security:    secured,
+			},
+		},
+		{
+			method:  http.MethodPost,
+			path:    "/providers/{id}/{action}",
+			handler: endpoints.ProviderAction,
+			admin:   true,
+			audit:   "providers",
+			op: &openapi.Operation{
+				OperationID: "updateProvider",
+				Summary:     "Delete the metadata of a provider, dropping it from lists",
+				Tags:        []string{"providers"},
+				Parameters: []*openapi.Parameter{
+					openapi.Path("id", openapi.String(), "id or alias of the provider"),
+					openapi.Path("action", openapi.String().WithEnum("delete"), "action to perform"),
+				},
+				Responses: responses(http.StatusOK, "deleted provider metadata", endpoints.ProvidersResponse{}, 401, 403, 404, 503),
+				Security:  secured,
+			},
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/killswitches",
+			handler: endpoints.KillSwitches,
+			admin:   true,
+			op: &openapi.Operation{
+				OperationID: "listKillSwitches",
+				Summary:     "List kill switches, expired ones included",
+				Tags:        []string{"providers"},
+				Responses:   responses(http.StatusOK, "kill switches", endpoints.KillSwitchesResponse{}, 401, 403, 503),
+				Security:    secured,
+			},
+		},
+		{
+			method:  http.MethodPost,
+			path:    "/killswitches",
+			handler: endpoints.KillSwitches,
+			admin:   true,
+			audit:   "killswitches",
+			op: &openapi.Operation{
+				OperationID: "createKillSwitch",
+				Summary:     "Disable a provider in all served lists, optionally for a platform, country or ad type only",
+				Tags:        []string{"providers"},
+				RequestBody: openapi.JSONBody(killSwitchRequest, true),
+				Responses:   responses(http.StatusCreated, "created kill switch", endpoints.KillSwitchesResponse{}, 400, 401, 403, 415, 422, 503),
+				Security:    secured,
+			},
+		},
+		{
+			method:  http.MethodPost,
+			path:    "/killswitches/{id}/{action}",
+			handler: endpoints.KillSwitchAction,
+			admin:   true,
+			audit:   "killswitches",
+			op: &openapi.Operation{
+				OperationID: "updateKillSwitch",
+				Summary:     "Delete a kill switch, enabling its provider again",
+				Tags:        []string{"providers"},
+				Parameters: []*openapi.Parameter{
+					openapi.Path("id", openapi.String(), "id of the kill switch"),
+					openapi.Path("action", openapi.String().WithEnum("delete"), "action to perform"),
+				},
+				Responses: responses(http.StatusOK, "deleted kill switch", endpoints.KillSwitchesResponse{}, 401, 403, 404, 503),
+				Security:  secured,
+			},
+		},
+		{
+			method:  http.MethodGet,
+			path:    "/audit",
+			handler: endpoints.Audit,
+			admin:   true,
+			op: &openapi.Operation{
+				OperationID: "queryAudit",
+				Summary:     "Query the audit log, newest entries first",
+				Tags:        []string{"accounts"},
+				Parameters: []*openapi.Parameter{
+					openapi.Query("actor", openapi.String(), false, "exact actor"),
+					openapi.Query("action", openapi.String(), false, "exact action, e.g. update or users.disable"),
+					openapi.Query("since", openapi.DateTime(), false, "oldest entry time"),
+					openapi.Query("until", openapi.DateTime(), false, "newest entry time"),
+					openapi.Query("limit", openapi.Integer().Min(0), false, "maximum number of entries"),
+					openapi.Query("format", openapi.String().WithEnum("json", "jsonl"), false, "jsonl exports entries as json lines"),
+				},
+				Responses: responses(http.StatusOK, "audit entries", endpoints.AuditResponse{}, 400, 401, 403, 503),
+				Security:  secured,
+			},
+		},
+	}
+
+	for _, rt := range a.routes {
+		a.doc.Add(rt.method, rt.path, rt.op)
+	}
+
+	return a
+}
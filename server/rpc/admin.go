@@ -0,0 +1,197 @@
+package rpc
+
+import (
+	"context"
+	"expertisetest/audit"
+	"expertisetest/auth"
+	"expertisetest/server/apierror"
+	"expertisetest/server/endpoints"
+	"expertisetest/server/rpc/pb"
+)
+
+// admin serves the Admin service, mirroring /users, /apikeys and /audit.
+type admin struct {
+	pb.UnimplementedAdminServer
+}
+
+func (s *admin) ListUsers(ctx context.Context, in *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	if e := endpoints.Authorize(ctx, auth.PermAccounts); e != nil {
+		return nil, fail(e)
+	}
+
+	users, e := endpoints.ListUsers(ctx, logger(ctx))
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	out := &pb.ListUsersResponse{}
+	for _, u := range users {
+		out.Users = append(out.Users, fromUser(u))
+	}
+
+	return out, nil
+}
+
+func (s *admin) CreateUser(ctx context.Context, in *pb.CreateUserRequest) (*pb.User, error) {
+	if e := endpoints.Authorize(ctx, auth.PermAccounts); e != nil {
+		return nil, fail(e)
+	}
+
+	u, e := endpoints.CreateUser(ctx, logger(ctx), &endpoints.UserRequest{
+		Username: in.Username,
+		Password: in.Password,
+		Role:     in.Role,
+		Tenants:  in.Tenants,
+	})
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	return fromUser(u), nil
+}
+
+func (s *admin) DisableUser(ctx context.Context, in *pb.UserRequest) (*pb.User, error) {
+	return s.updateUser(ctx, in.Username, "disable")
+}
+
+func (s *admin) EnableUser(ctx context.Context, in *pb.UserRequest) (*pb.User, error) {
+	return s.updateUser(ctx, in.Username, "enable")
+}
+
+func (s *admin) updateUser(ctx context.Context, username, action string) (*pb.User, error) {
+	if e := endpoints.Authorize(ctx, auth.PermAccounts); e != nil {
+		return nil, fail(e)
+	}
+
+	setParam(ctx, "username", username)
+
+	u, _, e := endpoints.UpdateUser(ctx, logger(ctx), username, action, nil)
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	return fromUser(u), nil
+}
+
+func (s *admin) RotatePassword(ctx context.Context, in *pb.RotatePasswordRequest) (*pb.RotatePasswordResponse, error) {
+	if e := endpoints.Authorize(ctx, auth.PermAccounts); e != nil {
+		return nil, fail(e)
+	}
+
+	setParam(ctx, "username", in.Username)
+
+	_, pass, e := endpoints.UpdateUser(ctx, logger(ctx), in.Username, "rotate", &endpoints.PasswordRequest{Password: in.Password})
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	return &pb.RotatePasswordResponse{Password: pass}, nil
+}
+
+func (s *admin) ListAPIKeys(ctx context.Context, in *pb.ListAPIKeysRequest) (*pb.ListAPIKeysResponse, error) {
+	if e := endpoints.Authorize(ctx, auth.PermAccounts); e != nil {
+		return nil, fail(e)
+	}
+
+	keys, e := endpoints.ListAPIKeys(ctx, logger(ctx))
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	out := &pb.ListAPIKeysResponse{}
+	for _, k := range keys {
+		out.Keys = append(out.Keys, fromAPIKey(k))
+	}
+
+	return out, nil
+}
+
+func (s *admin) CreateAPIKey(ctx context.Context, in *pb.CreateAPIKeyRequest) (*pb.APIKeyResponse, error) {
+	if e := endpoints.Authorize(ctx, auth.PermAccounts); e != nil {
+		return nil, fail(e)
+	}
+
+	k, key, e := endpoints.CreateAPIKey(ctx, logger(ctx), &endpoints.APIKeyRequest{
+		App:       in.App,
+		Scopes:    in.Scopes,
+		Tenants:   in.Tenants,
+		ExpiresAt: toTime(in.ExpiresAt),
+	})
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	return &pb.APIKeyResponse{ApiKey: fromAPIKey(k), Key: key}, nil
+}
+
+func (s *admin) RevokeAPIKey(ctx context.Context, in *pb.APIKeyRequest) (*pb.APIKey, error) {
+	if e := endpoints.Authorize(ctx, auth.PermAccounts); e != nil {
+		return nil, fail(e)
+	}
+
+	setParam(ctx, "id", in.Id)
+
+	k, _, e := endpoints.UpdateAPIKey(ctx, logger(ctx), in.Id, "revoke", nil)
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	return fromAPIKey(k), nil
+}
+
+func (s *admin) RotateAPIKey(ctx context.Context, in *pb.RotateAPIKeyRequest) (*pb.APIKeyResponse, error) {
+	if e := endpoints.Authorize(ctx, auth.PermAccounts); e != nil {
+		return nil, fail(e)
+	}
+
+	setParam(ctx, "id", in.Id)
+
+	// Missing overlap falls back to the default, as an empty overlap on /apikeys/{id}/rotate.
+	rotate := &endpoints.RotateKeyRequest{}
+	if in.Overlap != nil {
+		rotate.Overlap = in.Overlap.AsDuration().String()
+	}
+
+	k, key, e := endpoints.UpdateAPIKey(ctx, logger(ctx), in.Id, "rotate", rotate)
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	return &pb.APIKeyResponse{ApiKey: fromAPIKey(k), Key: key}, nil
+}
+
+func (s *admin) QueryAudit(ctx context.Context, in *pb.QueryAuditRequest) (*pb.QueryAuditResponse, error) {
+	log := logger(ctx)
+	if e := endpoints.Authorize(ctx, auth.PermRead); e != nil {
+		return nil, fail(e)
+	}
+
+	if in.Limit < 0 {
+		return nil, fail(apierror.InvalidArgument("limit", "must be a non negative integer"))
+	}
+
+	entries, e := endpoints.QueryAudit(ctx, log, &audit.Filter{
+		Actor:  in.Actor,
+		Action: in.Action,
+		Since:  toTime(in.Since),
+		Until:  toTime(in.Until),
+		Limit:  int(in.Limit),
+	})
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	out := &pb.QueryAuditResponse{}
+	for _, e := range entries {
+		out.Entries = append(out.Entries, fromAuditEntry(e))
+	}
+
+	return out, nil
+}
+
+// sets the param of the audit entry in ctx, as url params are recorded by the http audit middleware.
+func setParam(ctx context.Context, key, value string) {
+	if entry := audit.FromContext(ctx); entry != nil {
+		entry.Params[key] = value
+	}
+}
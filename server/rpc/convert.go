@@ -0,0 +1,160 @@
+package rpc
+
+import (
+	"expertisetest/adnetwork"
+	"expertisetest/audit"
+	"expertisetest/auth"
+	"expertisetest/server/endpoints"
+	"expertisetest/server/rpc/pb"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// returns app values as given by the "app" url argument, an empty app selects the default tenant.
+func apps(app string) []string {
+	if app == "" {
+		return nil
+	}
+
+	return []string{app}
+}
+
+func toDeviceContext(dc *pb.DeviceContext) *endpoints.DeviceContext {
+	if dc == nil {
+		return nil
+	}
+
+	return &endpoints.DeviceContext{
+		CountryCode: dc.CountryCode,
+		Platform:    dc.Platform,
+		OsVersion:   dc.OsVersion,
+		Device:      dc.Device,
+		Types:       dc.Types,
+		Limit:       int(dc.Limit),
+	}
+}
+
+// converts the network, leaving lists of ad types that were not requested empty.
+func fromNetwork(n *endpoints.Network) *pb.AdNetwork {
+	if n == nil {
+		return nil
+	}
+
+	out := &pb.AdNetwork{Country: n.Country}
+	for _, adType := range n.Types() {
+		sdks := fromSDKs(n.List(adType))
+		switch adType {
+		case adnetwork.Banner:
+			out.Banner = sdks
+		case adnetwork.Interstitial:
+			out.Interstitial = sdks
+		case adnetwork.Video:
+			out.Video = sdks
+		}
+	}
+
+	return out
+}
+
+func fromSDKs(sdks []*adnetwork.SDK) []*pb.SDK {
+	out := make([]*pb.SDK, 0, len(sdks))
+	for _, sdk := range sdks {
+		out = append(out, &pb.SDK{Provider: sdk.Provider, Score: sdk.Score})
+	}
+
+	return out
+}
+
+func toNetworks(networks []*pb.AdNetwork) []*adnetwork.AdNetwork {
+	out := make([]*adnetwork.AdNetwork, 0, len(networks))
+	for _, n := range networks {
+		if n == nil {
+			continue
+		}
+
+		out = append(out, &adnetwork.AdNetwork{
+			Banner:       toSDKs(n.Banner),
+			Interstitial: toSDKs(n.Interstitial),
+			Video:        toSDKs(n.Video),
+			Country:      n.Country,
+		})
+	}
+
+	return out
+}
+
+func toSDKs(sdks []*pb.SDK) []*adnetwork.SDK {
+	out := make([]*adnetwork.SDK, 0, len(sdks))
+	for _, sdk := range sdks {
+		if sdk != nil {
+			out = append(out, &adnetwork.SDK{Provider: sdk.Provider, Score: sdk.Score})
+		}
+	}
+
+	return out
+}
+
+func fromUser(u *auth.User) *pb.User {
+	return &pb.User{
+		Username:  u.Username,
+		Role:      string(u.Role),
+		Tenants:   u.Tenants,
+		Disabled:  u.Disabled,
+		CreatedAt: timestamp(&u.CreatedAt),
+		RotatedAt: timestamp(&u.RotatedAt),
+	}
+}
+
+func fromAPIKey(k *auth.APIKey) *pb.APIKey {
+	scopes := make([]string, 0, len(k.Scopes))
+	for _, s := range k.Scopes {
+		scopes = append(scopes, string(s))
+	}
+
+	return &pb.APIKey{
+		Id:         k.ID,
+		App:        k.App,
+		Scopes:     scopes,
+		Tenants:    k.Tenants,
+		CreatedAt:  timestamp(&k.CreatedAt),
+		ExpiresAt:  timestamp(k.ExpiresAt),
+		LastUsedAt: timestamp(k.LastUsedAt),
+		Revoked:    k.Revoked,
+		RotatedTo:  k.RotatedTo,
+	}
+}
+
+func fromAuditEntry(e *audit.Entry) *pb.AuditEntry {
+	return &pb.AuditEntry{
+		Time:          timestamp(&e.Time),
+		RequestId:     e.RequestID,
+		Actor:         e.Actor,
+		Method:        e.Method,
+		Ip:            e.IP,
+		Action:        e.Action,
+		Params:        e.Params,
+		Status:        int32(e.Status),
+		VersionBefore: e.VersionBefore,
+		VersionAfter:  e.VersionAfter,
+		Countries:     int32(e.Countries),
+	}
+}
+
+// returns nil for missing or zero times.
+func timestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+
+	return timestamppb.New(*t)
+}
+
+// returns the zero time for missing timestamps.
+func toTime(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+
+	return ts.AsTime()
+}
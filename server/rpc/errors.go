@@ -0,0 +1,85 @@
+package rpc
+
+import (
+	"expertisetest/server/apierror"
+	"net/http"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// codes returned for http statuses of api errors.
+var statusCodes = map[int]codes.Code{
+	http.StatusBadRequest:           codes.InvalidArgument,
+	http.StatusUnauthorized:         codes.Unauthenticated,
+	http.StatusForbidden:            codes.PermissionDenied,
+	http.StatusNotFound:             codes.NotFound,
+	http.StatusMethodNotAllowed:     codes.Unimplemented,
+	http.StatusNotAcceptable:        codes.InvalidArgument,
+	http.StatusConflict:             codes.FailedPrecondition,
+	http.StatusUnsupportedMediaType: codes.InvalidArgument,
+	http.StatusUnprocessableEntity:  codes.InvalidArgument,
+	http.StatusTooManyRequests:      codes.ResourceExhausted,
+	http.StatusInternalServerError:  codes.Internal,
+	http.StatusServiceUnavailable:   codes.Unavailable,
+}
+
+// apiError carries an api error through grpc, keeping the http status for the audit log.
+type apiError struct {
+	e *apierror.Error
+}
+
+// fail returns e as a grpc error.
+func fail(e *apierror.Error) error {
+	return &apiError{e}
+}
+
+// Error satisfies error interface.
+func (e *apiError) Error() string {
+	return e.e.Error()
+}
+
+// GRPCStatus converts the error to a grpc status, field errors are sent as BadRequest details.
+func (e *apiError) GRPCStatus() *status.Status {
+	code, ok := statusCodes[e.e.Status]
+	if !ok {
+		code = codes.Unknown
+	}
+
+	s := status.New(code, e.e.Message)
+	if len(e.e.Details) == 0 {
+		return s
+	}
+
+	br := &errdetails.BadRequest{}
+	for _, d := range e.e.Details {
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: d.Field, Description: d.Reason})
+	}
+
+	if withDetails, err := s.WithDetails(br); err == nil {
+		return withDetails
+	}
+
+	return s
+}
+
+// httpStatus returns the http status recorded in the audit log for err.
+func httpStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+
+	if e, ok := err.(*apiError); ok {
+		return e.e.Status
+	}
+
+	code := status.Code(err)
+	for s, c := range statusCodes {
+		if c == code && (c != codes.InvalidArgument || s == http.StatusBadRequest) {
+			return s
+		}
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,208 @@
+package rpc
+
+import (
+	"context"
+	"expertisetest/audit"
+	"expertisetest/auth"
+	"expertisetest/config"
+	"expertisetest/server/apierror"
+	"expertisetest/server/middlewares"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// actions recorded in the audit log, by full method name.
+// Other methods do not change state and are not recorded.
+var actions = map[string]string{
+	"/expertisetest.v1.AdNetworks/Update":    "update",
+	"/expertisetest.v1.Admin/CreateUser":     "users",
+	"/expertisetest.v1.Admin/DisableUser":    "users.disable",
+	"/expertisetest.v1.Admin/EnableUser":     "users.enable",
+	"/expertisetest.v1.Admin/RotatePassword": "users.rotate",
+	"/expertisetest.v1.Admin/CreateAPIKey":   "apikeys",
+	"/expertisetest.v1.Admin/RevokeAPIKey":   "apikeys.revoke",
+	"/expertisetest.v1.Admin/RotateAPIKey":   "apikeys.rotate",
+}
+
+// rejects admin calls made without a verified client certificate, when TLSRequireAdminCert is set.
+// Admin calls are the ones served by admin http routes: dataset updates and the Admin service.
+func clientCertInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	admin := info.FullMethod == "/"+adNetworksService+"/Update" || strings.HasPrefix(info.FullMethod, "/"+adminService+"/")
+	if !admin || !config.GetInstance().TLSRequireAdminCert {
+		return handler(ctx, req)
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.VerifiedChains) > 0 {
+			return handler(ctx, req)
+		}
+	}
+
+	logger(ctx).Warn("missing client certificate")
+	return nil, fail(apierror.Forbidden("client certificate required"))
+}
+
+// recovers from panics, logs them and returns an internal error to the client.
+func recoverInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if rvr := recover(); rvr != nil {
+			logrus.WithFields(logrus.Fields{
+				"method": info.FullMethod,
+				"panic":  rvr,
+				"stack":  string(debug.Stack()),
+			}).Error("recovered from panic")
+
+			err = status.Error(codes.Internal, "internal error")
+		}
+	}()
+
+	return handler(ctx, req)
+}
+
+// wraps each call with a request id and a logger, as the http logger middleware does.
+// The request id is taken from x-request-id metadata, if given.
+func loggerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	s := time.Now()
+
+	requestID := first(ctx, "x-request-id")
+	if requestID == "" {
+		requestID = fmt.Sprintf("grpc-%06d", middleware.NextRequestID())
+	}
+
+	log := logrus.WithFields(logrus.Fields{
+		"request_id": requestID,
+		"method":     info.FullMethod,
+	})
+
+	log.WithField("remote_addr", remoteIP(ctx)).Debug("grpc request")
+
+	ctx = context.WithValue(ctx, middleware.RequestIDKey, requestID)
+	ctx = context.WithValue(ctx, config.LogKey, log)
+
+	resp, err := handler(ctx, req)
+
+	log.WithFields(logrus.Fields{
+		"elapsed": time.Since(s),
+		"code":    status.Code(err),
+	}).Info("grpc request processed")
+
+	return resp, err
+}
+
+// resolves the identity from authorization or x-api-key metadata, as the http authentication middleware does.
+// Failing credentials leave the call anonymous, services reject it if they require an identity.
+func authenticationInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	log, ok := ctx.Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+	}
+
+	if id := middlewares.Identify(log, first(ctx, "authorization"), first(ctx, "x-api-key")); id != nil {
+		ctx = context.WithValue(ctx, config.IdentityKey, id)
+	}
+
+	return handler(ctx, req)
+}
+
+// returns an interceptor limiting calls per peer ip and identity, as the http rate limit middleware does.
+// Limits are reported in response headers, must run after authenticationInterceptor.
+func rateLimitInterceptor(l *middlewares.RateLimiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		id, _ := ctx.Value(config.IdentityKey).(*auth.Identity)
+		strictest := l.Allow(logger(ctx), remoteIP(ctx), id)
+		if strictest == nil {
+			return handler(ctx, req)
+		}
+
+		md := metadata.Pairs(
+			"x-ratelimit-limit", strconv.Itoa(strictest.Limit),
+			"x-ratelimit-remaining", strconv.Itoa(strictest.Remaining),
+			"x-ratelimit-reset", strconv.Itoa(middlewares.CeilSeconds(strictest.Reset.Seconds())),
+		)
+
+		if !strictest.Allowed {
+			md.Set("retry-after", strconv.Itoa(middlewares.CeilSeconds(strictest.RetryAfter.Seconds())))
+		}
+		if err := grpc.SetHeader(ctx, md); err != nil {
+			logger(ctx).Error(errors.Wrap(err, "failed to set rate limit headers"))
+		}
+
+		if !strictest.Allowed {
+			logger(ctx).WithField("remote_addr", remoteIP(ctx)).Warn("rate limit exceeded")
+			return nil, fail(apierror.RateLimited())
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// records state changing calls in the audit log, as the http audit middleware does.
+func auditInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	action, ok := actions[info.FullMethod]
+	if !ok {
+		return handler(ctx, req)
+	}
+
+	e := &audit.Entry{
+		Time:      time.Now().UTC(),
+		RequestID: middleware.GetReqID(ctx),
+		Actor:     "anonymous",
+		IP:        remoteIP(ctx),
+		Action:    action,
+		Params:    map[string]string{},
+	}
+
+	if id, ok := ctx.Value(config.IdentityKey).(*auth.Identity); ok {
+		e.Actor, e.Method = id.Subject, id.Method
+	}
+
+	resp, err := handler(context.WithValue(ctx, config.AuditKey, e), req)
+
+	e.Status = httpStatus(err)
+	audit.Record(e)
+
+	return resp, err
+}
+
+// returns the first metadata value for key or an empty string.
+func first(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	if vals := md.Get(key); len(vals) > 0 {
+		return vals[0]
+	}
+
+	return ""
+}
+
+// returns the ip of the calling peer.
+func remoteIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
@@ -0,0 +1,121 @@
+package rpc
+
+import (
+	"context"
+	"expertisetest/audit"
+	"expertisetest/auth"
+	"expertisetest/config"
+	"expertisetest/handler"
+	"expertisetest/server/endpoints"
+	"expertisetest/server/rpc/pb"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// networks serves the AdNetworks service, mirroring /list, /list/batch and /update.
+type networks struct {
+	pb.UnimplementedAdNetworksServer
+}
+
+func (s *networks) List(ctx context.Context, in *pb.ListRequest) (*pb.ListResponse, error) {
+	log := logger(ctx)
+
+	h, e := endpoints.TenantHandler(ctx, log, auth.PermList, apps(in.App))
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	dc := toDeviceContext(in.Context)
+	if dc == nil {
+		dc = &endpoints.DeviceContext{}
+	}
+
+	vals := dc.Values()
+	if e = endpoints.ValidateList(vals); e != nil {
+		return nil, fail(e)
+	}
+
+	n, e := endpoints.ListNetwork(log, h, vals)
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	return &pb.ListResponse{Network: fromNetwork(n)}, nil
+}
+
+func (s *networks) ListBatch(ctx context.Context, in *pb.ListBatchRequest) (*pb.ListBatchResponse, error) {
+	log := logger(ctx)
+
+	h, e := endpoints.TenantHandler(ctx, log, auth.PermList, apps(in.App))
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	batch := &endpoints.BatchRequest{}
+	for _, dc := range in.Contexts {
+		batch.Contexts = append(batch.Contexts, toDeviceContext(dc))
+	}
+
+	if e = endpoints.ValidateBatch(batch); e != nil {
+		return nil, fail(e)
+	}
+
+	resolved, e := endpoints.ResolveBatch(log, h, batch)
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	out := &pb.ListBatchResponse{}
+	for _, r := range resolved.Results {
+		result := &pb.ListBatchResult{Network: fromNetwork(r.Network)}
+		if r.Err != nil {
+			result.Error = &pb.Error{Code: string(r.Err.Code), Message: r.Err.Message}
+		}
+		out.Results = append(out.Results, result)
+	}
+
+	return out, nil
+}
+
+func (s *networks) Update(ctx context.Context, in *pb.UpdateRequest) (*pb.UpdateResponse, error) {
+	log := logger(ctx)
+
+	if entry := audit.FromContext(ctx); entry != nil {
+		if in.App != "" {
+			entry.Params["app"] = in.App
+		}
+		if in.Wipe {
+			entry.Params["wipe"] = strconv.FormatBool(in.Wipe)
+		}
+	}
+
+	h, e := endpoints.TenantHandler(ctx, log, auth.PermUpdate, apps(in.App))
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	load := &handler.LoadObject{AdNetwork: toNetworks(in.Data)}
+	if e = endpoints.ValidateLoad(load); e != nil {
+		log.WithField("details", e.Details).Error("invalid body on update")
+		return nil, fail(e)
+	}
+
+	version, e := endpoints.StoreDataset(ctx, log, h, load, in.Wipe)
+	if e != nil {
+		return nil, fail(e)
+	}
+
+	return &pb.UpdateResponse{Version: version}, nil
+}
+
+// returns the logger set by the logger interceptor.
+func logger(ctx context.Context) *logrus.Entry {
+	log, ok := ctx.Value(config.LogKey).(*logrus.Entry)
+	if !ok {
+		log = logrus.NewEntry(logrus.New())
+		log.Error("failed to fetch logger")
+	}
+
+	return log
+}
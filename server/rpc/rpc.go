@@ -0,0 +1,126 @@
+// Package rpc serves the gRPC api, sharing handlers, authentication and audit log with the http api.
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative pb/adnetwork.proto
+
+import (
+	"context"
+	"crypto/tls"
+	"expertisetest/config"
+	"expertisetest/server/middlewares"
+	"expertisetest/server/rpc/pb"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// names of served services, reported by health checking.
+const (
+	adNetworksService = "expertisetest.v1.AdNetworks"
+	adminService      = "expertisetest.v1.Admin"
+)
+
+// Server serves the gRPC services and health checking.
+type Server struct {
+	server *grpc.Server
+	health *health.Server
+	config *config.Config
+	done   chan struct{}
+}
+
+// New returns a new Server, serving TLS if tlsConfig is set.
+// Calls are limited by limiter, shared with the http api, unless it is nil.
+func New(c *config.Config, tlsConfig *tls.Config, limiter *middlewares.RateLimiter) *Server {
+	interceptors := []grpc.UnaryServerInterceptor{
+		recoverInterceptor,
+		loggerInterceptor,
+		clientCertInterceptor,
+		authenticationInterceptor,
+	}
+	if limiter != nil {
+		interceptors = append(interceptors, rateLimitInterceptor(limiter))
+	}
+	interceptors = append(interceptors, auditInterceptor)
+
+	opts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(interceptors...)}
+
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	s := &Server{
+		server: grpc.NewServer(opts...),
+		health: health.NewServer(),
+		config: c,
+		done:   make(chan struct{}),
+	}
+
+	pb.RegisterAdNetworksServer(s.server, &networks{})
+	pb.RegisterAdminServer(s.server, &admin{})
+	healthpb.RegisterHealthServer(s.server, s.health)
+
+	return s
+}
+
+// Serve serves on the listener until Shutdown is called or the listener fails.
+// Health is reported from the availability of storage.
+func (s *Server) Serve(lis net.Listener) error {
+	go s.watchHealth()
+
+	return s.server.Serve(lis)
+}
+
+// Shutdown reports not serving and stops accepting calls, waiting for in-flight calls until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	close(s.done)
+	s.health.Shutdown()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return errors.Wrap(ctx.Err(), "failed to drain grpc calls")
+	}
+}
+
+// checks storage every GRPCHealthInterval until shutdown.
+func (s *Server) watchHealth() {
+	ticker := time.NewTicker(s.config.GRPCHealthInterval)
+	defer ticker.Stop()
+
+	for {
+		s.checkHealth()
+
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sets the status of all services, which all depend on storage.
+func (s *Server) checkHealth() {
+	status := healthpb.HealthCheckResponse_SERVING
+	if err := s.config.RedisClient.Ping().Err(); err != nil {
+		logrus.WithField("transport", "grpc").Error(errors.Wrap(err, "storage unavailable"))
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+
+	for _, service := range []string{"", adNetworksService, adminService} {
+		s.health.SetServingStatus(service, status)
+	}
+}
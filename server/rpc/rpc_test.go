@@ -0,0 +1,118 @@
+package rpc
+
+import (
+	"expertisetest/auth"
+	"expertisetest/server/apierror"
+	"expertisetest/server/rpc/pb"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		err      *apierror.Error
+		expected codes.Code
+	}{
+		{apierror.Unavailable(), codes.Unavailable},
+		{apierror.Forbidden("insufficient permissions"), codes.PermissionDenied},
+		{apierror.NotFound("user not found"), codes.NotFound},
+		{apierror.Conflict("user already exists"), codes.FailedPrecondition},
+		{apierror.InvalidArgument("limit", "must be a non negative integer"), codes.InvalidArgument},
+	}
+
+	for _, tt := range tests {
+		err := fail(tt.err)
+		if got := status.Code(err); got != tt.expected {
+			t.Errorf("%s: Got: %s Expected: %s", tt.err.Code, got, tt.expected)
+		}
+
+		if got := httpStatus(err); got != tt.err.Status {
+			t.Errorf("%s: Got status: %d Expected: %d", tt.err.Code, got, tt.err.Status)
+		}
+	}
+
+	if got := httpStatus(nil); got != http.StatusOK {
+		t.Errorf("Got: %d Expected: %d", got, http.StatusOK)
+	}
+
+	if got := httpStatus(status.Error(codes.PermissionDenied, "")); got != http.StatusForbidden {
+		t.Errorf("Got: %d Expected: %d", got, http.StatusForbidden)
+	}
+}
+
+func TestStatusDetails(t *testing.T) {
+	s := status.Convert(fail(apierror.ValidationFailed(apierror.FieldError{Field: "contexts[0].countryCode", Reason: "required"})))
+
+	if len(s.Details()) != 1 {
+		t.Fatalf("expected one detail, got %v", s.Details())
+	}
+
+	br, ok := s.Details()[0].(*errdetails.BadRequest)
+	if !ok || len(br.FieldViolations) != 1 || br.FieldViolations[0].Field != "contexts[0].countryCode" {
+		t.Errorf("expected field violation, got %v", s.Details()[0])
+	}
+}
+
+func TestConvertNetworks(t *testing.T) {
+	in := []*pb.AdNetwork{nil, {
+		Banner:  []*pb.SDK{{Provider: "AdMob", Score: 2}, nil},
+		Country: "US",
+	}}
+
+	networks := toNetworks(in)
+	if len(networks) != 1 || networks[0].Country != "US" || len(networks[0].Banner) != 1 {
+		t.Fatalf("unexpected networks %v", networks)
+	}
+
+	if networks[0].Banner[0].Provider != "AdMob" || networks[0].Banner[0].Score != 2 {
+		t.Errorf("unexpected sdk %v", networks[0].Banner[0])
+	}
+
+	if out := fromNetwork(nil); out != nil {
+		t.Errorf("expected nil, got %v", out)
+	}
+}
+
+func TestFromAPIKey(t *testing.T) {
+	created := time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+	k := fromAPIKey(&auth.APIKey{
+		ID:        "k1",
+		App:       "app",
+		Scopes:    []auth.Permission{auth.PermList},
+		CreatedAt: created,
+	})
+
+	if k.Id != "k1" || len(k.Scopes) != 1 || k.Scopes[0] != string(auth.PermList) {
+		t.Errorf("unexpected key %v", k)
+	}
+
+	if !toTime(k.CreatedAt).Equal(created) {
+		t.Errorf("Got: %v Expected: %v", toTime(k.CreatedAt), created)
+	}
+
+	if k.ExpiresAt != nil || k.LastUsedAt != nil {
+		t.Errorf("expected missing times to be nil, got %v %v", k.ExpiresAt, k.LastUsedAt)
+	}
+}
+
+func TestToDeviceContext(t *testing.T) {
+	dc := toDeviceContext(&pb.DeviceContext{CountryCode: "US", Types: []string{"video"}, Limit: 3})
+
+	vals := dc.Values()
+	if vals.Get("countryCode") != "US" || vals.Get("types") != "video" || vals.Get("limit") != "3" {
+		t.Errorf("unexpected values %v", vals)
+	}
+
+	if dc = toDeviceContext(nil); dc != nil {
+		t.Errorf("expected nil, got %v", dc)
+	}
+
+	if got := apps(""); got != nil {
+		t.Errorf("expected default tenant, got %v", got)
+	}
+}
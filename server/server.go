@@ -1,14 +1,22 @@
 package server
 
 import (
+	"context"
 	"expertisetest/config"
+	"expertisetest/geoip"
+	"expertisetest/handler"
+	"expertisetest/notify"
+	"expertisetest/server/apierror"
 	"expertisetest/server/endpoints"
 	"expertisetest/server/middlewares"
-	"fmt"
+	"expertisetest/server/rpc"
+	"expertisetest/webhook"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -19,57 +27,241 @@ import (
 type Server struct {
 	router chi.Router
 	config *config.Config
+	// limiter is shared with the gRPC api, nil when rate limiting is disabled.
+	limiter *middlewares.RateLimiter
 }
 
 // New returns a new Server.
 func New() *Server {
+	c := config.GetInstance()
 	s := chi.NewRouter()
 
+	// The GeoIP database is opened on start, so a missing file fails the start instead of requests.
+	geoip.GetInstance()
+
+	realIP, err := middlewares.NewRealIPMiddleware()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
 	mws := []func(http.Handler) http.Handler{
 		middleware.RequestID,
-		middleware.RealIP,
-		middleware.Recoverer,
+		realIP,
+		middlewares.RecovererMiddleware,
 		NewCORS(),
+		middlewares.CompressionMiddleware,
 		middlewares.LoggerMiddleware,
 		middlewares.AuthenticationMiddleware,
 	}
 
+	var limiter *middlewares.RateLimiter
+	if c.RateLimitEnabled {
+		if limiter, err = middlewares.NewRateLimiter(); err != nil {
+			logrus.Fatal(err)
+		}
+		mws = append(mws, middlewares.NewRateLimitMiddleware(limiter))
+	}
+
 	for _, mw := range mws {
 		s.Use(mw)
 	}
 
+	s.NotFound(apierror.NotFoundHandler)
+	s.MethodNotAllowed(apierror.MethodNotAllowedHandler)
+
 	s.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if _, err := w.Write([]byte("Hello World!")); err != nil {
-			w.WriteHeader(500)
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write([]byte(`{"message":"Hello World!"}`)); err != nil {
+			logrus.Error(err)
 		}
 	})
 
-	s.HandleFunc("/list", endpoints.List)
-	s.HandleFunc("/update", endpoints.Update)
+	v1 := newV1(c)
+	s.Route(v1.prefix, func(r chi.Router) {
+		r.Get("/openapi.json", v1.doc.Handler())
+		v1.mount(r, c)
+	})
+
+	// Document of the latest version.
+	s.Get("/openapi.json", v1.doc.Handler())
+
+	// Unversioned routes are kept for shipped clients and behave like v1.
+	s.Group(func(r chi.Router) {
+		r.Use(middlewares.NewDeprecationMiddleware(v1.prefix))
+		v1.mount(r, c)
+	})
 
 	return &Server{
-		router: s,
+		router:  s,
+		config:  c,
+		limiter: limiter,
 	}
 }
 
 // Serve serves the server. :P
-func (s *Server) Serve() {
-	errChan := make(chan error, 1)
-	defer close(errChan)
+// It blocks until the listener fails or a termination signal is received,
+// in which case in-flight requests are given the configured grace period to drain
+// before webhook deliveries are stopped and the storage client is closed.
+// The gRPC api is served alongside on GRPCAddr, unless it is empty.
+// It returns the exit code of the process, non zero if serving or shutting down failed.
+func (s *Server) Serve() int {
+	srv := &http.Server{
+		Addr:         s.config.HTTPAddr,
+		Handler:      s.router,
+		ReadTimeout:  s.config.HTTPReadTimeout,
+		WriteTimeout: s.config.HTTPWriteTimeout,
+		IdleTimeout:  s.config.HTTPIdleTimeout,
+	}
+
+	if s.config.TLSEnabled() {
+		tlsConfig, err := newTLSConfig(s.config)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"transport": "https", "state": "failed"}).Error(err)
+			return 1
+		}
+		srv.TLSConfig = tlsConfig
+	}
+
+	// Watches and subscriptions waiting for datasets end on shutdown instead of holding it up.
+	srv.RegisterOnShutdown(notify.GetInstance().Close)
+
+	// Kill switches expire without a change announcing them, each instance wakes its own watches and subscriptions.
+	go endpoints.ExpireSwitches(notify.GetInstance())
+
+	// Datasets stored before tenants were introduced belong to the default tenant.
+	if n, err := handler.GetInstance().MigrateLegacy(); err != nil {
+		logrus.WithField("type", "migrate").Error(err)
+	} else if n > 0 {
+		logrus.WithFields(logrus.Fields{"type": "migrate", "tenant": s.config.DefaultTenant, "countries": n}).Info("legacy dataset moved")
+	}
+
+	// Rules are loaded on start, so changed rules are announced by the first instance starting with them.
+	for _, tenant := range s.config.Tenants {
+		h, err := handler.ForTenant(tenant)
+		if err == nil {
+			err = webhook.RulesLoaded(tenant, h.RulesVersion())
+		}
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"type": "webhook", "tenant": tenant}).Error(err)
+		}
+	}
+
+	var lis net.Listener
+	if s.config.GRPCAddr != "" {
+		var err error
+		if lis, err = net.Listen("tcp", s.config.GRPCAddr); err != nil {
+			logrus.WithFields(logrus.Fields{"transport": "grpc", "state": "failed"}).Error(err)
+			return 1
+		}
+	}
+
+	dispatcher := webhook.NewDispatcher(s.config)
+	dispatcher.Start()
+
+	errChan := make(chan error, 2)
+
+	var grpcSrv *rpc.Server
+	if lis != nil {
+		grpcSrv = rpc.New(s.config, srv.TLSConfig, s.limiter)
+		go func() {
+			logrus.WithFields(logrus.Fields{
+				"transport": "grpc",
+				"state":     "listening",
+				"addr":      lis.Addr().String(),
+				"tls":       srv.TLSConfig != nil,
+			}).Info("grpc init")
+
+			if err := grpcSrv.Serve(lis); err != nil {
+				errChan <- err
+			}
+		}()
+	}
 
-	// Check for errors.
 	go func() {
-		c := make(chan os.Signal)
-		signal.Notify(c, syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGKILL, syscall.SIGQUIT)
-		errChan <- fmt.Errorf("%s", <-c)
+		transport := "http"
+		if srv.TLSConfig != nil {
+			transport = "https"
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"transport": transport,
+			"state":     "listening",
+			"addr":      srv.Addr,
+		}).Info("http init")
+
+		var err error
+		if srv.TLSConfig != nil {
+			// Certificates are served from TLSConfig.GetCertificate.
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+
+		if err != nil && err != http.ErrServerClosed {
+			errChan <- err
+		}
 	}()
 
-	// If errors log and exit
+	// SIGKILL cannot be trapped, so only listen for signals that can be handled.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGQUIT)
+	defer signal.Stop(sigChan)
+
+	exitCode := wait(srv, grpcSrv, s.config.ShutdownGrace, errChan, sigChan)
+
+	// Running deliveries finish before storage is closed, queued ones are left to other instances.
+	dispatcher.Stop()
+
+	if err := s.config.Close(); err != nil {
+		logrus.WithField("type", "storage").Error(err)
+		exitCode = 1
+	}
+
+	logrus.WithFields(logrus.Fields{"transport": "http", "state": "terminated"}).Info("http stopped")
+	return exitCode
+}
+
+// wait blocks until a listener fails or a signal is received, returning the exit code.
+// On signal in-flight requests of both transports are given the grace period to drain, grpcSrv may be nil.
+func wait(srv *http.Server, grpcSrv *rpc.Server, grace time.Duration, errChan <-chan error, sigChan <-chan os.Signal) int {
+	var sig os.Signal
+	select {
+	case err := <-errChan:
+		logrus.WithFields(logrus.Fields{"transport": "http", "state": "failed"}).Error(err)
+		return 1
+	case sig = <-sigChan:
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"transport": "http",
+		"state":     "draining",
+		"signal":    sig.String(),
+		"grace":     grace,
+	}).Info("http shutdown")
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	// Both transports drain within the same grace period.
+	grpcErr := make(chan error, 1)
 	go func() {
-		logrus.WithFields(logrus.Fields{"transport": "http", "state": "listening"}).Info("http init")
-		errChan <- http.ListenAndServe(":80", s.router)
+		if grpcSrv != nil {
+			grpcErr <- grpcSrv.Shutdown(ctx)
+			return
+		}
+		grpcErr <- nil
 	}()
 
-	logrus.WithFields(logrus.Fields{"transport": "http", "state": "terminated"}).Error(<-errChan)
-	os.Exit(1)
+	exitCode := 0
+	if err := srv.Shutdown(ctx); err != nil {
+		logrus.WithFields(logrus.Fields{"transport": "http", "state": "shutdown"}).Error(err)
+		exitCode = 1
+	}
+
+	if err := <-grpcErr; err != nil {
+		logrus.WithFields(logrus.Fields{"transport": "grpc", "state": "shutdown"}).Error(err)
+		exitCode = 1
+	}
+
+	return exitCode
 }
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWaitDrains(t *testing.T) {
+	tests := []struct {
+		name  string
+		grace time.Duration
+		// the request in flight on signal completes.
+		completed bool
+		exitCode  int
+	}{
+		{"within grace", time.Second, true, 0},
+		{"past grace", 50 * time.Millisecond, false, 1},
+	}
+
+	for _, tt := range tests {
+		started := make(chan struct{})
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			time.Sleep(300 * time.Millisecond)
+			_, _ = w.Write([]byte("done"))
+		})}
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() { _ = srv.Serve(lis) }()
+
+		body := make(chan string, 1)
+		go func() {
+			res, err := http.Get("http://" + lis.Addr().String())
+			if err != nil {
+				body <- ""
+				return
+			}
+			defer res.Body.Close()
+
+			b, _ := ioutil.ReadAll(res.Body)
+			body <- string(b)
+		}()
+
+		// The signal arrives while the request is served.
+		<-started
+		sigChan := make(chan os.Signal, 1)
+		sigChan <- syscall.SIGTERM
+
+		start := time.Now()
+		if got := wait(srv, nil, tt.grace, make(chan error), sigChan); got != tt.exitCode {
+			t.Errorf("%s: Got exit code: %d Expected: %d", tt.name, got, tt.exitCode)
+		}
+		if elapsed := time.Since(start); elapsed > tt.grace+100*time.Millisecond {
+			t.Errorf("%s: Got shutdown after %s Expected within grace %s", tt.name, elapsed, tt.grace)
+		}
+
+		// Requests past the grace period are cut off by closing the server.
+		if !tt.completed {
+			_ = srv.Close()
+		}
+		if got := <-body; (got == "done") != tt.completed {
+			t.Errorf("%s: Got body: %q Expected completed: %v", tt.name, got, tt.completed)
+		}
+	}
+}
+
+func TestWaitFails(t *testing.T) {
+	errChan := make(chan error, 1)
+	errChan <- errors.New("address already in use")
+
+	if got := wait(&http.Server{}, nil, time.Second, errChan, make(chan os.Signal)); got != 1 {
+		t.Errorf("Got exit code: %d Expected: 1", got)
+	}
+}
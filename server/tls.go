@@ -0,0 +1,152 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"expertisetest/config"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// certReloader keeps the served certificate in sync with the files on disk.
+// Files are checked at most once per interval, on handshake, so no background
+// goroutine is required.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func newCertReloader(certFile, keyFile string, interval time.Duration) (*certReloader, error) {
+	cr := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+	}
+
+	if err := cr.load(); err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// load reads the certificate pair from disk.
+func (cr *certReloader) load() error {
+	modTime, err := cr.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to load certificate pair")
+	}
+
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.modTime = modTime
+	cr.lastCheck = time.Now()
+	cr.mu.Unlock()
+
+	return nil
+}
+
+// latestModTime returns the most recent modification time of cert and key files.
+func (cr *certReloader) latestModTime() (time.Time, error) {
+	latest := time.Time{}
+	for _, file := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, errors.Wrapf(err, "failed to stat %q", file)
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// GetCertificate satisfies tls.Config GetCertificate callback.
+// If reloading fails the previously loaded certificate keeps being served.
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	cert, modTime, due := cr.cert, cr.modTime, time.Since(cr.lastCheck) >= cr.interval
+	cr.mu.RUnlock()
+
+	if !due {
+		return cert, nil
+	}
+
+	cr.mu.Lock()
+	cr.lastCheck = time.Now()
+	cr.mu.Unlock()
+
+	latest, err := cr.latestModTime()
+	if err != nil {
+		logrus.WithField("type", "tls").Error(err)
+		return cert, nil
+	}
+
+	if !latest.After(modTime) {
+		return cert, nil
+	}
+
+	if err := cr.load(); err != nil {
+		logrus.WithField("type", "tls").Error(errors.Wrap(err, "failed to reload certificate"))
+		return cert, nil
+	}
+
+	logrus.WithFields(logrus.Fields{"type": "tls", "file": cr.certFile}).Info("certificate reloaded")
+
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// newTLSConfig builds the TLS configuration for the http server.
+// HTTP/2 is negotiated through ALPN and client certificates are verified
+// against the configured CA if any are presented.
+func newTLSConfig(c *config.Config) (*tls.Config, error) {
+	cr, err := newCertReloader(c.TLSCertFile, c.TLSKeyFile, c.TLSReloadInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cr.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+
+	if c.TLSClientCAFile != "" {
+		b, err := ioutil.ReadFile(c.TLSClientCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read client ca")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.New("failed to parse client ca")
+		}
+
+		// Certificates are only verified here, requiring them is left
+		// to route level middleware so /list stays open to clients without one.
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"expertisetest/config"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writePair(t, certFile, keyFile, "old", time.Now().Add(-time.Hour))
+
+	interval := 100 * time.Millisecond
+	tlsConfig, err := newTLSConfig(&config.Config{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSReloadInterval: interval})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := handshake(t, tlsConfig, nil); got != "old" {
+		t.Fatalf("Got certificate: %q Expected: %q", got, "old")
+	}
+
+	// Files are only checked once the interval passed.
+	writePair(t, certFile, keyFile, "new", time.Now())
+	if got := handshake(t, tlsConfig, nil); got != "old" {
+		t.Errorf("Got certificate: %q Expected: %q before the interval passed", got, "old")
+	}
+
+	time.Sleep(interval)
+	if got := handshake(t, tlsConfig, nil); got != "new" {
+		t.Errorf("Got certificate: %q Expected: %q after the interval passed", got, "new")
+	}
+
+	// A broken pair keeps the previous certificate served.
+	if err = ioutil.WriteFile(certFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(certFile, time.Now().Add(time.Hour), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(interval)
+	if got := handshake(t, tlsConfig, nil); got != "new" {
+		t.Errorf("Got certificate: %q Expected: %q with a broken pair", got, "new")
+	}
+}
+
+func TestClientCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writePair(t, certFile, keyFile, "server", time.Now())
+
+	// The self signed client certificate is its own CA.
+	caFile, clientKeyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "client-key.pem")
+	writePair(t, caFile, clientKeyFile, "client", time.Now())
+
+	c := &config.Config{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSClientCAFile: caFile, TLSReloadInterval: time.Minute}
+	tlsConfig, err := newTLSConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tlsConfig.ClientAuth != tls.VerifyClientCertIfGiven {
+		t.Errorf("Got client auth: %v Expected: %v", tlsConfig.ClientAuth, tls.VerifyClientCertIfGiven)
+	}
+
+	client, err := tls.LoadX509KeyPair(caFile, clientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Certificates are verified if given, clients without one still connect.
+	for _, certs := range [][]tls.Certificate{nil, {client}} {
+		if got := handshake(t, tlsConfig, certs); got != "server" {
+			t.Errorf("Got certificate: %q Expected: %q with client certificates: %d", got, "server", len(certs))
+		}
+	}
+
+	c.TLSClientCAFile = keyFile
+	if _, err = newTLSConfig(c); err == nil {
+		t.Error("Got no error Expected a client ca without certificates to fail")
+	}
+}
+
+// writePair writes a self signed certificate for cn and its key, setting their modification time.
+func writePair(t *testing.T, certFile, keyFile, cn string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{certFile, keyFile} {
+		if err = os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// handshake connects a client presenting certs to a server using tlsConfig, returning the common name the server is served by.
+func handshake(t *testing.T, tlsConfig *tls.Config, certs []tls.Certificate) string {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	server := tls.Server(s, tlsConfig)
+	errs := make(chan error, 1)
+	go func() { errs <- server.Handshake() }()
+
+	client := tls.Client(c, &tls.Config{InsecureSkipVerify: true, Certificates: certs})
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	if certs != nil && len(server.ConnectionState().VerifiedChains) == 0 {
+		t.Error("Got no verified chains Expected the client certificate verified")
+	}
+
+	return client.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
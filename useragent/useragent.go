@@ -0,0 +1,200 @@
+// Package useragent derives the platform, operating system version and device type of a client
+// from its User-Agent and client hints headers, in the values /list expects.
+package useragent
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Platforms, lowercase as in postfilter rules.
+const (
+	Android  = "android"
+	IOS      = "ios"
+	TVOS     = "tvos"
+	Windows  = "windows"
+	MacOS    = "macos"
+	ChromeOS = "chromeos"
+	Linux    = "linux"
+)
+
+// Device types.
+const (
+	Phone   = "phone"
+	Tablet  = "tablet"
+	TV      = "tv"
+	Desktop = "desktop"
+)
+
+// Hints are the client hints headers used, browsers send them once asked for with Accept-CH.
+var Hints = []string{"Sec-CH-UA-Platform", "Sec-CH-UA-Platform-Version", "Sec-CH-UA-Mobile", "Sec-CH-UA-Form-Factors"}
+
+var (
+	android  = regexp.MustCompile(`Android (\d+)`)
+	apple    = regexp.MustCompile(`(iPhone|iPad|iPod|AppleTV)[^)]*? OS (\d+)[_.]`)
+	darwin   = regexp.MustCompile(`CFNetwork/.* Darwin/(\d+)`)
+	windowsN = regexp.MustCompile(`Windows NT (\d+)\.(\d+)`)
+	macOS    = regexp.MustCompile(`Mac OS X (\d+)`)
+	// Smart TVs and TV sticks, Fire TV models start with AFT.
+	tv = regexp.MustCompile(`(?i)smart-?tv|smart tv|googletv|android ?tv|; AFT|bravia|web0s|webos|tizen|roku|crkey|hbbtv|appletv`)
+)
+
+// Context holds the derived device context, values that could not be derived are empty.
+type Context struct {
+	Platform  string `json:"platform,omitempty"`
+	OsVersion string `json:"osVersion,omitempty"`
+	Device    string `json:"device,omitempty"`
+}
+
+// Parse derives the device context from the User-Agent and client hints in header.
+// Client hints are preferred, browsers reducing their User-Agent keep them accurate.
+func Parse(header http.Header) *Context {
+	c := FromUserAgent(header.Get("User-Agent"))
+	hints := FromHints(header)
+
+	if hints.Platform != "" {
+		// The version and device type of the User-Agent belong to its platform.
+		if hints.Platform != c.Platform {
+			c = &Context{}
+		}
+		c.Platform = hints.Platform
+	}
+	if hints.OsVersion != "" {
+		c.OsVersion = hints.OsVersion
+	}
+	if hints.Device != "" && c.Device != TV {
+		c.Device = hints.Device
+	}
+
+	return c
+}
+
+// FromUserAgent derives the device context from a browser or app User-Agent.
+func FromUserAgent(ua string) *Context {
+	c := &Context{}
+	if ua == "" {
+		return c
+	}
+
+	switch {
+	case android.MatchString(ua):
+		c.Platform, c.OsVersion = Android, android.FindStringSubmatch(ua)[1]
+		// Browsers mark phones as Mobile, app http clients (Dalvik, okhttp) do not tell phones from tablets.
+		switch {
+		case strings.HasPrefix(ua, "Dalvik/"):
+		case strings.Contains(ua, "Mobile"):
+			c.Device = Phone
+		default:
+			c.Device = Tablet
+		}
+	case apple.MatchString(ua):
+		m := apple.FindStringSubmatch(ua)
+		c.Platform, c.OsVersion = IOS, m[2]
+		switch m[1] {
+		case "iPad":
+			c.Device = Tablet
+		case "AppleTV":
+			c.Platform = TVOS
+		default:
+			c.Device = Phone
+		}
+	case darwin.MatchString(ua) && !strings.Contains(ua, "Macintosh"):
+		// App http clients on iOS report the Darwin kernel, iOS 8 and later are 6 major versions behind it.
+		if v, _ := strconv.Atoi(darwin.FindStringSubmatch(ua)[1]); v >= 14 {
+			c.Platform, c.OsVersion = IOS, strconv.Itoa(v-6)
+		}
+	case windowsN.MatchString(ua):
+		m := windowsN.FindStringSubmatch(ua)
+		c.Platform, c.OsVersion, c.Device = Windows, windowsVersion(m[1], m[2]), Desktop
+	case strings.Contains(ua, "CrOS"):
+		c.Platform, c.Device = ChromeOS, Desktop
+	case macOS.MatchString(ua):
+		c.Platform, c.OsVersion, c.Device = MacOS, macOS.FindStringSubmatch(ua)[1], Desktop
+	case strings.Contains(ua, "Linux") || strings.Contains(ua, "X11"):
+		c.Platform, c.Device = Linux, Desktop
+	}
+
+	if tv.MatchString(ua) {
+		c.Device = TV
+	}
+
+	return c
+}
+
+// FromHints derives the device context from client hints headers.
+func FromHints(header http.Header) *Context {
+	c := &Context{Platform: platform(unquote(header.Get("Sec-CH-UA-Platform")))}
+
+	if major, err := strconv.Atoi(strings.SplitN(unquote(header.Get("Sec-CH-UA-Platform-Version")), ".", 2)[0]); err == nil {
+		c.OsVersion = strconv.Itoa(major)
+		if c.Platform == Windows {
+			// Windows reports its platform version, 13 and later are Windows 11, 0 are versions before 10.
+			switch {
+			case major >= 13:
+				c.OsVersion = "11"
+			case major > 0:
+				c.OsVersion = "10"
+			default:
+				c.OsVersion = ""
+			}
+		}
+	}
+
+	for _, factor := range strings.Split(header.Get("Sec-CH-UA-Form-Factors"), ",") {
+		switch strings.ToLower(unquote(factor)) {
+		case "mobile":
+			c.Device = Phone
+		case "tablet":
+			c.Device = Tablet
+		case "desktop":
+			c.Device = Desktop
+		default:
+			continue
+		}
+		return c
+	}
+
+	switch header.Get("Sec-CH-UA-Mobile") {
+	case "?1":
+		c.Device = Phone
+	case "?0":
+		// Android browsers request mobile pages on phones only.
+		switch c.Platform {
+		case Android:
+			c.Device = Tablet
+		case Windows, MacOS, ChromeOS, Linux:
+			c.Device = Desktop
+		}
+	}
+
+	return c
+}
+
+// returns the platform of a Sec-CH-UA-Platform value.
+func platform(hint string) string {
+	switch p := strings.ToLower(strings.Replace(hint, " ", "", -1)); p {
+	case "", "unknown":
+		return ""
+	default:
+		return p
+	}
+}
+
+// returns the Windows version of an NT version.
+func windowsVersion(major, minor string) string {
+	switch major + "." + minor {
+	case "6.1":
+		return "7"
+	case "6.2", "6.3":
+		return "8"
+	default:
+		return major
+	}
+}
+
+// removes quotes and spaces around a structured header string.
+func unquote(s string) string {
+	return strings.Trim(strings.TrimSpace(s), `"`)
+}
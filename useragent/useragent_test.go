@@ -0,0 +1,94 @@
+package useragent
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestFromUserAgent(t *testing.T) {
+	tests := []struct {
+		ua       string
+		expected Context
+	}{
+		{"Mozilla/5.0 (Linux; Android 9; SM-G960F) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/80.0.3987.149 Mobile Safari/537.36", Context{Android, "9", Phone}},
+		{"Mozilla/5.0 (Linux; Android 8.1.0; SM-T580) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/80.0.3987.132 Safari/537.36", Context{Android, "8", Tablet}},
+		{"Dalvik/2.1.0 (Linux; U; Android 10; Pixel 3 Build/QQ1A.200205.002)", Context{Android, "10", ""}},
+		{"Mozilla/5.0 (Linux; Android 9; AFTMM Build/PS7233) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/70.0.3538.110 Mobile Safari/537.36", Context{Android, "9", TV}},
+		{"Mozilla/5.0 (Linux; Android 9; BRAVIA 4K GB) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/79.0.3945.136 Safari/537.36", Context{Android, "9", TV}},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 13_3_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.0.5 Mobile/15E148 Safari/604.1", Context{IOS, "13", Phone}},
+		{"Mozilla/5.0 (iPad; CPU OS 12_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148", Context{IOS, "12", Tablet}},
+		{"MyGame/2.3.1 CFNetwork/1121.2.2 Darwin/19.3.0", Context{IOS, "13", ""}},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/80.0.3987.149 Safari/537.36", Context{Windows, "10", Desktop}},
+		{"Mozilla/5.0 (Windows NT 6.1; Win64; x64; rv:74.0) Gecko/20100101 Firefox/74.0", Context{Windows, "7", Desktop}},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_3) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.0.5 Safari/605.1.15", Context{MacOS, "10", Desktop}},
+		{"Mozilla/5.0 (X11; CrOS x86_64 12871.102.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/81.0.4044.141 Safari/537.36", Context{ChromeOS, "", Desktop}},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:75.0) Gecko/20100101 Firefox/75.0", Context{Linux, "", Desktop}},
+		{"Mozilla/5.0 (SMART-TV; Linux; Tizen 5.0) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/2.2 Chrome/63.0.3239.84 TV Safari/537.36", Context{Linux, "", TV}},
+		{"curl/7.68.0", Context{}},
+		{"", Context{}},
+	}
+
+	for _, tt := range tests {
+		if got := FromUserAgent(tt.ua); !reflect.DeepEqual(*got, tt.expected) {
+			t.Errorf("%s: Got: %+v Expected: %+v", tt.ua, *got, tt.expected)
+		}
+	}
+}
+
+func TestFromHints(t *testing.T) {
+	tests := []struct {
+		hints    map[string]string
+		expected Context
+	}{
+		{map[string]string{"Sec-CH-UA-Platform": `"Android"`, "Sec-CH-UA-Platform-Version": `"13.0.0"`, "Sec-CH-UA-Mobile": "?1"}, Context{Android, "13", Phone}},
+		{map[string]string{"Sec-CH-UA-Platform": `"Android"`, "Sec-CH-UA-Mobile": "?0"}, Context{Android, "", Tablet}},
+		{map[string]string{"Sec-CH-UA-Platform": `"Windows"`, "Sec-CH-UA-Platform-Version": `"15.0.0"`, "Sec-CH-UA-Mobile": "?0"}, Context{Windows, "11", Desktop}},
+		{map[string]string{"Sec-CH-UA-Platform": `"Windows"`, "Sec-CH-UA-Platform-Version": `"10.0.0"`}, Context{Windows, "10", ""}},
+		{map[string]string{"Sec-CH-UA-Platform": `"Windows"`, "Sec-CH-UA-Platform-Version": `"0.3.0"`}, Context{Windows, "", ""}},
+		{map[string]string{"Sec-CH-UA-Platform": `"Chrome OS"`, "Sec-CH-UA-Mobile": "?0"}, Context{ChromeOS, "", Desktop}},
+		{map[string]string{"Sec-CH-UA-Platform": `"Android"`, "Sec-CH-UA-Mobile": "?0", "Sec-CH-UA-Form-Factors": `"EInk", "Tablet"`}, Context{Android, "", Tablet}},
+		{map[string]string{"Sec-CH-UA-Platform": `"Unknown"`, "Sec-CH-UA-Platform-Version": `""`}, Context{}},
+		{map[string]string{}, Context{}},
+	}
+
+	for _, tt := range tests {
+		header := http.Header{}
+		for k, v := range tt.hints {
+			header.Set(k, v)
+		}
+
+		if got := FromHints(header); !reflect.DeepEqual(*got, tt.expected) {
+			t.Errorf("%v: Got: %+v Expected: %+v", tt.hints, *got, tt.expected)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	// Reduced User-Agents freeze the Android version, client hints hold the real one.
+	reduced := "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36"
+
+	tests := []struct {
+		headers  map[string]string
+		expected Context
+	}{
+		{map[string]string{"User-Agent": reduced}, Context{Android, "10", Phone}},
+		{map[string]string{"User-Agent": reduced, "Sec-CH-UA-Platform": `"Android"`, "Sec-CH-UA-Platform-Version": `"14.0.0"`}, Context{Android, "14", Phone}},
+		// Versions and devices of a different platform are not mixed.
+		{map[string]string{"User-Agent": reduced, "Sec-CH-UA-Platform": `"Windows"`}, Context{Windows, "", ""}},
+		// TVs are only told apart by their User-Agent.
+		{map[string]string{"User-Agent": "Mozilla/5.0 (Linux; Android 9; BRAVIA 4K GB) Chrome/79.0.3945.136 Safari/537.36", "Sec-CH-UA-Platform": `"Android"`, "Sec-CH-UA-Mobile": "?0"}, Context{Android, "9", TV}},
+		{map[string]string{}, Context{}},
+	}
+
+	for _, tt := range tests {
+		header := http.Header{}
+		for k, v := range tt.headers {
+			header.Set(k, v)
+		}
+
+		if got := Parse(header); !reflect.DeepEqual(*got, tt.expected) {
+			t.Errorf("%v: Got: %+v Expected: %+v", tt.headers, *got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"expertisetest/config"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/pquerna/ffjson/ffjson"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// jobsKey holds queued deliveries, scored by the time they are due.
+	jobsKey = "webhooks:jobs"
+	// deliveriesKey holds the latest delivery attempts of a webhook, newest first.
+	deliveriesKey = "webhooks:deliveries:"
+	// deadLettersKey holds deliveries that failed every attempt, by delivery id.
+	deadLettersKey = "webhooks:deadletters"
+)
+
+const (
+	// idle workers look for due deliveries this often.
+	pollInterval = time.Second
+	// retries are never delayed longer.
+	maxBackoff = time.Hour
+	// response bodies are read up to this size, so connections can be reused.
+	maxResponseSize = 64 << 10
+	userAgent       = "expertisetest-webhooks/1.0"
+)
+
+// Outcomes of delivery attempts.
+const (
+	OutcomeDelivered = "delivered"
+	OutcomeRetrying  = "retrying"
+	OutcomeDead      = "dead"
+)
+
+// claim takes the first due delivery and pushes it back by a lease instead of removing it,
+// so it is delivered again if the instance dies while delivering it.
+var claim = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 1)
+if #due == 0 then
+	return false
+end
+redis.call('ZADD', KEYS[1], ARGV[2], due[1])
+return due[1]
+`)
+
+// job is a queued delivery of a payload to a webhook.
+type job struct {
+	Delivery string          `json:"delivery"`
+	Webhook  string          `json:"webhook"`
+	Event    string          `json:"event"`
+	Attempt  int             `json:"attempt"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// Attempt records a single delivery attempt.
+type Attempt struct {
+	Delivery string    `json:"delivery"`
+	Webhook  string    `json:"webhook"`
+	Event    string    `json:"event"`
+	Attempt  int       `json:"attempt"`
+	Time     time.Time `json:"time"`
+	Duration int64     `json:"durationMs"`
+	// Status is the http status answered by the webhook, zero if the request failed.
+	Status      int        `json:"status,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Outcome     string     `json:"outcome"`
+	NextAttempt *time.Time `json:"nextAttempt,omitempty"`
+}
+
+// ok returns true if the webhook accepted the delivery.
+func (a *Attempt) ok() bool {
+	return a.Error == "" && a.Status >= 200 && a.Status < 300
+}
+
+// DeadLetter is a delivery that failed every attempt, it can be redelivered.
+type DeadLetter struct {
+	Delivery    string          `json:"delivery"`
+	Webhook     string          `json:"webhook"`
+	Event       string          `json:"event"`
+	Payload     json.RawMessage `json:"payload"`
+	LastAttempt *Attempt        `json:"lastAttempt"`
+}
+
+// Deliveries returns the latest delivery attempts of the webhook, newest first.
+func Deliveries(id string, limit int) ([]*Attempt, error) {
+	if _, err := get(client(), id); err != nil {
+		return nil, err
+	}
+
+	values, err := client().LRange(deliveriesKey+id, 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch deliveries of %q", id)
+	}
+
+	out := []*Attempt{}
+	for _, value := range values {
+		a := &Attempt{}
+		if err = ffjson.Unmarshal([]byte(value), a); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal delivery attempt")
+		}
+		out = append(out, a)
+	}
+
+	return out, nil
+}
+
+// DeadLetters returns deliveries that failed every attempt, latest failures first.
+func DeadLetters(limit int) ([]*DeadLetter, error) {
+	m, err := client().HGetAll(deadLettersKey).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch dead letters")
+	}
+
+	out := []*DeadLetter{}
+	for id, b := range m {
+		dl := &DeadLetter{}
+		if err = ffjson.Unmarshal([]byte(b), dl); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal dead letter %q", id)
+		}
+		out = append(out, dl)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].LastAttempt.Time.After(out[j].LastAttempt.Time)
+	})
+
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+
+	return out, nil
+}
+
+// GetDeadLetter returns the dead letter of the delivery.
+func GetDeadLetter(delivery string) (*DeadLetter, error) {
+	return deadLetter(client(), delivery)
+}
+
+// Redeliver queues the dead letter again with a fresh set of attempts.
+func Redeliver(delivery string) (*DeadLetter, error) {
+	rd := client()
+
+	dl, err := deadLetter(rd, delivery)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = get(rd, dl.Webhook); err != nil {
+		return nil, err
+	}
+
+	// Only the caller removing the dead letter queues it, concurrent redeliveries are not duplicated.
+	removed, err := rd.HDel(deadLettersKey, delivery).Result()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to remove dead letter %q", delivery)
+	}
+	if removed == 0 {
+		return nil, ErrNotFound
+	}
+
+	j, err := ffjson.Marshal(&job{Delivery: dl.Delivery, Webhook: dl.Webhook, Event: dl.Event, Attempt: 1, Payload: dl.Payload})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal delivery")
+	}
+
+	return dl, errors.Wrapf(rd.ZAdd(jobsKey, redis.Z{Score: score(time.Now()), Member: j}).Err(), "failed to queue %q", delivery)
+}
+
+// Dispatcher delivers queued webhooks, every instance runs one and they share the queue.
+type Dispatcher struct {
+	client *redis.Client
+	http   *http.Client
+	config *config.Config
+	log    *logrus.Entry
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewDispatcher returns a Dispatcher delivering with settings of the Config.
+func NewDispatcher(c *config.Config) *Dispatcher {
+	return &Dispatcher{
+		client: c.MetaRedisClient,
+		http: &http.Client{
+			Timeout: c.WebhookTimeout,
+			// Redirects are failures, deliveries only go to the configured url.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		config: c,
+		log:    logrus.WithField("type", "webhook"),
+		stop:   make(chan struct{}),
+	}
+}
+
+// Start runs the configured number of workers.
+func (d *Dispatcher) Start() {
+	for i := 0; i < d.config.WebhookWorkers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+}
+
+// Stop stops workers from taking deliveries and waits for the running ones.
+func (d *Dispatcher) Stop() {
+	close(d.stop)
+	d.wg.Wait()
+}
+
+// delivers due deliveries one after another, waiting for more once none are due.
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+
+	for {
+		found, err := d.next()
+		if err != nil {
+			d.log.Error(err)
+		}
+
+		wait := pollInterval
+		if found {
+			wait = 0
+		}
+
+		select {
+		case <-d.stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// claims and delivers the first due delivery, false is returned if none is due.
+func (d *Dispatcher) next() (bool, error) {
+	now := time.Now()
+	// The lease outlasts the delivery request, so the delivery is not taken twice.
+	lease := now.Add(2 * d.config.WebhookTimeout)
+
+	member, err := claim.Run(d.client, []string{jobsKey}, score(now), score(lease)).String()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "failed to claim delivery")
+	}
+
+	j := &job{}
+	if err = ffjson.Unmarshal([]byte(member), j); err != nil {
+		d.client.ZRem(jobsKey, member)
+		return true, errors.Wrap(err, "failed to unmarshal delivery")
+	}
+
+	return true, d.process(member, j)
+}
+
+// delivers the job and records the attempt, scheduling a retry or moving it to dead letters on failure.
+func (d *Dispatcher) process(member string, j *job) error {
+	log := d.log.WithFields(logrus.Fields{"webhook": j.Webhook, "delivery": j.Delivery, "attempt": j.Attempt})
+
+	s, err := get(d.client, j.Webhook)
+	if err == ErrNotFound || (err == nil && !s.Active) {
+		log.Debug("webhook removed or disabled, delivery dropped")
+		return errors.Wrap(d.client.ZRem(jobsKey, member).Err(), "failed to drop delivery")
+	}
+	if err != nil {
+		// The delivery is taken again once its lease expires.
+		return err
+	}
+
+	a := d.deliver(s, j)
+
+	pipe := d.client.TxPipeline()
+	pipe.ZRem(jobsKey, member)
+
+	switch {
+	case a.ok():
+		a.Outcome = OutcomeDelivered
+	case j.Attempt < d.config.WebhookMaxAttempts:
+		a.Outcome = OutcomeRetrying
+		next := a.Time.Add(backoff(d.config.WebhookRetryBackoff, j.Attempt))
+		a.NextAttempt = &next
+
+		retry := *j
+		retry.Attempt++
+		b, err := ffjson.Marshal(&retry)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal delivery")
+		}
+		pipe.ZAdd(jobsKey, redis.Z{Score: score(next), Member: b})
+	default:
+		a.Outcome = OutcomeDead
+		b, err := ffjson.Marshal(&DeadLetter{Delivery: j.Delivery, Webhook: j.Webhook, Event: j.Event, Payload: j.Payload, LastAttempt: a})
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal dead letter")
+		}
+		pipe.HSet(deadLettersKey, j.Delivery, b)
+	}
+
+	b, err := ffjson.Marshal(a)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal delivery attempt")
+	}
+	pipe.LPush(deliveriesKey+j.Webhook, b)
+	pipe.LTrim(deliveriesKey+j.Webhook, 0, int64(d.config.WebhookHistorySize)-1)
+
+	if _, err = pipe.Exec(); err != nil {
+		return errors.Wrap(err, "failed to record delivery attempt")
+	}
+
+	entry := log.WithFields(logrus.Fields{"status": a.Status, "outcome": a.Outcome})
+	if a.Outcome == OutcomeDelivered {
+		entry.Debug("webhook delivered")
+	} else {
+		entry.WithField("error", a.Error).Warn("webhook delivery failed")
+	}
+
+	return nil
+}
+
+// posts the signed payload to the webhook.
+func (d *Dispatcher) deliver(s *Subscription, j *job) *Attempt {
+	a := &Attempt{Delivery: j.Delivery, Webhook: j.Webhook, Event: j.Event, Attempt: j.Attempt, Time: time.Now().UTC()}
+	defer func(start time.Time) {
+		a.Duration = int64(time.Since(start) / time.Millisecond)
+	}(time.Now())
+
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(j.Payload))
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+
+	timestamp := strconv.FormatInt(a.Time.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("X-Webhook-Id", j.Delivery)
+	req.Header.Set("X-Webhook-Event", j.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", sign(s.Secret, timestamp, j.Payload))
+
+	resp, err := d.http.Do(req)
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	defer resp.Body.Close()
+
+	if _, err = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseSize)); err != nil {
+		d.log.Debug(errors.Wrap(err, "failed to read webhook response"))
+	}
+
+	a.Status = resp.StatusCode
+	return a
+}
+
+// returns the delay before the attempt following the given one, doubling from base.
+func backoff(base time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > maxBackoff {
+		return maxBackoff
+	}
+
+	return delay
+}
+
+// returns the queue score of t, in milliseconds.
+func score(t time.Time) float64 {
+	return float64(t.UnixNano() / int64(time.Millisecond))
+}
+
+func deadLetter(rd *redis.Client, delivery string) (*DeadLetter, error) {
+	b, err := rd.HGet(deadLettersKey, delivery).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch dead letter %q", delivery)
+	}
+
+	dl := &DeadLetter{}
+	return dl, errors.Wrapf(ffjson.Unmarshal(b, dl), "failed to unmarshal dead letter %q", delivery)
+}
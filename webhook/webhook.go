@@ -0,0 +1,338 @@
+// Package webhook posts signed notifications of published datasets and rule changes to subscribed urls.
+// Subscriptions, queued deliveries and their history are kept in meta storage, shared by all instances,
+// so each notification is delivered by a single instance.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"expertisetest/config"
+	"expertisetest/notify"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+const (
+	// EventDatasetPublished is sent after every stored dataset.
+	EventDatasetPublished = "dataset.published"
+	// EventRulesChanged is sent when pre- or postfilter rules of a tenant differ from the ones loaded before.
+	EventRulesChanged = "rules.changed"
+)
+
+// Events lists all events webhooks can subscribe to.
+var Events = []string{EventDatasetPublished, EventRulesChanged}
+
+const (
+	// subscriptionsKey holds subscriptions by id.
+	subscriptionsKey = "webhooks"
+	// rulesKey holds the last loaded rules version of a tenant.
+	rulesKey = "webhooks:rules:"
+)
+
+// ErrNotFound is returned when webhook or dead letter does not exist.
+var ErrNotFound = errors.New("webhook not found")
+
+// ErrInvalidURL is returned when webhook url is not an absolute http or https url.
+var ErrInvalidURL = errors.New("invalid webhook url")
+
+// ErrInvalidEvent is returned when subscribing to an unknown event.
+var ErrInvalidEvent = errors.New("invalid event")
+
+// ErrInvalidTenant is returned when subscribing to a tenant not in Config.
+var ErrInvalidTenant = errors.New("invalid tenant")
+
+// Subscription posts events to an url.
+// The secret signing payloads is only returned once, on creation.
+type Subscription struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Events and tenants the webhook receives, empty means all of them.
+	Events    []string  `json:"events,omitempty"`
+	Tenants   []string  `json:"tenants,omitempty"`
+	Secret    string    `json:"secret,omitempty"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// matches returns true if the webhook receives the payload.
+func (s *Subscription) matches(p *Payload) bool {
+	return s.Active && (len(s.Events) == 0 || contains(s.Events, p.Event)) &&
+		(len(s.Tenants) == 0 || contains(s.Tenants, p.Tenant))
+}
+
+// Payload is the json body posted to webhooks.
+type Payload struct {
+	ID     string    `json:"id"`
+	Event  string    `json:"event"`
+	Tenant string    `json:"tenant"`
+	Time   time.Time `json:"time"`
+	// Published dataset, countries are the ones changed by the publish.
+	Version   int64        `json:"version,omitempty"`
+	Wiped     bool         `json:"wiped,omitempty"`
+	Countries []string     `json:"countries,omitempty"`
+	Diff      *notify.Diff `json:"diff,omitempty"`
+	// Changed rules, as versions identifying their content.
+	Rules         string `json:"rules,omitempty"`
+	PreviousRules string `json:"previousRules,omitempty"`
+}
+
+// Create stores a new active webhook, the returned subscription holds its secret.
+func Create(rawURL string, events, tenants []string) (*Subscription, error) {
+	if err := validate(rawURL, events, tenants); err != nil {
+		return nil, err
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := randomHex(24)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Subscription{
+		ID:        id,
+		URL:       rawURL,
+		Events:    events,
+		Tenants:   tenants,
+		Secret:    secret,
+		Active:    true,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	return s, put(client(), s)
+}
+
+// List returns all webhooks sorted by creation time.
+func List() ([]*Subscription, error) {
+	return list(client())
+}
+
+func list(rd *redis.Client) ([]*Subscription, error) {
+	m, err := rd.HGetAll(subscriptionsKey).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch webhooks")
+	}
+
+	out := []*Subscription{}
+	for id, b := range m {
+		s := &Subscription{}
+		if err = ffjson.Unmarshal([]byte(b), s); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal webhook %q", id)
+		}
+		out = append(out, s)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out, nil
+}
+
+// Get returns the webhook.
+func Get(id string) (*Subscription, error) {
+	return get(client(), id)
+}
+
+// SetActive enables or disables the webhook, disabled webhooks drop their queued deliveries.
+func SetActive(id string, active bool) (*Subscription, error) {
+	s, err := get(client(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Active = active
+	return s, put(client(), s)
+}
+
+// Delete removes the webhook together with its delivery history.
+func Delete(id string) (*Subscription, error) {
+	s, err := get(client(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := client().TxPipeline()
+	pipe.HDel(subscriptionsKey, id)
+	pipe.Del(deliveriesKey + id)
+	if _, err = pipe.Exec(); err != nil {
+		return nil, errors.Wrapf(err, "failed to delete webhook %q", id)
+	}
+
+	return s, nil
+}
+
+// DatasetPublished queues the event for webhooks receiving it.
+func DatasetPublished(e *notify.Event) error {
+	p := &Payload{
+		Event:     EventDatasetPublished,
+		Tenant:    e.Tenant,
+		Time:      e.Time,
+		Version:   e.Version,
+		Wiped:     e.Wiped,
+		Countries: e.Countries,
+		Diff:      e.Diff,
+	}
+
+	if e.Diff != nil {
+		p.Countries = e.Diff.Changed()
+	}
+
+	return enqueue(client(), p)
+}
+
+// RulesLoaded records the rules version loaded for the tenant and queues rules.changed if it differs from the last one.
+// Only the first instance loading new rules sees the difference, the first version ever loaded is not announced.
+func RulesLoaded(tenant, rules string) error {
+	previous, err := client().GetSet(rulesKey+tenant, rules).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to record rules version")
+	}
+
+	if previous == rules {
+		return nil
+	}
+
+	return enqueue(client(), &Payload{
+		Event:         EventRulesChanged,
+		Tenant:        tenant,
+		Time:          time.Now().UTC(),
+		Rules:         rules,
+		PreviousRules: previous,
+	})
+}
+
+// queues one delivery of the payload per webhook receiving it.
+func enqueue(rd *redis.Client, p *Payload) error {
+	subs, err := list(rd)
+	if err != nil {
+		return err
+	}
+
+	if p.ID, err = randomHex(8); err != nil {
+		return err
+	}
+
+	body, err := ffjson.Marshal(p)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal payload")
+	}
+
+	now := time.Now()
+	pipe := rd.TxPipeline()
+	queued := 0
+	for _, s := range subs {
+		if !s.matches(p) {
+			continue
+		}
+
+		j := &job{Webhook: s.ID, Event: p.Event, Attempt: 1, Payload: body}
+		if j.Delivery, err = randomHex(8); err != nil {
+			return err
+		}
+
+		b, err := ffjson.Marshal(j)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal delivery")
+		}
+
+		pipe.ZAdd(jobsKey, redis.Z{Score: score(now), Member: b})
+		queued++
+	}
+
+	if queued == 0 {
+		return nil
+	}
+
+	_, err = pipe.Exec()
+	return errors.Wrapf(err, "failed to queue %s deliveries", p.Event)
+}
+
+func get(rd *redis.Client, id string) (*Subscription, error) {
+	b, err := rd.HGet(subscriptionsKey, id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch webhook %q", id)
+	}
+
+	s := &Subscription{}
+	return s, errors.Wrapf(ffjson.Unmarshal(b, s), "failed to unmarshal webhook %q", id)
+}
+
+func put(rd *redis.Client, s *Subscription) error {
+	b, err := ffjson.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal webhook")
+	}
+
+	return errors.Wrapf(rd.HSet(subscriptionsKey, s.ID, b).Err(), "failed to store webhook %q", s.ID)
+}
+
+// validates subscription settings.
+func validate(rawURL string, events, tenants []string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidURL
+	}
+
+	for _, event := range events {
+		if !contains(Events, event) {
+			return ErrInvalidEvent
+		}
+	}
+
+	for _, tenant := range tenants {
+		if !config.GetInstance().ValidTenant(tenant) {
+			return ErrInvalidTenant
+		}
+	}
+
+	return nil
+}
+
+// sign returns the signature of the body sent at timestamp, as sent in the signature header.
+// The timestamp is signed as well, so receivers can reject replayed deliveries.
+func sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func client() *redis.Client {
+	return config.GetInstance().MetaRedisClient
+}
+
+func contains(arr []string, target string) bool {
+	for _, item := range arr {
+		if item == target {
+			return true
+		}
+	}
+
+	return false
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate random string")
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,109 @@
+package webhook
+
+import (
+	"expertisetest/config"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	config.OverrideInstance(config.NewTest())
+	config.GetInstance().DisableLogging()
+	os.Exit(m.Run())
+}
+
+func TestSign(t *testing.T) {
+	expected := "sha256=086f6aff7bd084c98679825129c5a64dbad88c760016d6d2c0fb123f27951d54"
+	if got := sign("secret", "1700000000", []byte(`{"id":"1"}`)); got != expected {
+		t.Errorf("Got: %s Expected: %s", got, expected)
+	}
+
+	// Signatures change with the timestamp, so they cannot be replayed later.
+	if sign("secret", "1700000001", []byte(`{"id":"1"}`)) == expected {
+		t.Error("expected signature to change with timestamp")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{4, 80 * time.Second},
+		{12, maxBackoff},
+		{100, maxBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := backoff(10*time.Second, tt.attempt); got != tt.expected {
+			t.Errorf("attempt %d: Got: %s Expected: %s", tt.attempt, got, tt.expected)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	published := &Payload{Event: EventDatasetPublished, Tenant: "default"}
+
+	tests := []struct {
+		s        *Subscription
+		expected bool
+	}{
+		{&Subscription{Active: true}, true},
+		{&Subscription{Active: false}, false},
+		{&Subscription{Active: true, Events: []string{EventRulesChanged}}, false},
+		{&Subscription{Active: true, Events: Events, Tenants: []string{"default"}}, true},
+		{&Subscription{Active: true, Tenants: []string{"other"}}, false},
+	}
+
+	for i, tt := range tests {
+		if got := tt.s.matches(published); got != tt.expected {
+			t.Errorf("%d: Got: %v Expected: %v", i, got, tt.expected)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tenant := config.GetInstance().DefaultTenant
+
+	tests := []struct {
+		url      string
+		events   []string
+		tenants  []string
+		expected error
+	}{
+		{"https://hooks.example.com/datasets", nil, nil, nil},
+		{"http://10.0.0.1:8080/hook", []string{EventRulesChanged}, []string{tenant}, nil},
+		{"ftp://hooks.example.com", nil, nil, ErrInvalidURL},
+		{"/relative", nil, nil, ErrInvalidURL},
+		{"https://hooks.example.com", []string{"dataset.deleted"}, nil, ErrInvalidEvent},
+		{"https://hooks.example.com", nil, []string{"unknown"}, ErrInvalidTenant},
+	}
+
+	for _, tt := range tests {
+		if got := validate(tt.url, tt.events, tt.tenants); got != tt.expected {
+			t.Errorf("%s: Got: %v Expected: %v", tt.url, got, tt.expected)
+		}
+	}
+}
+
+func TestAttemptOK(t *testing.T) {
+	tests := []struct {
+		a        *Attempt
+		expected bool
+	}{
+		{&Attempt{Status: 200}, true},
+		{&Attempt{Status: 204}, true},
+		{&Attempt{Status: 302}, false},
+		{&Attempt{Status: 500}, false},
+		{&Attempt{Error: "connection refused"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.a.ok(); got != tt.expected {
+			t.Errorf("%+v: Got: %v Expected: %v", tt.a, got, tt.expected)
+		}
+	}
+}